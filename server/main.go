@@ -16,13 +16,17 @@ import (
 	attachmentServer "github.com/web-stuff-98/psql-social/pkg/attachmentServer"
 	callServer "github.com/web-stuff-98/psql-social/pkg/callServer"
 	"github.com/web-stuff-98/psql-social/pkg/channelRTCserver"
+	contentFilter "github.com/web-stuff-98/psql-social/pkg/contentFilter"
 	"github.com/web-stuff-98/psql-social/pkg/db"
+	exportServer "github.com/web-stuff-98/psql-social/pkg/exportServer"
 	"github.com/web-stuff-98/psql-social/pkg/handlers"
 	mw "github.com/web-stuff-98/psql-social/pkg/handlers/middleware"
+	pollHelpers "github.com/web-stuff-98/psql-social/pkg/helpers/pollHelpers"
 	rdb "github.com/web-stuff-98/psql-social/pkg/redis"
 	socketLimiter "github.com/web-stuff-98/psql-social/pkg/socketLimiter"
 	socketMessages "github.com/web-stuff-98/psql-social/pkg/socketMessages"
 	"github.com/web-stuff-98/psql-social/pkg/socketServer"
+	webhookServer "github.com/web-stuff-98/psql-social/pkg/webhookServer"
 )
 
 func main() {
@@ -42,19 +46,9 @@ func main() {
 	cRTCs := channelRTCserver.Init(ss, db, cRTCsdc)
 	cs := callServer.Init(ss, csdc)
 	sl := socketLimiter.Init(rdb)
-
-	if _, err = db.Exec(context.Background(), `
-	DELETE FROM direct_messages;
-	DELETE FROM room_messages;
-	DELETE FROM invitations;
-	DELETE FROM friend_requests;
-	DELETE FROM friends;
-	DELETE FROM blocks;
-	DELETE FROM members;
-	DELETE FROM bans;
-	`); err != nil {
-		log.Fatalf("Error in delete statement:%v", err)
-	}
+	ws := webhookServer.Init(db)
+	cf := contentFilter.Init()
+	es := exportServer.Init(ss, db)
 
 	// wipe the db and redo the schema, because there may have been changes and I cannot connect
 	// to the database from pgadmin for some reason.
@@ -76,7 +70,12 @@ func main() {
 	go handleUserDeleteCancelDelete(&userDeleteList, udlcdc)
 	go handleUserDeleteListUserDisconnected(&userDeleteList, ss, db, udludc)
 
-	h := handlers.New(db, rdb, ss, cs, cRTCs, as, sl)
+	go watchPollDeadlines(ss, db)
+	go purgeExpiredMessages(ss, db)
+	go liftExpiredSanctions(ss, db)
+	go expireInvitations(ss, db)
+
+	h := handlers.New(db, rdb, ss, cs, cRTCs, as, sl, ws, cf, es)
 	app := fiber.New()
 
 	allowedOrigin := "http://localhost:5173,http://localhost:8080"
@@ -102,156 +101,714 @@ func main() {
 		MaxReqs:       10,
 		BlockDuration: time.Hour * 12,
 		Message:       "Too many requests",
-		RouteName:     "logout",
+		RouteName:     "logout",
+	}, rdb, db))
+	app.Post("/api/acc/register", mw.BasicRateLimiter(h.Register, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 20,
+		MaxReqs:       10,
+		BlockDuration: time.Hour * 12,
+		Message:       "Too many requests",
+		RouteName:     "register",
+	}, rdb, db))
+	app.Post("/api/acc/refresh", mw.BasicRateLimiter(h.Refresh, mw.SimpleLimiterOpts{
+		Window:        time.Second * 1,
+		MaxReqs:       10,
+		BlockDuration: time.Hour * 3,
+		Message:       "Too many requests",
+		RouteName:     "refresh",
+	}, rdb, db))
+	app.Post("/api/acc/bio", mw.BasicRateLimiter(h.UpdateBio, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       10,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "update-bio",
+	}, rdb, db))
+	app.Post("/api/acc/pfp", mw.BasicRateLimiter(h.UploadPfp, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 2,
+		MaxReqs:       10,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "upload-pfp",
+	}, rdb, db))
+	app.Get("/api/acc/uids", mw.BasicRateLimiter(h.GetConversees, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-conversees",
+	}, rdb, db))
+	app.Get("/api/acc/friends", mw.BasicRateLimiter(h.GetFriends, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-friends",
+	}, rdb, db))
+	app.Get("/api/acc/invitations", mw.BasicRateLimiter(h.GetPendingInvitations, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-pending-invitations",
+	}, rdb, db))
+	app.Get("/api/acc/blocked", mw.BasicRateLimiter(h.GetBlocked, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-blocked",
+	}, rdb, db))
+	app.Get("/api/acc/conv/:id", mw.BasicRateLimiter(h.GetConversation, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-conversation",
+	}, rdb, db))
+	app.Get("/api/acc/notifications", mw.BasicRateLimiter(h.GetNotifications, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-notifications",
+	}, rdb, db))
+
+	app.Post("/api/acc/bots", mw.BasicRateLimiter(h.CreateBot, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 20,
+		MaxReqs:       10,
+		BlockDuration: time.Hour * 1,
+		Message:       "Too many requests",
+		RouteName:     "create-bot",
+	}, rdb, db))
+	app.Get("/api/acc/bots", mw.BasicRateLimiter(h.GetBots, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-bots",
+	}, rdb, db))
+	app.Post("/api/acc/bots/:id/token", mw.BasicRateLimiter(h.RegenerateBotToken, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 20,
+		MaxReqs:       10,
+		BlockDuration: time.Hour * 1,
+		Message:       "Too many requests",
+		RouteName:     "regenerate-bot-token",
+	}, rdb, db))
+	app.Delete("/api/acc/bots/:id", mw.BasicRateLimiter(h.DeleteBot, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       20,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "delete-bot",
+	}, rdb, db))
+
+	app.Post("/api/acc/bookmarks", mw.BasicRateLimiter(h.CreateBookmark, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       60,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "create-bookmark",
+	}, rdb, db))
+	app.Get("/api/acc/bookmarks", mw.BasicRateLimiter(h.GetBookmarks, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-bookmarks",
+	}, rdb, db))
+	app.Patch("/api/acc/bookmark/:id", mw.BasicRateLimiter(h.UpdateBookmark, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       60,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "update-bookmark",
+	}, rdb, db))
+	app.Delete("/api/acc/bookmark/:id", mw.BasicRateLimiter(h.DeleteBookmark, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       60,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "delete-bookmark",
+	}, rdb, db))
+	app.Post("/api/acc/conv/:id/export", mw.BasicRateLimiter(h.CreateConversationExport, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       5,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "create-conversation-export",
+	}, rdb, db))
+	app.Get("/api/acc/exports", mw.BasicRateLimiter(h.GetExports, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       60,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-exports",
+	}, rdb, db))
+
+	app.Post("/api/room", mw.BasicRateLimiter(h.CreateRoom, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "create-room",
+	}, rdb, db))
+	app.Post("/api/room/:id/img", mw.BasicRateLimiter(h.UploadRoomImage, mw.SimpleLimiterOpts{
+		Window:        time.Second * 30,
+		MaxReqs:       900,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "upload-room-image",
+	}, rdb, db))
+	app.Get("/api/room/:id/img", mw.BasicRateLimiter(h.GetRoomImage, mw.SimpleLimiterOpts{
+		Window:        time.Second * 10,
+		MaxReqs:       900,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-room-image",
+	}, rdb, db))
+	app.Get("/api/rooms", mw.BasicRateLimiter(h.GetRooms, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-rooms",
+	}, rdb, db))
+	app.Patch("/api/room/:id", mw.BasicRateLimiter(h.UpdateRoom, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "update-room",
+	}, rdb, db))
+	app.Get("/api/room/:id", mw.BasicRateLimiter(h.GetRoom, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-room",
+	}, rdb, db))
+	app.Delete("/api/room/:id", mw.BasicRateLimiter(h.DeleteRoom, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "delete-room",
+	}, rdb, db))
+	app.Get("/api/room/channel/:id", mw.BasicRateLimiter(h.GetRoomChannel, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-room-channel",
+	}, rdb, db))
+	app.Patch("/api/room/channel/:id", mw.BasicRateLimiter(h.UpdateRoomChannel, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "update-room-channel",
+	}, rdb, db))
+	app.Delete("/api/room/channel/:id", mw.BasicRateLimiter(h.DeleteRoomChannel, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "delete-room-channel",
+	}, rdb, db))
+	app.Post("/api/room/:id/channels", mw.BasicRateLimiter(h.CreateRoomChannel, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "create-room-channel",
+	}, rdb, db))
+	app.Get("/api/room/channels/:id", mw.BasicRateLimiter(h.GetRoomChannels, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-room-channels",
+	}, rdb, db))
+	app.Put("/api/room/:id/channels/order", mw.BasicRateLimiter(h.UpdateChannelOrder, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       30,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "update-channel-order",
+	}, rdb, db))
+	app.Post("/api/room/:id/categories", mw.BasicRateLimiter(h.CreateChannelCategory, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       30,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "create-channel-category",
+	}, rdb, db))
+	app.Get("/api/room/:id/categories", mw.BasicRateLimiter(h.GetChannelCategories, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-channel-categories",
+	}, rdb, db))
+	app.Patch("/api/room/category/:id", mw.BasicRateLimiter(h.UpdateChannelCategory, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "update-channel-category",
+	}, rdb, db))
+	app.Delete("/api/room/category/:id", mw.BasicRateLimiter(h.DeleteChannelCategory, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "delete-channel-category",
+	}, rdb, db))
+	app.Post("/api/room/:id/roles", mw.BasicRateLimiter(h.CreateRoomRole, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       30,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "create-room-role",
+	}, rdb, db))
+	app.Get("/api/room/:id/roles", mw.BasicRateLimiter(h.GetRoomRoles, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-room-roles",
+	}, rdb, db))
+	app.Patch("/api/room/role/:id", mw.BasicRateLimiter(h.UpdateRoomRole, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "update-room-role",
+	}, rdb, db))
+	app.Delete("/api/room/role/:id", mw.BasicRateLimiter(h.DeleteRoomRole, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "delete-room-role",
+	}, rdb, db))
+	app.Put("/api/room/:id/members/:uid/roles/:role", mw.BasicRateLimiter(h.AssignRoomRole, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "assign-room-role",
+	}, rdb, db))
+	app.Delete("/api/room/:id/members/:uid/roles/:role", mw.BasicRateLimiter(h.AssignRoomRole, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "unassign-room-role",
+	}, rdb, db))
+	app.Get("/api/room/:id/members", mw.BasicRateLimiter(h.GetRoomMembers, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-room-members",
+	}, rdb, db))
+	app.Get("/api/room/:id/bans", mw.BasicRateLimiter(h.GetRoomSanctions, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       60,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-room-sanctions",
+	}, rdb, db))
+	app.Get("/api/room/:id/audit", mw.BasicRateLimiter(h.GetRoomAuditLog, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       60,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-room-audit-log",
+	}, rdb, db))
+	app.Put("/api/room/:id/owner", mw.BasicRateLimiter(h.TransferRoomOwnership, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       10,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "transfer-room-ownership",
+	}, rdb, db))
+	app.Put("/api/room/:id/succession", mw.BasicRateLimiter(h.UpdateRoomSuccession, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       30,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "update-room-succession",
+	}, rdb, db))
+	app.Post("/api/room/:id/join-requests", mw.BasicRateLimiter(h.CreateJoinRequest, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       5,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "create-join-request",
+	}, rdb, db))
+	app.Delete("/api/room/:id/join-requests", mw.BasicRateLimiter(h.DeleteJoinRequest, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       10,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "delete-join-request",
+	}, rdb, db))
+	app.Get("/api/room/:id/join-requests", mw.BasicRateLimiter(h.GetJoinRequests, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       60,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-join-requests",
+	}, rdb, db))
+	app.Put("/api/room/:id/join-requests/:uid", mw.BasicRateLimiter(h.RespondToJoinRequest, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       60,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "respond-to-join-request",
+	}, rdb, db))
+	app.Get("/api/room/:id/rules", mw.BasicRateLimiter(h.GetRoomRules, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-room-rules",
+	}, rdb, db))
+	app.Put("/api/room/:id/rules", mw.BasicRateLimiter(h.UpdateRoomRules, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       20,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "update-room-rules",
+	}, rdb, db))
+	app.Post("/api/room/:id/rules/accept", mw.BasicRateLimiter(h.AcceptRoomRules, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       20,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "accept-room-rules",
+	}, rdb, db))
+	app.Get("/api/room/:id/rules/answers", mw.BasicRateLimiter(h.GetRulesAcceptances, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       60,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-rules-acceptances",
+	}, rdb, db))
+	app.Post("/api/room/:id/invites", mw.BasicRateLimiter(h.CreateRoomInvite, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       10,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "create-room-invite",
+	}, rdb, db))
+	app.Get("/api/room/:id/invites", mw.BasicRateLimiter(h.GetRoomInvites, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       60,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-room-invites",
+	}, rdb, db))
+	app.Get("/api/invite/:code", mw.BasicRateLimiter(h.GetInvitePreview, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       60,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-invite-preview",
+	}, rdb, db))
+	app.Get("/api/invite/:code/img", mw.BasicRateLimiter(h.GetInviteImage, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       60,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-invite-image",
+	}, rdb, db))
+	app.Post("/api/invite/:code/join", mw.BasicRateLimiter(h.JoinRoomWithInvite, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       10,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "join-room-with-invite",
+	}, rdb, db))
+	app.Delete("/api/invite/:code", mw.BasicRateLimiter(h.RevokeRoomInvite, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       30,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "revoke-room-invite",
+	}, rdb, db))
+	app.Post("/api/room/:id/webhooks", mw.BasicRateLimiter(h.CreateRoomWebhook, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       10,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "create-room-webhook",
+	}, rdb, db))
+	app.Get("/api/room/:id/webhooks", mw.BasicRateLimiter(h.GetRoomWebhooks, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-room-webhooks",
+	}, rdb, db))
+	app.Patch("/api/room/webhook/:id", mw.BasicRateLimiter(h.UpdateRoomWebhook, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       30,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "update-room-webhook",
+	}, rdb, db))
+	app.Delete("/api/room/webhook/:id", mw.BasicRateLimiter(h.DeleteRoomWebhook, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       30,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "delete-room-webhook",
+	}, rdb, db))
+	app.Get("/api/room/webhook/:id/deliveries", mw.BasicRateLimiter(h.GetRoomWebhookDeliveries, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-room-webhook-deliveries",
+	}, rdb, db))
+	app.Post("/api/room/channel/:id/webhooks", mw.BasicRateLimiter(h.CreateChannelWebhook, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       10,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "create-channel-webhook",
+	}, rdb, db))
+	app.Get("/api/room/channel/:id/webhooks", mw.BasicRateLimiter(h.GetChannelWebhooks, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-channel-webhooks",
+	}, rdb, db))
+	app.Post("/api/room/channel/webhook/:id/token", mw.BasicRateLimiter(h.RotateChannelWebhook, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       10,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "rotate-channel-webhook",
+	}, rdb, db))
+	app.Delete("/api/room/channel/webhook/:id", mw.BasicRateLimiter(h.RevokeChannelWebhook, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       30,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "revoke-channel-webhook",
+	}, rdb, db))
+	app.Post("/api/room/channel/:id/export", mw.BasicRateLimiter(h.CreateChannelExport, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       5,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "create-channel-export",
+	}, rdb, db))
+	app.Put("/api/room/:id/retention", mw.BasicRateLimiter(h.UpdateRoomRetention, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       20,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "update-room-retention",
+	}, rdb, db))
+	app.Put("/api/room/channel/:id/retention", mw.BasicRateLimiter(h.UpdateRoomChannelRetention, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       20,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "update-room-channel-retention",
+	}, rdb, db))
+	app.Post("/api/room/channel/:id/follow", mw.BasicRateLimiter(h.FollowChannel, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       20,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "follow-channel",
+	}, rdb, db))
+	app.Delete("/api/room/channel/:id/follow/:target", mw.BasicRateLimiter(h.UnfollowChannel, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       20,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "unfollow-channel",
+	}, rdb, db))
+	app.Get("/api/room/channel/:id/overrides", mw.BasicRateLimiter(h.GetChannelOverrides, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-channel-overrides",
 	}, rdb, db))
-	app.Post("/api/acc/register", mw.BasicRateLimiter(h.Register, mw.SimpleLimiterOpts{
-		Window:        time.Minute * 20,
-		MaxReqs:       10,
-		BlockDuration: time.Hour * 12,
+	app.Put("/api/room/channel/:id/overrides/:target", mw.BasicRateLimiter(h.UpdateChannelOverride, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
 		Message:       "Too many requests",
-		RouteName:     "register",
+		RouteName:     "update-channel-override",
 	}, rdb, db))
-	app.Post("/api/acc/refresh", mw.BasicRateLimiter(h.Refresh, mw.SimpleLimiterOpts{
-		Window:        time.Second * 1,
-		MaxReqs:       10,
-		BlockDuration: time.Hour * 3,
+	app.Delete("/api/room/channel/:id/overrides/:target", mw.BasicRateLimiter(h.DeleteChannelOverride, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
 		Message:       "Too many requests",
-		RouteName:     "refresh",
+		RouteName:     "delete-channel-override",
 	}, rdb, db))
-	app.Post("/api/acc/bio", mw.BasicRateLimiter(h.UpdateBio, mw.SimpleLimiterOpts{
+	app.Get("/api/room/:id/filter", mw.BasicRateLimiter(h.GetRoomWordFilter, mw.SimpleLimiterOpts{
 		Window:        time.Minute * 1,
-		MaxReqs:       10,
+		MaxReqs:       90,
 		BlockDuration: time.Minute * 10,
 		Message:       "Too many requests",
-		RouteName:     "update-bio",
+		RouteName:     "get-room-word-filter",
 	}, rdb, db))
-	app.Post("/api/acc/pfp", mw.BasicRateLimiter(h.UploadPfp, mw.SimpleLimiterOpts{
-		Window:        time.Minute * 2,
-		MaxReqs:       10,
+	app.Put("/api/room/:id/filter", mw.BasicRateLimiter(h.UpdateRoomWordFilter, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       20,
 		BlockDuration: time.Minute * 10,
 		Message:       "Too many requests",
-		RouteName:     "upload-pfp",
+		RouteName:     "update-room-word-filter",
 	}, rdb, db))
-	app.Get("/api/acc/uids", mw.BasicRateLimiter(h.GetConversees, mw.SimpleLimiterOpts{
+	app.Get("/api/room/:id/filter/matches", mw.BasicRateLimiter(h.GetRoomFilterMatches, mw.SimpleLimiterOpts{
 		Window:        time.Minute * 1,
 		MaxReqs:       90,
 		BlockDuration: time.Minute * 10,
 		Message:       "Too many requests",
-		RouteName:     "get-conversees",
+		RouteName:     "get-room-filter-matches",
 	}, rdb, db))
-	app.Get("/api/acc/friends", mw.BasicRateLimiter(h.GetFriends, mw.SimpleLimiterOpts{
+
+	app.Get("/api/filter/matches", mw.BasicRateLimiter(mw.AdminOnly(h.GetFilterMatches, rdb, db), mw.SimpleLimiterOpts{
 		Window:        time.Minute * 1,
 		MaxReqs:       90,
 		BlockDuration: time.Minute * 10,
 		Message:       "Too many requests",
-		RouteName:     "get-friends",
+		RouteName:     "get-filter-matches",
 	}, rdb, db))
-	app.Get("/api/acc/blocked", mw.BasicRateLimiter(h.GetBlocked, mw.SimpleLimiterOpts{
+
+	app.Post("/api/reports", mw.BasicRateLimiter(h.CreateReport, mw.SimpleLimiterOpts{
 		Window:        time.Minute * 1,
-		MaxReqs:       90,
+		MaxReqs:       5,
 		BlockDuration: time.Minute * 10,
 		Message:       "Too many requests",
-		RouteName:     "get-blocked",
+		RouteName:     "create-report",
 	}, rdb, db))
-	app.Get("/api/acc/conv/:id", mw.BasicRateLimiter(h.GetConversation, mw.SimpleLimiterOpts{
+
+	app.Get("/api/admin/reports", mw.BasicRateLimiter(mw.AdminOnly(h.GetReports, rdb, db), mw.SimpleLimiterOpts{
 		Window:        time.Minute * 1,
 		MaxReqs:       90,
 		BlockDuration: time.Minute * 10,
 		Message:       "Too many requests",
-		RouteName:     "get-conversation",
+		RouteName:     "get-reports",
 	}, rdb, db))
-	app.Get("/api/acc/notifications", mw.BasicRateLimiter(h.GetNotifications, mw.SimpleLimiterOpts{
+
+	app.Put("/api/admin/reports/:id/claim", mw.BasicRateLimiter(mw.AdminOnly(h.ClaimReport, rdb, db), mw.SimpleLimiterOpts{
 		Window:        time.Minute * 1,
-		MaxReqs:       90,
+		MaxReqs:       60,
 		BlockDuration: time.Minute * 10,
 		Message:       "Too many requests",
-		RouteName:     "get-notifications",
+		RouteName:     "claim-report",
 	}, rdb, db))
 
-	app.Post("/api/room", mw.BasicRateLimiter(h.CreateRoom, mw.SimpleLimiterOpts{
+	app.Delete("/api/admin/reports/:id/claim", mw.BasicRateLimiter(mw.AdminOnly(h.ClaimReport, rdb, db), mw.SimpleLimiterOpts{
 		Window:        time.Minute * 1,
-		MaxReqs:       90,
+		MaxReqs:       60,
 		BlockDuration: time.Minute * 10,
 		Message:       "Too many requests",
-		RouteName:     "create-room",
+		RouteName:     "release-report",
 	}, rdb, db))
-	app.Post("/api/room/:id/img", mw.BasicRateLimiter(h.UploadRoomImage, mw.SimpleLimiterOpts{
-		Window:        time.Second * 30,
-		MaxReqs:       900,
+
+	app.Put("/api/admin/reports/:id/resolve", mw.BasicRateLimiter(mw.AdminOnly(h.ResolveReport, rdb, db), mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       60,
 		BlockDuration: time.Minute * 10,
 		Message:       "Too many requests",
-		RouteName:     "upload-room-image",
+		RouteName:     "resolve-report",
 	}, rdb, db))
-	app.Get("/api/room/:id/img", mw.BasicRateLimiter(h.GetRoomImage, mw.SimpleLimiterOpts{
-		Window:        time.Second * 10,
-		MaxReqs:       900,
+
+	app.Put("/api/admin/reports/:id/dismiss", mw.BasicRateLimiter(mw.AdminOnly(h.DismissReport, rdb, db), mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       60,
 		BlockDuration: time.Minute * 10,
 		Message:       "Too many requests",
-		RouteName:     "get-room-image",
+		RouteName:     "dismiss-report",
 	}, rdb, db))
-	app.Get("/api/rooms", mw.BasicRateLimiter(h.GetRooms, mw.SimpleLimiterOpts{
+
+	app.Get("/api/admin/users", mw.BasicRateLimiter(mw.AdminOnly(h.GetAdminUsers, rdb, db), mw.SimpleLimiterOpts{
 		Window:        time.Minute * 1,
 		MaxReqs:       90,
 		BlockDuration: time.Minute * 10,
 		Message:       "Too many requests",
-		RouteName:     "get-rooms",
+		RouteName:     "get-admin-users",
 	}, rdb, db))
-	app.Patch("/api/room/:id", mw.BasicRateLimiter(h.UpdateRoom, mw.SimpleLimiterOpts{
+
+	app.Get("/api/admin/users/:id", mw.BasicRateLimiter(mw.AdminOnly(h.GetAdminUser, rdb, db), mw.SimpleLimiterOpts{
 		Window:        time.Minute * 1,
 		MaxReqs:       90,
 		BlockDuration: time.Minute * 10,
 		Message:       "Too many requests",
-		RouteName:     "update-room",
+		RouteName:     "get-admin-user",
 	}, rdb, db))
-	app.Get("/api/room/:id", mw.BasicRateLimiter(h.GetRoom, mw.SimpleLimiterOpts{
+
+	app.Put("/api/admin/users/:id/suspend", mw.BasicRateLimiter(mw.AdminOnly(h.SuspendUser, rdb, db), mw.SimpleLimiterOpts{
 		Window:        time.Minute * 1,
-		MaxReqs:       90,
+		MaxReqs:       60,
 		BlockDuration: time.Minute * 10,
 		Message:       "Too many requests",
-		RouteName:     "get-room",
+		RouteName:     "suspend-user",
 	}, rdb, db))
-	app.Delete("/api/room/:id", mw.BasicRateLimiter(h.DeleteRoom, mw.SimpleLimiterOpts{
+
+	app.Delete("/api/admin/users/:id/suspend", mw.BasicRateLimiter(mw.AdminOnly(h.UnsuspendUser, rdb, db), mw.SimpleLimiterOpts{
 		Window:        time.Minute * 1,
-		MaxReqs:       90,
+		MaxReqs:       60,
 		BlockDuration: time.Minute * 10,
 		Message:       "Too many requests",
-		RouteName:     "delete-room",
+		RouteName:     "unsuspend-user",
 	}, rdb, db))
-	app.Get("/api/room/channel/:id", mw.BasicRateLimiter(h.GetRoomChannel, mw.SimpleLimiterOpts{
+
+	app.Delete("/api/admin/users/:id/sessions", mw.BasicRateLimiter(mw.AdminOnly(h.LogoutUser, rdb, db), mw.SimpleLimiterOpts{
 		Window:        time.Minute * 1,
-		MaxReqs:       90,
+		MaxReqs:       60,
 		BlockDuration: time.Minute * 10,
 		Message:       "Too many requests",
-		RouteName:     "get-room-channel",
+		RouteName:     "logout-user",
 	}, rdb, db))
-	app.Patch("/api/room/channel/:id", mw.BasicRateLimiter(h.UpdateRoomChannel, mw.SimpleLimiterOpts{
+
+	app.Get("/api/admin/rooms", mw.BasicRateLimiter(mw.AdminOnly(h.GetAdminRooms, rdb, db), mw.SimpleLimiterOpts{
 		Window:        time.Minute * 1,
 		MaxReqs:       90,
 		BlockDuration: time.Minute * 10,
 		Message:       "Too many requests",
-		RouteName:     "update-room-channel",
+		RouteName:     "get-admin-rooms",
 	}, rdb, db))
-	app.Delete("/api/room/channel/:id", mw.BasicRateLimiter(h.DeleteRoomChannel, mw.SimpleLimiterOpts{
+
+	app.Delete("/api/admin/rooms/:id", mw.BasicRateLimiter(mw.AdminOnly(h.AdminDeleteRoom, rdb, db), mw.SimpleLimiterOpts{
 		Window:        time.Minute * 1,
-		MaxReqs:       90,
+		MaxReqs:       60,
 		BlockDuration: time.Minute * 10,
 		Message:       "Too many requests",
-		RouteName:     "delete-room-channel",
+		RouteName:     "admin-delete-room",
 	}, rdb, db))
-	app.Post("/api/room/:id/channels", mw.BasicRateLimiter(h.CreateRoomChannel, mw.SimpleLimiterOpts{
+
+	app.Post("/api/admin/announcements", mw.BasicRateLimiter(mw.AdminOnly(h.SendSystemAnnouncement, rdb, db), mw.SimpleLimiterOpts{
 		Window:        time.Minute * 1,
-		MaxReqs:       90,
+		MaxReqs:       5,
 		BlockDuration: time.Minute * 10,
 		Message:       "Too many requests",
-		RouteName:     "create-room-channel",
+		RouteName:     "send-system-announcement",
 	}, rdb, db))
-	app.Get("/api/room/channels/:id", mw.BasicRateLimiter(h.GetRoomChannels, mw.SimpleLimiterOpts{
+
+	app.Get("/api/export/:id", mw.BasicRateLimiter(h.DownloadExport, mw.SimpleLimiterOpts{
 		Window:        time.Minute * 1,
-		MaxReqs:       90,
+		MaxReqs:       10,
 		BlockDuration: time.Minute * 10,
 		Message:       "Too many requests",
-		RouteName:     "get-room-channels",
+		RouteName:     "download-export",
 	}, rdb, db))
+
 	app.Post("/api/rooms/search", mw.BasicRateLimiter(h.SearchRooms, mw.SimpleLimiterOpts{
 		Window:        time.Minute * 1,
 		MaxReqs:       90,
@@ -267,6 +824,56 @@ func main() {
 		RouteName:     "rooms-page",
 	}, rdb, db))
 
+	app.Post("/api/group", mw.BasicRateLimiter(h.CreateGroup, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       20,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "create-group",
+	}, rdb, db))
+	app.Get("/api/groups", mw.BasicRateLimiter(h.GetGroups, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-groups",
+	}, rdb, db))
+	app.Get("/api/group/:id", mw.BasicRateLimiter(h.GetGroup, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-group",
+	}, rdb, db))
+	app.Patch("/api/group/:id", mw.BasicRateLimiter(h.UpdateGroup, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       20,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "update-group",
+	}, rdb, db))
+	app.Get("/api/group/:id/messages", mw.BasicRateLimiter(h.GetGroupMessages, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-group-messages",
+	}, rdb, db))
+	app.Post("/api/group/:id/img", mw.BasicRateLimiter(h.UploadGroupImage, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       20,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "upload-group-img",
+	}, rdb, db))
+	app.Get("/api/group/:id/img", mw.BasicRateLimiter(h.GetGroupImage, mw.SimpleLimiterOpts{
+		Window:        time.Minute * 1,
+		MaxReqs:       90,
+		BlockDuration: time.Minute * 10,
+		Message:       "Too many requests",
+		RouteName:     "get-group-img",
+	}, rdb, db))
+
 	app.Get("/api/user/bio/:id", mw.BasicRateLimiter(h.GetUserBio, mw.SimpleLimiterOpts{
 		Window:        time.Minute * 1,
 		MaxReqs:       90,
@@ -332,6 +939,14 @@ func main() {
 		RouteName:     "get-attachment-video-chunks",
 	}, rdb, db))
 
+	app.Post("/api/webhook/:id/:token", mw.BasicRateLimiter(h.IncomingChannelWebhook, mw.SimpleLimiterOpts{
+		Window:        time.Second * 10,
+		MaxReqs:       10,
+		BlockDuration: time.Minute * 5,
+		Message:       "Too many requests",
+		RouteName:     "incoming-webhook",
+	}, rdb, db))
+
 	app.Use("/api/ws", h.WebSocketAuth)
 	app.Get("/api/ws", h.WebSocketHandler())
 
@@ -353,6 +968,197 @@ func main() {
 	log.Fatalln(app.Listen(":" + os.Getenv("PORT")))
 }
 
+// Closes polls after their closing time has passed and sends out the final tally
+func watchPollDeadlines(ss *socketServer.SocketServer, db *pgxpool.Pool) {
+	for {
+		time.Sleep(time.Second * 10)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+
+		conn, err := db.Acquire(ctx)
+		if err != nil {
+			log.Printf("Error acquiring connection in poll deadline loop:%v\n", err)
+			cancel()
+			continue
+		}
+
+		closed := make(map[string]string)
+		if rows, err := conn.Query(ctx, `
+		UPDATE polls SET closed = TRUE
+		FROM room_messages
+		WHERE room_messages.id = polls.message_id AND polls.closed = FALSE AND polls.closes_at <= NOW()
+		RETURNING polls.id,room_messages.room_channel_id;
+		`); err != nil {
+			log.Printf("Error closing polls in poll deadline loop:%v\n", err)
+		} else {
+			for rows.Next() {
+				var id, channel_id string
+				if err = rows.Scan(&id, &channel_id); err != nil {
+					log.Printf("Error scanning poll in poll deadline loop:%v\n", err)
+					break
+				}
+				closed[id] = channel_id
+			}
+			rows.Close()
+		}
+
+		for id, channel_id := range closed {
+			poll, err := pollHelpers.GetPollTally(conn, ctx, id)
+			if err != nil {
+				log.Printf("Error getting poll tally in poll deadline loop:%v\n", err)
+				continue
+			}
+			ss.SendDataToSub <- socketServer.SubscriptionMessageData{
+				SubName:     fmt.Sprintf("channel:%v", channel_id),
+				Data:        poll,
+				MessageType: "POLL_TALLY",
+			}
+		}
+
+		conn.Release()
+		cancel()
+	}
+}
+
+// Deletes room messages older than their room or channels retention period. Messages are deleted
+// in small batches, skipping rows that are locked, so that the table is never locked for long.
+// Attachments, notifications and polls are deleted along with the messages by the foreign keys.
+func purgeExpiredMessages(ss *socketServer.SocketServer, db *pgxpool.Pool) {
+	const batchSize = 200
+
+	for {
+		time.Sleep(time.Minute * 5)
+
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+
+			deleted := 0
+			if rows, err := db.Query(ctx, `
+			DELETE FROM room_messages WHERE id IN (
+				SELECT room_messages.id FROM room_messages
+				INNER JOIN room_channels ON room_channels.id = room_messages.room_channel_id
+				INNER JOIN rooms ON rooms.id = room_channels.room_id
+				WHERE COALESCE(room_channels.retention_days, rooms.retention_days, 0) > 0
+				AND room_messages.created_at < NOW() - MAKE_INTERVAL(days => COALESCE(room_channels.retention_days, rooms.retention_days))
+				LIMIT $1
+				FOR UPDATE OF room_messages SKIP LOCKED
+			) RETURNING id,room_channel_id;
+			`, batchSize); err != nil {
+				log.Printf("Error purging messages in retention loop:%v\n", err)
+			} else {
+				for rows.Next() {
+					var id, channel_id string
+					if err = rows.Scan(&id, &channel_id); err != nil {
+						log.Printf("Error scanning message in retention loop:%v\n", err)
+						break
+					}
+					deleted++
+					ss.SendDataToSub <- socketServer.SubscriptionMessageData{
+						SubName: fmt.Sprintf("channel:%v", channel_id),
+						Data: socketMessages.RoomMessageDelete{
+							ID: id,
+						},
+						MessageType: "ROOM_MESSAGE_DELETE",
+					}
+				}
+				rows.Close()
+			}
+
+			cancel()
+
+			if deleted < batchSize {
+				break
+			}
+			// give other queries a chance between batches
+			time.Sleep(time.Millisecond * 200)
+		}
+	}
+}
+
+// Deletes bans and mutes once they have expired and sends out UNBAN events, the same as lifting them manually
+func liftExpiredSanctions(ss *socketServer.SocketServer, db *pgxpool.Pool) {
+	type sanction struct {
+		userID string
+		roomID string
+		kind   string
+	}
+
+	for {
+		time.Sleep(time.Second * 10)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+
+		lifted := []sanction{}
+		for _, kind := range []string{"BAN", "MUTE"} {
+			table, action := "bans", "UNBAN"
+			if kind == "MUTE" {
+				table, action = "mutes", "UNMUTE"
+			}
+			// lifted sanctions are recorded in the audit log without an actor
+			rows, err := db.Query(ctx, fmt.Sprintf(`
+			WITH lifted AS (
+				DELETE FROM %v WHERE expires_at <= NOW() RETURNING user_id,room_id,reason,expires_at
+			), logged AS (
+				INSERT INTO room_audit_log (room_id,action,target_id,before)
+				SELECT room_id,$1,user_id,JSONB_BUILD_OBJECT('reason',reason,'expires_at',expires_at) FROM lifted
+			)
+			SELECT user_id,room_id FROM lifted;
+			`, table), action)
+			if err != nil {
+				log.Printf("Error lifting expired sanctions:%v\n", err)
+				continue
+			}
+			for rows.Next() {
+				s := sanction{kind: kind}
+				if err = rows.Scan(&s.userID, &s.roomID); err != nil {
+					log.Printf("Error scanning sanction in sanction expiry loop:%v\n", err)
+					break
+				}
+				lifted = append(lifted, s)
+			}
+			rows.Close()
+		}
+
+		for _, s := range lifted {
+			rows, err := db.Query(ctx, `
+			SELECT id FROM room_channels WHERE room_id = $1;
+			`, s.roomID)
+			if err != nil {
+				log.Printf("Error selecting channels in sanction expiry loop:%v\n", err)
+				continue
+			}
+			channel_sub_names := []string{}
+			for rows.Next() {
+				var id string
+				if err = rows.Scan(&id); err != nil {
+					log.Printf("Error scanning channel in sanction expiry loop:%v\n", err)
+					break
+				}
+				channel_sub_names = append(channel_sub_names, fmt.Sprintf("channel:%v", id))
+			}
+			rows.Close()
+
+			data := socketMessages.Unban{
+				UserID: s.userID,
+				RoomID: s.roomID,
+				Kind:   s.kind,
+			}
+			ss.SendDataToSubs <- socketServer.SubscriptionsMessageData{
+				SubNames:    channel_sub_names,
+				Data:        data,
+				MessageType: "UNBAN",
+			}
+			ss.SendDataToUser <- socketServer.UserMessageData{
+				Uid:         s.userID,
+				Data:        data,
+				MessageType: "UNBAN",
+			}
+		}
+
+		cancel()
+	}
+}
+
 func handleUserDeleteCancelDelete(udl *sync.Map, udldc chan string) {
 	for {
 		uid := <-udldc
@@ -376,42 +1182,36 @@ func handleUserDeleteListUserDisconnected(udl *sync.Map, ss *socketServer.Socket
 
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
 
-		var seeded bool
-		if err := db.QueryRow(ctx, "SELECT seeded FROM users WHERE id = $1;", uid).Scan(&seeded); err != nil {
+		// seeded users and bots are never deleted. Suspended users can't reconnect to stop the deletion, deleting
+		// them would also free up their name so the suspension could be dodged by registering again
+		var seeded, bot, suspended bool
+		if err := db.QueryRow(ctx, "SELECT seeded,bot,COALESCE(suspended_until > NOW(), FALSE) FROM users WHERE id = $1;", uid).Scan(&seeded, &bot, &suspended); err != nil {
 			log.Printf("Error A in user delete list disconnect sleep channel:%v\n", err)
 			cancel()
 			continue
 		}
-		if seeded {
+		if seeded || bot || suspended {
 			cancel()
 			continue
 		}
 
-		if _, err := db.Exec(ctx, "DELETE FROM users WHERE id = $1;", uid); err != nil {
-			log.Printf("Error A in user delete list disconnect sleep channel:%v\n", err)
+		// rooms that can't be passed on are deleted along with the user
+		deletedRooms, err := passOnRooms(ctx, ss, db, uid)
+		if err != nil {
+			log.Printf("Error B in user delete list disconnect sleep channel:%v\n", err)
 			cancel()
 			continue
 		}
 
-		roomSubs := []string{}
-
-		if rows, err := db.Query(ctx, "SELECT id FROM rooms WHERE author_id = $1;", uid); err != nil {
-			log.Printf("Error B in user delete list disconnect sleep channel:%v\n", err)
+		if _, err := db.Exec(ctx, "DELETE FROM users WHERE id = $1;", uid); err != nil {
+			log.Printf("Error A in user delete list disconnect sleep channel:%v\n", err)
 			cancel()
 			continue
-		} else {
-			for rows.Next() {
-				var id string
-				if err := rows.Scan(&id); err != nil {
-					rows.Close()
-					cancel()
-					log.Printf("Error C in user delete list disconnect sleep channel:%v\n", err)
-					continue
-				}
-				roomSubs = append(roomSubs, fmt.Sprintf("channel:%v", id))
-			}
+		}
 
-			rows.Close()
+		roomSubs := []string{}
+		for _, id := range deletedRooms {
+			roomSubs = append(roomSubs, fmt.Sprintf("room:%v", id))
 		}
 
 		changeData := make(map[string]interface{})
@@ -445,3 +1245,128 @@ func handleUserDeleteListUserDisconnected(udl *sync.Map, ss *socketServer.Socket
 		ctx.Done()
 	}
 }
+
+// Hands the rooms owned by a user who is about to be deleted to their successor, or the longest
+// standing member. Returns the rooms that couldn't be passed on, those are deleted with the user
+func passOnRooms(ctx context.Context, ss *socketServer.SocketServer, db *pgxpool.Pool, uid string) ([]string, error) {
+	type room struct {
+		id          string
+		successorID *string
+	}
+
+	// the designated successor has to still be a member, bots and banned users are skipped
+	rows, err := db.Query(ctx, `
+	SELECT id,CASE WHEN succession THEN COALESCE(
+		(SELECT user_id FROM members
+		WHERE user_id = rooms.successor_id AND room_id = rooms.id
+		AND NOT EXISTS(SELECT 1 FROM bans WHERE user_id = members.user_id AND room_id = rooms.id)),
+		(SELECT user_id FROM members INNER JOIN users ON users.id = members.user_id
+		WHERE room_id = rooms.id AND users.bot = FALSE
+		AND NOT EXISTS(SELECT 1 FROM bans WHERE user_id = members.user_id AND room_id = rooms.id)
+		ORDER BY members.created_at ASC LIMIT 1)
+	)::TEXT END
+	FROM rooms WHERE author_id = $1;
+	`, uid)
+	if err != nil {
+		return nil, err
+	}
+	rooms := []room{}
+	for rows.Next() {
+		var r room
+		if err = rows.Scan(&r.id, &r.successorID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		rooms = append(rooms, r)
+	}
+	rows.Close()
+
+	deleted := []string{}
+	for _, r := range rooms {
+		if r.successorID == nil {
+			deleted = append(deleted, r.id)
+			continue
+		}
+
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = tx.Exec(ctx, `
+		UPDATE rooms SET author_id = $1, successor_id = NULL WHERE id = $2;
+		`, *r.successorID, r.id); err != nil {
+			tx.Rollback(ctx)
+			return nil, err
+		}
+		if _, err = tx.Exec(ctx, `
+		DELETE FROM members WHERE user_id = $1 AND room_id = $2;
+		`, *r.successorID, r.id); err != nil {
+			tx.Rollback(ctx)
+			return nil, err
+		}
+		// recorded in the audit log without an actor
+		if _, err = tx.Exec(ctx, `
+		INSERT INTO room_audit_log (room_id,action,target_id,before,after)
+		VALUES($1,'OWNER_TRANSFER',$2,JSONB_BUILD_OBJECT('author_id',$3::TEXT),JSONB_BUILD_OBJECT('author_id',$4::TEXT));
+		`, r.id, *r.successorID, uid, *r.successorID); err != nil {
+			tx.Rollback(ctx)
+			return nil, err
+		}
+		if err = tx.Commit(ctx); err != nil {
+			return nil, err
+		}
+
+		changeData := make(map[string]interface{})
+		changeData["ID"] = r.id
+		changeData["author_id"] = *r.successorID
+		ss.SendDataToSub <- socketServer.SubscriptionMessageData{
+			SubName: fmt.Sprintf("room:%v", r.id),
+			Data: socketMessages.ChangeEvent{
+				Type:   "UPDATE",
+				Data:   changeData,
+				Entity: "ROOM",
+			},
+			MessageType: "CHANGE",
+		}
+	}
+
+	return deleted, nil
+}
+
+// Deletes invitations that haven't been responded to in time, and tells the inviter and the invited user
+func expireInvitations(ss *socketServer.SocketServer, db *pgxpool.Pool) {
+	for {
+		time.Sleep(time.Minute)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+
+		rows, err := db.Query(ctx, `
+		DELETE FROM invitations WHERE expires_at <= NOW() RETURNING inviter,invited,room_id;
+		`)
+		if err != nil {
+			log.Printf("Error deleting expired invitations:%v\n", err)
+			cancel()
+			continue
+		}
+		expired := []socketMessages.InvitationDelete{}
+		for rows.Next() {
+			inv := socketMessages.InvitationDelete{Reason: "EXPIRED"}
+			if err = rows.Scan(&inv.Inviter, &inv.Invited, &inv.RoomID); err != nil {
+				log.Printf("Error scanning invitation in invitation expiry loop:%v\n", err)
+				break
+			}
+			expired = append(expired, inv)
+		}
+		rows.Close()
+
+		for _, inv := range expired {
+			ss.SendDataToUsers <- socketServer.UsersMessageData{
+				Uids:        []string{inv.Inviter, inv.Invited},
+				Data:        inv,
+				MessageType: "INVITATION_DELETE",
+			}
+		}
+
+		cancel()
+	}
+}
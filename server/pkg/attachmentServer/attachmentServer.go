@@ -201,6 +201,28 @@ func processChunk(ss *socketServer.SocketServer, as *AttachmentServer, db *pgxpo
 			}
 		}
 
+		if strings.HasPrefix(metaTable, "group") {
+			var group_id string
+			if selectGroupStmt, err := conn.Conn().Prepare(data.Ctx, "attachment_server_chunk_loop_select_group_id_stmt", "SELECT group_id FROM group_messages WHERE id = $1;"); err != nil {
+				errored(err, conn)
+				continue
+			} else {
+				if err = conn.Conn().QueryRow(data.Ctx, selectGroupStmt.Name, data.ID).Scan(&group_id); err != nil {
+					errored(err, conn)
+					continue
+				}
+				ss.SendDataToSub <- socketServer.SubscriptionMessageData{
+					SubName: fmt.Sprintf("group:%v", group_id),
+					Data: socketMessages.AttachmentProgress{
+						Ratio:  ratio,
+						Failed: false,
+						MsgID:  data.ID,
+					},
+					MessageType: "ATTACHMENT_PROGRESS",
+				}
+			}
+		}
+
 		if ratio == 1 {
 			cleanup(data.Uid, data.ID, *conn, as)
 		}
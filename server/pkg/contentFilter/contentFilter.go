@@ -0,0 +1,386 @@
+package contentfilter
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+/*
+	Content goes through each stage of the pipeline in order. A stage can
+	block the content, mask the matching part of it, or just flag it. Every
+	match is returned so that it can be recorded for moderators.
+
+	The stages are configured from the JSON file at CONTENT_FILTER_CONFIG,
+	if that isn't set the defaults below are used. Rooms can have their own
+	word list, which replaces the default word list for messages in that room.
+*/
+
+type Action string
+
+const (
+	Block Action = "BLOCK"
+	Mask  Action = "MASK"
+	Flag  Action = "FLAG"
+)
+
+type Match struct {
+	Stage  string
+	Rule   string
+	Action Action
+	// The part of the content that matched
+	Text string
+}
+
+type Result struct {
+	// The content after masking
+	Content string
+	Blocked bool
+	Masked  bool
+	Matches []Match
+}
+
+type Stage interface {
+	Name() string
+	// Returns the content with any masks applied, and the matches
+	Apply(content string) (string, []Match)
+}
+
+type ContentFilter struct {
+	Words Stage
+	// The stages after the word list
+	Stages []Stage
+}
+
+type Config struct {
+	Words         WordsConfig         `json:"words"`
+	Regex         []RegexConfig       `json:"regex"`
+	Links         LinksConfig         `json:"links"`
+	RepeatedChars RepeatedCharsConfig `json:"repeated_chars"`
+	Caps          CapsConfig          `json:"caps"`
+}
+
+type WordsConfig struct {
+	Action Action   `json:"action"`
+	List   []string `json:"list"`
+}
+
+type RegexConfig struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	Action  Action `json:"action"`
+}
+
+type LinksConfig struct {
+	Action Action `json:"action"`
+	// If the allow list isn't empty, links to any other domain match
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+type RepeatedCharsConfig struct {
+	Action Action `json:"action"`
+	// The most times a character can be repeated in a row, 0 disables the stage
+	Max int `json:"max"`
+}
+
+type CapsConfig struct {
+	Action Action `json:"action"`
+	// Content with fewer letters than this is ignored, 0 disables the stage
+	MinLength int     `json:"min_length"`
+	MaxRatio  float64 `json:"max_ratio"`
+}
+
+var defaultConfig = Config{
+	Words: WordsConfig{Action: Mask, List: []string{}},
+	Links: LinksConfig{Action: Flag, Allow: []string{}, Deny: []string{}},
+	RepeatedChars: RepeatedCharsConfig{
+		Action: Mask,
+		Max:    8,
+	},
+	Caps: CapsConfig{
+		Action:    Flag,
+		MinLength: 16,
+		MaxRatio:  0.8,
+	},
+}
+
+func Init() *ContentFilter {
+	config := defaultConfig
+	if path := os.Getenv("CONTENT_FILTER_CONFIG"); path != "" {
+		bytes, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("Unable to read content filter config: %v\n", err)
+		}
+		if err = json.Unmarshal(bytes, &config); err != nil {
+			log.Fatalf("Unable to parse content filter config: %v\n", err)
+		}
+	}
+	cf, err := New(config)
+	if err != nil {
+		log.Fatalf("Invalid content filter config: %v\n", err)
+	}
+	return cf
+}
+
+func New(config Config) (*ContentFilter, error) {
+	cf := &ContentFilter{
+		Words:  NewWordList(config.Words.List, config.Words.Action),
+		Stages: []Stage{},
+	}
+
+	for _, r := range config.Regex {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("regex rule %v: %v", r.Name, err)
+		}
+		cf.Stages = append(cf.Stages, &RegexRule{
+			Rule:    r.Name,
+			Pattern: re,
+			Action:  r.Action,
+		})
+	}
+	if len(config.Links.Allow) > 0 || len(config.Links.Deny) > 0 {
+		cf.Stages = append(cf.Stages, &Links{
+			Allow:  config.Links.Allow,
+			Deny:   config.Links.Deny,
+			Action: config.Links.Action,
+		})
+	}
+	if config.RepeatedChars.Max > 0 {
+		cf.Stages = append(cf.Stages, &RepeatedChars{
+			Max:    config.RepeatedChars.Max,
+			Action: config.RepeatedChars.Action,
+		})
+	}
+	if config.Caps.MinLength > 0 {
+		cf.Stages = append(cf.Stages, &Caps{
+			MinLength: config.Caps.MinLength,
+			MaxRatio:  config.Caps.MaxRatio,
+			Action:    config.Caps.Action,
+		})
+	}
+
+	return cf, nil
+}
+
+// Runs the content through the pipeline. If words isn't nil it is used instead of the default word list.
+func (cf *ContentFilter) Run(content string, words Stage) Result {
+	if words == nil {
+		words = cf.Words
+	}
+
+	res := Result{Content: content, Matches: []Match{}}
+	for _, stage := range append([]Stage{words}, cf.Stages...) {
+		var matches []Match
+		res.Content, matches = stage.Apply(res.Content)
+		for _, m := range matches {
+			switch m.Action {
+			case Block:
+				res.Blocked = true
+			case Mask:
+				res.Masked = true
+			}
+		}
+		res.Matches = append(res.Matches, matches...)
+	}
+
+	return res
+}
+
+func mask(text string) string {
+	return strings.Repeat("*", len([]rune(text)))
+}
+
+// Replaces matches with the output of replace if the action is mask, and returns the matches
+func applyPattern(content string, re *regexp.Regexp, stage string, rule string, action Action, replace func(string) string) (string, []Match) {
+	matches := []Match{}
+	for _, text := range re.FindAllString(content, -1) {
+		matches = append(matches, Match{
+			Stage:  stage,
+			Rule:   rule,
+			Action: action,
+			Text:   text,
+		})
+	}
+	if action == Mask && len(matches) > 0 {
+		content = re.ReplaceAllStringFunc(content, replace)
+	}
+	return content, matches
+}
+
+/* --------------- WORD LIST --------------- */
+type WordList struct {
+	Action  Action
+	pattern *regexp.Regexp
+}
+
+// Words are matched as whole words, ignoring case
+func NewWordList(words []string, action Action) *WordList {
+	quoted := []string{}
+	for _, w := range words {
+		if w = strings.TrimSpace(w); w != "" {
+			quoted = append(quoted, regexp.QuoteMeta(w))
+		}
+	}
+	wl := &WordList{Action: action}
+	if len(quoted) > 0 {
+		wl.pattern = regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`)
+	}
+	return wl
+}
+
+func (wl *WordList) Name() string { return "WORDS" }
+
+func (wl *WordList) Apply(content string) (string, []Match) {
+	if wl.pattern == nil {
+		return content, nil
+	}
+	return applyPattern(content, wl.pattern, wl.Name(), "word", wl.Action, mask)
+}
+
+/* --------------- REGEX --------------- */
+type RegexRule struct {
+	Rule    string
+	Pattern *regexp.Regexp
+	Action  Action
+}
+
+func (r *RegexRule) Name() string { return "REGEX" }
+
+func (r *RegexRule) Apply(content string) (string, []Match) {
+	return applyPattern(content, r.Pattern, r.Name(), r.Rule, r.Action, mask)
+}
+
+/* --------------- LINKS --------------- */
+type Links struct {
+	Allow  []string
+	Deny   []string
+	Action Action
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+
+// Matches the domain and its subdomains
+func domainIn(host string, domains []string) bool {
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimPrefix(d, "."))
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Links) Name() string { return "LINKS" }
+
+func (l *Links) Apply(content string) (string, []Match) {
+	matches := []Match{}
+	out := linkPattern.ReplaceAllStringFunc(content, func(link string) string {
+		raw := link
+		if !strings.Contains(strings.ToLower(raw), "://") {
+			raw = "http://" + raw
+		}
+		u, err := url.Parse(raw)
+		if err != nil {
+			return link
+		}
+		host := strings.ToLower(u.Hostname())
+		rule := ""
+		if domainIn(host, l.Deny) {
+			rule = "deny"
+		} else if len(l.Allow) > 0 && !domainIn(host, l.Allow) {
+			rule = "not allowed"
+		}
+		if rule == "" {
+			return link
+		}
+		matches = append(matches, Match{
+			Stage:  l.Name(),
+			Rule:   rule,
+			Action: l.Action,
+			Text:   link,
+		})
+		if l.Action == Mask {
+			return "[link removed]"
+		}
+		return link
+	})
+	return out, matches
+}
+
+/* --------------- REPEATED CHARACTERS --------------- */
+type RepeatedChars struct {
+	Max    int
+	Action Action
+}
+
+func (rc *RepeatedChars) Name() string { return "REPEATED_CHARS" }
+
+// Masking cuts runs of the same character down to the maximum
+func (rc *RepeatedChars) Apply(content string) (string, []Match) {
+	matches := []Match{}
+	var out strings.Builder
+	runes := []rune(content)
+	for i := 0; i < len(runes); {
+		j := i
+		for j < len(runes) && runes[j] == runes[i] {
+			j++
+		}
+		run := string(runes[i:j])
+		if j-i > rc.Max && !unicode.IsSpace(runes[i]) {
+			matches = append(matches, Match{
+				Stage:  rc.Name(),
+				Rule:   fmt.Sprintf("more than %v", rc.Max),
+				Action: rc.Action,
+				Text:   run,
+			})
+			if rc.Action == Mask {
+				run = string(runes[i : i+rc.Max])
+			}
+		}
+		out.WriteString(run)
+		i = j
+	}
+	return out.String(), matches
+}
+
+/* --------------- CAPS --------------- */
+type Caps struct {
+	MinLength int
+	MaxRatio  float64
+	Action    Action
+}
+
+func (c *Caps) Name() string { return "CAPS" }
+
+// Masking lowercases the content
+func (c *Caps) Apply(content string) (string, []Match) {
+	letters, upper := 0, 0
+	for _, r := range content {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	if letters < c.MinLength || float64(upper)/float64(letters) <= c.MaxRatio {
+		return content, nil
+	}
+	match := Match{
+		Stage:  c.Name(),
+		Rule:   fmt.Sprintf("more than %v%% caps", int(c.MaxRatio*100)),
+		Action: c.Action,
+		Text:   content,
+	}
+	if c.Action == Mask {
+		content = strings.ToLower(content)
+	}
+	return content, []Match{match}
+}
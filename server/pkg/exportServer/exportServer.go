@@ -0,0 +1,518 @@
+package exportserver
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/pgtype"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	socketMessages "github.com/web-stuff-98/psql-social/pkg/socketMessages"
+	"github.com/web-stuff-98/psql-social/pkg/socketServer"
+)
+
+/*
+	Exports the history of a room channel or a conversation between two
+	users. Jobs are run one at a time in the background. The messages are
+	read in batches and written to a zip containing messages.json, a
+	transcript.html that doesn't need any other files to display, and the
+	attachments. The zip is stored in export_chunks and the requester is
+	sent an EXPORT_COMPLETE event with the download link.
+
+	Access is checked again when the job runs, incase the requester lost
+	access to the channel while the job was queued.
+*/
+
+const (
+	// messages are read from the database in batches of this size
+	batchSize = 500
+	// the zip is stored in chunks of this size, the same as attachments
+	chunkSize = 4 * 1024 * 1024
+	// exports are deleted after this long
+	expiry     = time.Hour * 24 * 7
+	jobTimeout = time.Minute * 10
+)
+
+type ExportServer struct {
+	// Channel for queueing an export by its id, the row must already exist in the exports table
+	QueueExport chan string
+}
+
+/* --------------- MODELS --------------- */
+type job struct {
+	id       string
+	uid      string
+	kind     string
+	targetID string
+}
+
+type Export struct {
+	Kind      string `json:"kind"`
+	Title     string `json:"title"`
+	RoomID    string `json:"room_id,omitempty"`
+	ChannelID string `json:"channel_id,omitempty"`
+	// the two users for conversation exports
+	Uids       []string `json:"uids,omitempty"`
+	ExportedAt string   `json:"exported_at"`
+}
+
+type Message struct {
+	ID         string `json:"ID"`
+	AuthorID   string `json:"author_id"`
+	AuthorName string `json:"author_name"`
+	Content    string `json:"content"`
+	CreatedAt  string `json:"created_at"`
+	// empty if the message was never edited
+	EditedAt   string      `json:"edited_at,omitempty"`
+	Attachment *Attachment `json:"attachment,omitempty"`
+}
+
+type Attachment struct {
+	Name string `json:"name"`
+	Mime string `json:"mime"`
+	Size int    `json:"size"`
+	// path of the file inside the zip, empty if the upload failed or didn't finish
+	Path string `json:"path,omitempty"`
+}
+
+func Init(ss *socketServer.SocketServer, db *pgxpool.Pool) *ExportServer {
+	es := &ExportServer{
+		QueueExport: make(chan string, 100),
+	}
+	runServer(ss, es, db)
+	return es
+}
+
+func runServer(ss *socketServer.SocketServer, es *ExportServer, db *pgxpool.Pool) {
+	go requeuePending(es, db)
+	go runJobs(ss, es, db)
+	go deleteExpired(db)
+}
+
+// Jobs that were pending when the server stopped get queued again
+func requeuePending(es *ExportServer, db *pgxpool.Pool) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	rows, err := db.Query(ctx, `
+	SELECT id FROM exports WHERE status = 'PENDING' ORDER BY created_at ASC;
+	`)
+	if err != nil {
+		log.Println("Error selecting pending exports in export server:", err)
+		return
+	}
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			log.Println("Error scanning pending export in export server:", err)
+			break
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		es.QueueExport <- id
+	}
+}
+
+func deleteExpired(db *pgxpool.Pool) {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		if _, err := db.Exec(ctx, `
+		DELETE FROM exports WHERE expires_at < NOW();
+		`); err != nil {
+			log.Println("Error deleting expired exports in export server:", err)
+		}
+		cancel()
+		time.Sleep(time.Hour)
+	}
+}
+
+func runJobs(ss *socketServer.SocketServer, es *ExportServer, db *pgxpool.Pool) {
+	for {
+		id := <-es.QueueExport
+
+		ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+
+		var j job
+		if err := db.QueryRow(ctx, `
+		SELECT id,user_id,kind,target_id FROM exports WHERE id = $1 AND status = 'PENDING';
+		`, id).Scan(&j.id, &j.uid, &j.kind, &j.targetID); err != nil {
+			if err != pgx.ErrNoRows {
+				log.Println("Error selecting export in export server:", err)
+			}
+			cancel()
+			continue
+		}
+
+		status := "COMPLETE"
+		size, err := runJob(ctx, db, j)
+		if err != nil {
+			log.Println("Export failed in export server:", err)
+			status = "FAILED"
+			if _, err = db.Exec(ctx, `
+			DELETE FROM export_chunks WHERE export_id = $1;
+			`, j.id); err != nil {
+				log.Println("Error deleting failed export chunks in export server:", err)
+			}
+		}
+
+		if _, err = db.Exec(ctx, `
+		UPDATE exports SET status = $1, size = $2, expires_at = $3 WHERE id = $4;
+		`, status, size, time.Now().Add(expiry), j.id); err != nil {
+			log.Println("Error updating export in export server:", err)
+		}
+
+		ss.SendDataToUser <- socketServer.UserMessageData{
+			Uid: j.uid,
+			Data: socketMessages.ExportComplete{
+				ID:     j.id,
+				Failed: status == "FAILED",
+				URL:    fmt.Sprintf("/api/export/%v", j.id),
+			},
+			MessageType: "EXPORT_COMPLETE",
+		}
+
+		cancel()
+	}
+}
+
+// Writes the zip to a temporary file, then copies it into export_chunks. Returns the size of the zip.
+func runJob(ctx context.Context, db *pgxpool.Pool, j job) (int, error) {
+	conn, err := db.Acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+
+	export, err := getExport(ctx, conn, j)
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := os.CreateTemp("", "export-*.zip")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	if err = writeJSON(ctx, conn, j, export, zw); err != nil {
+		return 0, err
+	}
+	if err = writeHTML(ctx, conn, j, export, zw); err != nil {
+		return 0, err
+	}
+	if err = writeAttachments(ctx, conn, j, zw); err != nil {
+		return 0, err
+	}
+	if err = zw.Close(); err != nil {
+		return 0, err
+	}
+
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	size := 0
+	buf := make([]byte, chunkSize)
+	for index := 0; ; index++ {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			if _, err := conn.Exec(ctx, `
+			INSERT INTO export_chunks (bytes,export_id,chunk_index) VALUES($1,$2,$3);
+			`, buf[:n], j.id, index); err != nil {
+				return 0, err
+			}
+			size += n
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return size, nil
+}
+
+// Checks that the requester still has access, and gets the details for the top of the export
+func getExport(ctx context.Context, conn *pgxpool.Conn, j job) (Export, error) {
+	export := Export{
+		Kind:       j.kind,
+		ExportedAt: time.Now().Format(time.RFC3339),
+	}
+
+	switch j.kind {
+	case "CHANNEL":
+		var roomName, channelName, authorID string
+		if err := conn.QueryRow(ctx, `
+		SELECT rooms.id,rooms.name,rooms.author_id,room_channels.name FROM room_channels
+		INNER JOIN rooms ON rooms.id = room_channels.room_id
+		WHERE room_channels.id = $1;
+		`, j.targetID).Scan(&export.RoomID, &roomName, &authorID, &channelName); err != nil {
+			return export, err
+		}
+		if authorID != j.uid {
+			return export, fmt.Errorf("User %v does not own room %v", j.uid, export.RoomID)
+		}
+		export.ChannelID = j.targetID
+		export.Title = fmt.Sprintf("%v - %v", roomName, channelName)
+	case "CONVERSATION":
+		var usernames []string
+		if err := conn.QueryRow(ctx, `
+		SELECT ARRAY_AGG(username ORDER BY id = $1 DESC) FROM users WHERE id = $1 OR id = $2;
+		`, j.uid, j.targetID).Scan(&usernames); err != nil {
+			return export, err
+		}
+		if len(usernames) != 2 {
+			return export, fmt.Errorf("User not found")
+		}
+		export.Uids = []string{j.uid, j.targetID}
+		export.Title = fmt.Sprintf("%v and %v", usernames[0], usernames[1])
+	default:
+		return export, fmt.Errorf("Unknown export kind %v", j.kind)
+	}
+
+	return export, nil
+}
+
+func messagesQuery(j job) string {
+	if j.kind == "CHANNEL" {
+		return `
+		SELECT room_messages.id,COALESCE(room_messages.author_id::TEXT,''),
+		COALESCE(users.username,room_messages.webhook_username,''),
+		room_messages.content,room_messages.created_at,room_messages.edited_at,
+		room_message_attachment_metadata.name,room_message_attachment_metadata.meta,
+		room_message_attachment_metadata.size,room_message_attachment_metadata.ratio = 1 AND NOT room_message_attachment_metadata.failed
+		FROM room_messages
+		LEFT JOIN users ON users.id = room_messages.author_id
+		LEFT JOIN room_message_attachment_metadata ON room_message_attachment_metadata.message_id = room_messages.id
+		WHERE room_messages.room_channel_id = $1
+		AND (room_messages.created_at,room_messages.id) > ($2,$3)
+		ORDER BY room_messages.created_at ASC,room_messages.id ASC
+		LIMIT $4;
+		`
+	}
+	return `
+	SELECT direct_messages.id,direct_messages.author_id::TEXT,users.username,
+	direct_messages.content,direct_messages.created_at,direct_messages.edited_at,
+	direct_message_attachment_metadata.name,direct_message_attachment_metadata.meta,
+	direct_message_attachment_metadata.size,direct_message_attachment_metadata.ratio = 1 AND NOT direct_message_attachment_metadata.failed
+	FROM direct_messages
+	INNER JOIN users ON users.id = direct_messages.author_id
+	LEFT JOIN direct_message_attachment_metadata ON direct_message_attachment_metadata.message_id = direct_messages.id
+	WHERE ((direct_messages.author_id = $1 AND direct_messages.recipient_id = $2)
+	OR (direct_messages.author_id = $2 AND direct_messages.recipient_id = $1))
+	AND (direct_messages.created_at,direct_messages.id) > ($3,$4)
+	ORDER BY direct_messages.created_at ASC,direct_messages.id ASC
+	LIMIT $5;
+	`
+}
+
+// Calls fn for every message in order, reading them from the database in batches
+func forEachMessage(ctx context.Context, conn *pgxpool.Conn, j job, fn func(m Message) error) error {
+	query := messagesQuery(j)
+	lastCreatedAt := time.Time{}
+	lastID := "00000000-0000-0000-0000-000000000000"
+	for {
+		args := []interface{}{j.uid, j.targetID, lastCreatedAt, lastID, batchSize}
+		if j.kind == "CHANNEL" {
+			args = []interface{}{j.targetID, lastCreatedAt, lastID, batchSize}
+		}
+		rows, err := conn.Query(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+
+		batch := []Message{}
+		for rows.Next() {
+			var m Message
+			var created_at, edited_at pgtype.Timestamptz
+			var name, meta *string
+			var size *int
+			var complete *bool
+			if err = rows.Scan(&m.ID, &m.AuthorID, &m.AuthorName, &m.Content, &created_at, &edited_at, &name, &meta, &size, &complete); err != nil {
+				rows.Close()
+				return err
+			}
+			m.CreatedAt = created_at.Time.Format(time.RFC3339)
+			if edited_at.Status == pgtype.Present {
+				m.EditedAt = edited_at.Time.Format(time.RFC3339)
+			}
+			if name != nil {
+				m.Attachment = &Attachment{
+					Name: *name,
+					Mime: *meta,
+					Size: *size,
+				}
+				if *complete {
+					m.Attachment.Path = attachmentPath(m.ID, *name)
+				}
+			}
+			lastCreatedAt = created_at.Time
+			lastID = m.ID
+			batch = append(batch, m)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		for _, m := range batch {
+			if err = fn(m); err != nil {
+				return err
+			}
+		}
+
+		if len(batch) < batchSize {
+			return nil
+		}
+	}
+}
+
+func attachmentPath(msgID string, name string) string {
+	return fmt.Sprintf("attachments/%v/%v", msgID, path.Base(strings.ReplaceAll(name, "\\", "/")))
+}
+
+// Writes messages.json, the messages are encoded one at a time so the whole history is never in memory
+func writeJSON(ctx context.Context, conn *pgxpool.Conn, j job, export Export, zw *zip.Writer) error {
+	w, err := zw.Create("messages.json")
+	if err != nil {
+		return err
+	}
+
+	exportBytes, err := json.Marshal(export)
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintf(w, `{"export":%s,"messages":[`, exportBytes); err != nil {
+		return err
+	}
+
+	first := true
+	if err = forEachMessage(ctx, conn, j, func(m Message) error {
+		if !first {
+			if _, err := w.Write([]byte(",")); err != nil {
+				return err
+			}
+		}
+		first = false
+		msgBytes, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(msgBytes)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	_, err = w.Write([]byte("]}"))
+	return err
+}
+
+const transcriptStyle = `
+body { font-family: sans-serif; background: #fafafa; color: #222; max-width: 800px; margin: 0 auto; padding: 16px; }
+h1 { font-size: 1.4em; margin-bottom: 0; }
+.exported { color: #777; font-size: 0.85em; margin-bottom: 16px; }
+.message { padding: 6px 0; border-bottom: 1px solid #eee; }
+.author { font-weight: bold; }
+.time, .edited { color: #777; font-size: 0.8em; margin-left: 6px; }
+.content { white-space: pre-wrap; word-wrap: break-word; margin-top: 2px; }
+.attachment { font-size: 0.9em; margin-top: 2px; }
+`
+
+// Writes transcript.html. The styles are inline, attachments are linked relative to the zip.
+func writeHTML(ctx context.Context, conn *pgxpool.Conn, j job, export Export, zw *zip.Writer) error {
+	w, err := zw.Create("transcript.html")
+	if err != nil {
+		return err
+	}
+
+	title := html.EscapeString(export.Title)
+	if _, err = fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%v</title>
+<style>%v</style>
+</head>
+<body>
+<h1>%v</h1>
+<div class="exported">Exported %v</div>
+`, title, transcriptStyle, title, export.ExportedAt); err != nil {
+		return err
+	}
+
+	if err = forEachMessage(ctx, conn, j, func(m Message) error {
+		var sb strings.Builder
+		sb.WriteString(`<div class="message">`)
+		fmt.Fprintf(&sb, `<span class="author">%v</span>`, html.EscapeString(m.AuthorName))
+		fmt.Fprintf(&sb, `<span class="time">%v</span>`, m.CreatedAt)
+		if m.EditedAt != "" {
+			fmt.Fprintf(&sb, `<span class="edited">(edited %v)</span>`, m.EditedAt)
+		}
+		fmt.Fprintf(&sb, `<div class="content">%v</div>`, html.EscapeString(m.Content))
+		if m.Attachment != nil {
+			if m.Attachment.Path != "" {
+				fmt.Fprintf(&sb, `<div class="attachment"><a href="%v">%v</a></div>`, html.EscapeString(m.Attachment.Path), html.EscapeString(m.Attachment.Name))
+			} else {
+				fmt.Fprintf(&sb, `<div class="attachment">%v (unavailable)</div>`, html.EscapeString(m.Attachment.Name))
+			}
+		}
+		sb.WriteString("</div>\n")
+		_, err := io.WriteString(w, sb.String())
+		return err
+	}); err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "</body>\n</html>\n")
+	return err
+}
+
+// Copies the attachment chunks into the zip, one chunk at a time
+func writeAttachments(ctx context.Context, conn *pgxpool.Conn, j job, zw *zip.Writer) error {
+	chunkTable := "direct_message_attachment_chunks"
+	if j.kind == "CHANNEL" {
+		chunkTable = "room_message_attachment_chunks"
+	}
+
+	return forEachMessage(ctx, conn, j, func(m Message) error {
+		if m.Attachment == nil || m.Attachment.Path == "" {
+			return nil
+		}
+		w, err := zw.Create(m.Attachment.Path)
+		if err != nil {
+			return err
+		}
+		var chunkBytes pgtype.Bytea
+		for index := 0; ; index++ {
+			if err = conn.QueryRow(ctx, fmt.Sprintf(`
+			SELECT bytes FROM %v WHERE message_id = $1 AND chunk_index = $2;
+			`, chunkTable), m.ID, index).Scan(&chunkBytes); err != nil {
+				if err == pgx.ErrNoRows {
+					return nil
+				}
+				return err
+			}
+			if _, err = w.Write(chunkBytes.Bytes); err != nil {
+				return err
+			}
+		}
+	})
+}
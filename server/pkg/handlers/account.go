@@ -74,6 +74,12 @@ func (h handler) Login(ctx *fiber.Ctx) error {
 		}
 	}
 
+	if suspended, err := authHelpers.IsSuspended(rctx, h.DB, id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else if suspended {
+		return fiber.NewError(fiber.StatusForbidden, "Your account has been suspended")
+	}
+
 	if cookie, err := authHelpers.Authorize(h.RedisClient, rctx, id); err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 	} else {
@@ -129,6 +135,14 @@ func (h handler) Register(ctx *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusBadRequest, "There is already another user using that name")
 	}
 
+	filtered, allowed, err := filterName(rctx, h, "", "USERNAME", "", strings.TrimSpace(body.Username))
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if !allowed {
+		return fiber.NewError(fiber.StatusBadRequest, "That username is not allowed")
+	}
+
 	var id string
 
 	// dont hash passwords in development mode, because it doesn't work with CGO and I need to use the -race flag to debug
@@ -160,6 +174,10 @@ func (h handler) Register(ctx *fiber.Ctx) error {
 		}
 	}
 
+	if err = recordFilterMatches(rctx, h, id, "USERNAME", id, "", strings.TrimSpace(body.Username), filtered); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
 	if cookie, err := authHelpers.Authorize(h.RedisClient, rctx, id); err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 	} else {
@@ -181,7 +199,7 @@ func (h handler) Logout(ctx *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusForbidden, "You are not logged in")
 	} else {
 		h.SocketServer.CloseConnChan <- uid
-		authHelpers.DeleteSession(h.RedisClient, rctx, sid)
+		authHelpers.DeleteSession(h.RedisClient, rctx, uid, sid)
 		ctx.Cookie(authHelpers.GetClearedCookie())
 	}
 
@@ -251,9 +269,30 @@ func (h handler) GetNotifications(ctx *fiber.Ctx) error {
 		}
 	}
 
+	groupMessageNotifications := []responses.GroupMessageNotification{}
+	if rows, err := h.DB.Query(rctx, `
+	SELECT group_id FROM group_message_notifications WHERE user_id = $1;
+	`, uid); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+	} else {
+		defer rows.Close()
+		for rows.Next() {
+			var group_id string
+			if err = rows.Scan(&group_id); err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+			}
+			groupMessageNotifications = append(groupMessageNotifications, responses.GroupMessageNotification{
+				GroupID: group_id,
+			})
+		}
+	}
+
 	if data, err := json.Marshal(responses.Notifications{
 		DirectMessageNotifications: directMessageNotifications,
 		RoomMessageNotifications:   roomMessageNotifications,
+		GroupMessageNotifications:  groupMessageNotifications,
 	}); err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 	} else {
@@ -545,7 +584,7 @@ func (h handler) GetConversees(ctx *fiber.Ctx) error {
 	}
 
 	selectInvsStmt, err := conn.Conn().Prepare(rctx, "select_conversees_invitations_stmt", `
-	SELECT inviter,invited FROM invitations WHERE inviter = $1 OR invited = $1;
+	SELECT inviter,invited FROM invitations WHERE (inviter = $1 OR invited = $1) AND expires_at > NOW();
 	`)
 	if err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
@@ -671,7 +710,7 @@ func (h handler) GetConversation(ctx *fiber.Ctx) error {
 	}
 
 	selectInvStmt, err := conn.Conn().Prepare(rctx, "get_conversation_select_invitations_stmt", `
-	SELECT inviter,invited,created_at,room_id FROM invitations WHERE (inviter = $1) OR (invited = $1);
+	SELECT inviter,invited,created_at,expires_at,room_id FROM invitations WHERE ((inviter = $1) OR (invited = $1)) AND expires_at > NOW();
 	`)
 	if err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
@@ -685,9 +724,9 @@ func (h handler) GetConversation(ctx *fiber.Ctx) error {
 		defer rows.Close()
 		for rows.Next() {
 			var inviter, invited, room_id string
-			var created_at pgtype.Timestamptz
+			var created_at, expires_at pgtype.Timestamptz
 
-			if err = rows.Scan(&inviter, &invited, &created_at, &room_id); err != nil {
+			if err = rows.Scan(&inviter, &invited, &created_at, &expires_at, &room_id); err != nil {
 				return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 			}
 
@@ -695,6 +734,7 @@ func (h handler) GetConversation(ctx *fiber.Ctx) error {
 				Inviter:   inviter,
 				Invited:   invited,
 				CreatedAt: created_at.Time.Format(time.RFC3339),
+				ExpiresAt: expires_at.Time.Format(time.RFC3339),
 				RoomID:    room_id,
 			})
 		}
@@ -714,6 +754,56 @@ func (h handler) GetConversation(ctx *fiber.Ctx) error {
 	return nil
 }
 
+// Lists the invitations the user has received and sent that haven't expired, newest first
+func (h handler) GetPendingInvitations(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	rows, err := h.DB.Query(rctx, `
+	SELECT inviter,invited,created_at,expires_at,room_id FROM invitations
+	WHERE (inviter = $1 OR invited = $1) AND expires_at > NOW()
+	ORDER BY created_at DESC;
+	`, uid)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer rows.Close()
+
+	pending := responses.PendingInvitations{
+		Received: []responses.Invitation{},
+		Sent:     []responses.Invitation{},
+	}
+	for rows.Next() {
+		var inv responses.Invitation
+		var created_at, expires_at pgtype.Timestamptz
+		if err = rows.Scan(&inv.Inviter, &inv.Invited, &created_at, &expires_at, &inv.RoomID); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		inv.CreatedAt = created_at.Time.Format(time.RFC3339)
+		inv.ExpiresAt = expires_at.Time.Format(time.RFC3339)
+		if inv.Invited == uid {
+			pending.Received = append(pending.Received, inv)
+		} else {
+			pending.Sent = append(pending.Sent, inv)
+		}
+	}
+	rows.Close()
+
+	if outBytes, err := json.Marshal(pending); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(outBytes)
+	}
+
+	return nil
+}
+
 func (h handler) GetFriends(ctx *fiber.Ctx) error {
 	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
 	defer cancel()
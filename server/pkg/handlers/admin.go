@@ -0,0 +1,495 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/web-stuff-98/psql-social/pkg/helpers/authHelpers"
+	"github.com/web-stuff-98/psql-social/pkg/responses"
+	socketMessages "github.com/web-stuff-98/psql-social/pkg/socketMessages"
+	"github.com/web-stuff-98/psql-social/pkg/socketServer"
+	"github.com/web-stuff-98/psql-social/pkg/validation"
+)
+
+/*
+	Endpoints for users with the ADMIN role, for managing users and rooms
+	without going through the database. Every route is wrapped in
+	middleware.AdminOnly. Admins can't suspend or log out other admins.
+*/
+
+const adminPageSize = 30
+
+// The columns scanned by scanAdminUser
+const adminUserColumns = `id,username,role,bot,COALESCE(suspended_until = 'infinity', FALSE),
+	CASE WHEN suspended_until > NOW() AND suspended_until <> 'infinity' THEN suspended_until END`
+
+func scanAdminUser(h handler, row pgx.Row) (responses.AdminUser, error) {
+	var u responses.AdminUser
+	var permanent bool
+	var suspended_until *time.Time
+	if err := row.Scan(&u.ID, &u.Username, &u.Role, &u.Bot, &permanent, &suspended_until); err != nil {
+		return u, err
+	}
+	if permanent {
+		u.SuspendedUntil = "infinity"
+	} else if suspended_until != nil {
+		u.SuspendedUntil = suspended_until.Format(time.RFC3339)
+	}
+
+	recvChan := make(chan bool, 1)
+	h.SocketServer.IsUserOnline <- socketServer.IsUserOnline{
+		RecvChan: recvChan,
+		Uid:      u.ID,
+	}
+	u.Online = <-recvChan
+	close(recvChan)
+
+	return u, nil
+}
+
+// The columns scanned by scanAdminRooms
+const adminRoomColumns = `id,name,private,author_id::TEXT,created_at,(SELECT COUNT(*) FROM members WHERE room_id = rooms.id)`
+
+func scanAdminRooms(rows pgx.Rows) ([]responses.AdminRoom, error) {
+	rooms := []responses.AdminRoom{}
+	for rows.Next() {
+		var r responses.AdminRoom
+		var author_id *string
+		var created_at time.Time
+		if err := rows.Scan(&r.ID, &r.Name, &r.Private, &author_id, &created_at, &r.MemberCount); err != nil {
+			return nil, err
+		}
+		if author_id != nil {
+			r.AuthorID = *author_id
+		}
+		r.CreatedAt = created_at.Format(time.RFC3339)
+		rooms = append(rooms, r)
+	}
+	return rooms, rows.Err()
+}
+
+// Checks that the user exists and isn't an admin
+func checkCanModerateUser(ctx context.Context, h handler, uid string) error {
+	var role string
+	if err := h.DB.QueryRow(ctx, `
+	SELECT role FROM users WHERE id = $1;
+	`, uid).Scan(&role); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "User not found")
+	}
+	if role == "ADMIN" {
+		return fiber.NewError(fiber.StatusBadRequest, "You cannot do that to an admin")
+	}
+	return nil
+}
+
+// Suspends the user for a number of days, or permanently if days is 0. Their sessions
+// are deleted and their socket connection is closed, and they can't log in again until
+// the suspension is lifted
+func suspendUser(ctx context.Context, h handler, uid string, days int) error {
+	if _, err := h.DB.Exec(ctx, `
+	UPDATE users SET suspended_until = CASE WHEN $1 = 0 THEN 'infinity' ELSE NOW() + MAKE_INTERVAL(days => $1) END WHERE id = $2;
+	`, days, uid); err != nil {
+		return err
+	}
+
+	// the users bots can't authenticate while their owner is suspended, so close their connections too
+	rows, err := h.DB.Query(ctx, `
+	SELECT id FROM users WHERE owner_id = $1;
+	`, uid)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var botID string
+		if err = rows.Scan(&botID); err != nil {
+			return err
+		}
+		h.SocketServer.CloseConnChan <- botID
+	}
+	rows.Close()
+
+	return logoutUser(ctx, h, uid)
+}
+
+// Deletes all of the users sessions and closes their socket connection
+func logoutUser(ctx context.Context, h handler, uid string) error {
+	if err := authHelpers.DeleteSessions(h.RedisClient, ctx, uid); err != nil {
+		return err
+	}
+
+	h.SocketServer.CloseConnChan <- uid
+
+	return nil
+}
+
+// Deletes the room and tells everyone watching it
+func removeRoom(ctx context.Context, h handler, roomID string) error {
+	if _, err := h.DB.Exec(ctx, `
+	DELETE FROM rooms WHERE id = $1;
+	`, roomID); err != nil {
+		return err
+	}
+
+	outChangeData := make(map[string]interface{})
+	outChangeData["ID"] = roomID
+
+	h.SocketServer.SendDataToSub <- socketServer.SubscriptionMessageData{
+		SubName: fmt.Sprintf("room:%v", roomID),
+		Data: socketMessages.ChangeEvent{
+			Data:   outChangeData,
+			Entity: "ROOM",
+			Type:   "DELETE",
+		},
+		MessageType: "CHANGE",
+	}
+
+	return nil
+}
+
+// Lists users by name. Can be filtered by the start of the name, role, whether they are suspended and whether they are bots
+func (h handler) GetAdminUsers(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	page := ctx.QueryInt("page", 1)
+	if page < 1 {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	offset := (page - 1) * adminPageSize
+
+	search := ctx.Query("search")
+	role := ctx.Query("role")
+	suspended := ctx.Query("suspended")
+	bot := ctx.Query("bot")
+	if len(search) > 16 ||
+		(role != "" && role != "ADMIN" && role != "USER") ||
+		(suspended != "" && suspended != "true" && suspended != "false") ||
+		(bot != "" && bot != "true" && bot != "false") {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	// empty filters match everything
+	filter := `
+	WHERE ($1 = '' OR LOWER(username) LIKE LOWER($1) || '%')
+	AND ($2 = '' OR role = $2)
+	AND ($3 = '' OR COALESCE(suspended_until > NOW(), FALSE) = ($3 = 'true'))
+	AND ($4 = '' OR bot = ($4 = 'true'))`
+
+	rows, err := h.DB.Query(rctx, `
+	SELECT `+adminUserColumns+` FROM users`+filter+`
+	ORDER BY username ASC
+	LIMIT $5 OFFSET $6;
+	`, search, role, suspended, bot, adminPageSize, offset)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer rows.Close()
+
+	users := []responses.AdminUser{}
+	for rows.Next() {
+		u, err := scanAdminUser(h, rows)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		users = append(users, u)
+	}
+	rows.Close()
+
+	var count int
+	if err = h.DB.QueryRow(rctx, `
+	SELECT COUNT(*) FROM users`+filter+`;
+	`, search, role, suspended, bot).Scan(&count); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if bytes, err := json.Marshal(responses.AdminUsersPage{
+		Users: users,
+		Count: count,
+	}); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
+
+// Returns the user along with their rooms, sessions and the 50 most recent reports made by and against them
+func (h handler) GetAdminUser(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	id := ctx.Params("id")
+	if id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	details := responses.AdminUserDetails{}
+
+	user, err := scanAdminUser(h, h.DB.QueryRow(rctx, `
+	SELECT `+adminUserColumns+` FROM users WHERE id = $1;
+	`, id))
+	if err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "User not found")
+	}
+	details.User = user
+
+	roomRows, err := h.DB.Query(rctx, `
+	SELECT `+adminRoomColumns+` FROM rooms
+	WHERE author_id = $1 OR id IN (SELECT room_id FROM members WHERE user_id = $1)
+	ORDER BY created_at DESC;
+	`, id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer roomRows.Close()
+	if details.Rooms, err = scanAdminRooms(roomRows); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	roomRows.Close()
+
+	sessions, err := authHelpers.GetSessions(h.RedisClient, rctx, id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	details.Sessions = []responses.AdminSession{}
+	for _, s := range sessions {
+		details.Sessions = append(details.Sessions, responses.AdminSession{
+			ExpiresAt: time.Now().Add(s.ExpiresIn).Format(time.RFC3339),
+		})
+	}
+
+	againstRows, err := h.DB.Query(rctx, `
+	SELECT `+reportColumns+` FROM reports WHERE reported_user_id = $1 ORDER BY created_at DESC LIMIT 50;
+	`, id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer againstRows.Close()
+	if details.ReportsAgainst, err = scanReports(againstRows); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	againstRows.Close()
+
+	madeRows, err := h.DB.Query(rctx, `
+	SELECT `+reportColumns+` FROM reports WHERE reporter_id = $1 ORDER BY created_at DESC LIMIT 50;
+	`, id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer madeRows.Close()
+	if details.ReportsMade, err = scanReports(madeRows); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	madeRows.Close()
+
+	if bytes, err := json.Marshal(details); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
+
+func (h handler) SuspendUser(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	v := validator.New()
+	body := &validation.SuspendUser{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	id := ctx.Params("id")
+	if id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	if err := checkCanModerateUser(rctx, h, id); err != nil {
+		return err
+	}
+
+	if err := suspendUser(rctx, h, id, body.Days); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	return nil
+}
+
+func (h handler) UnsuspendUser(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	id := ctx.Params("id")
+	if id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	tag, err := h.DB.Exec(rctx, `
+	UPDATE users SET suspended_until = NULL WHERE id = $1;
+	`, id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if tag.RowsAffected() == 0 {
+		return fiber.NewError(fiber.StatusNotFound, "User not found")
+	}
+
+	return nil
+}
+
+// Logs the user out everywhere. They can log straight back in unless they are suspended
+func (h handler) LogoutUser(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	id := ctx.Params("id")
+	if id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	if err := checkCanModerateUser(rctx, h, id); err != nil {
+		return err
+	}
+
+	if err := logoutUser(rctx, h, id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	return nil
+}
+
+// Lists rooms newest first. Can be filtered by the start of the name, whether they are private and the owner
+func (h handler) GetAdminRooms(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	page := ctx.QueryInt("page", 1)
+	if page < 1 {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	offset := (page - 1) * adminPageSize
+
+	search := ctx.Query("search")
+	private := ctx.Query("private")
+	author := ctx.Query("author")
+	if len(search) > 16 || len(author) > 36 ||
+		(private != "" && private != "true" && private != "false") {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	// empty filters match everything
+	filter := `
+	WHERE ($1 = '' OR LOWER(name) LIKE LOWER($1) || '%')
+	AND ($2 = '' OR private = ($2 = 'true'))
+	AND ($3 = '' OR author_id::TEXT = $3)`
+
+	rows, err := h.DB.Query(rctx, `
+	SELECT `+adminRoomColumns+` FROM rooms`+filter+`
+	ORDER BY created_at DESC
+	LIMIT $4 OFFSET $5;
+	`, search, private, author, adminPageSize, offset)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer rows.Close()
+
+	rooms, err := scanAdminRooms(rows)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	rows.Close()
+
+	var count int
+	if err = h.DB.QueryRow(rctx, `
+	SELECT COUNT(*) FROM rooms`+filter+`;
+	`, search, private, author).Scan(&count); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if bytes, err := json.Marshal(responses.AdminRoomsPage{
+		Rooms: rooms,
+		Count: count,
+	}); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
+
+func (h handler) AdminDeleteRoom(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	room_id := ctx.Params("id")
+	if room_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	var exists bool
+	if err := h.DB.QueryRow(rctx, `
+	SELECT EXISTS(SELECT 1 FROM rooms WHERE id = $1);
+	`, room_id).Scan(&exists); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if !exists {
+		return fiber.NewError(fiber.StatusNotFound, "Room not found")
+	}
+
+	if err := removeRoom(rctx, h, room_id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	return nil
+}
+
+// Sends a SYSTEM_ANNOUNCEMENT to every connected socket. Announcements aren't stored
+func (h handler) SendSystemAnnouncement(ctx *fiber.Ctx) error {
+	v := validator.New()
+	body := &validation.SystemAnnouncement{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	content := strings.TrimSpace(body.Content)
+	if content == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	h.SocketServer.SendDataToAll <- socketServer.AllMessageData{
+		Data: socketMessages.SystemAnnouncement{
+			Content:   content,
+			CreatedAt: time.Now().Format(time.RFC3339),
+		},
+		MessageType: "SYSTEM_ANNOUNCEMENT",
+	}
+
+	return nil
+}
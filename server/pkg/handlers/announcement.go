@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/web-stuff-98/psql-social/pkg/helpers/authHelpers"
+	socketMessages "github.com/web-stuff-98/psql-social/pkg/socketMessages"
+	"github.com/web-stuff-98/psql-social/pkg/validation"
+)
+
+/*
+	Only users with permManageChannels can post in announcement channels,
+	everyone else can only read them. A channel override can allow it for
+	a role or a user without giving them permManageChannels for the room. Room owners can follow an announcement channel
+	from another room, new posts in the announcement channel are then
+	mirrored to a channel in their room. Mirrored messages have no author,
+	the source is included instead. Attachments and polls aren't mirrored.
+*/
+
+// Checks that the user is allowed to post in the channel
+func checkCanPost(ctx context.Context, h handler, uid string, channelID string, ownerID string) error {
+	if uid == ownerID {
+		return nil
+	}
+
+	permissions, _, err := getChannelPermissions(ctx, h, uid, channelID)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	if !permissions.has(permSendMessages) {
+		return fmt.Errorf("You do not have permission to post in this channel")
+	}
+
+	var channelType string
+	if err := h.DB.QueryRow(ctx, `
+	SELECT type FROM room_channels WHERE id = $1;
+	`, channelID).Scan(&channelType); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	if channelType == "ANNOUNCEMENT" && !permissions.has(permManageChannels) {
+		return fmt.Errorf("You do not have permission to post in announcement channels")
+	}
+
+	return nil
+}
+
+type followTarget struct {
+	channelID string
+	roomID    string
+	ownerID   string
+}
+
+// Returns the channels following an announcement channel, and the source for messages mirrored to them
+func getFollowTargets(ctx context.Context, h handler, uid string, channelID string) ([]followTarget, *socketMessages.RoomMessageSource, error) {
+	conn, err := h.DB.Acquire(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Release()
+
+	selectStmt, err := conn.Conn().Prepare(ctx, "mirror_announcement_select_follows_stmt", `
+	SELECT target_channels.id,target_rooms.id,target_rooms.author_id,source_channels.name,source_rooms.id,source_rooms.name
+	FROM channel_follows
+	INNER JOIN room_channels AS target_channels ON target_channels.id = channel_follows.target_channel_id
+	INNER JOIN rooms AS target_rooms ON target_rooms.id = target_channels.room_id
+	INNER JOIN room_channels AS source_channels ON source_channels.id = channel_follows.source_channel_id
+	INNER JOIN rooms AS source_rooms ON source_rooms.id = source_channels.room_id
+	WHERE channel_follows.source_channel_id = $1;
+	`)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := conn.Query(ctx, selectStmt.Name, channelID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	targets := []followTarget{}
+	source := &socketMessages.RoomMessageSource{
+		ChannelID: channelID,
+		AuthorID:  uid,
+	}
+	for rows.Next() {
+		var t followTarget
+		if err = rows.Scan(&t.channelID, &t.roomID, &t.ownerID, &source.ChannelName, &source.RoomID, &source.RoomName); err != nil {
+			return nil, nil, err
+		}
+		targets = append(targets, t)
+	}
+
+	return targets, source, nil
+}
+
+// Posts a copy of a message from an announcement channel to the channels following it
+func mirrorAnnouncement(ctx context.Context, h handler, uid string, channelID string, content string) error {
+	targets, source, err := getFollowTargets(ctx, h, uid, channelID)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	for _, t := range targets {
+		if _, err = postRoomMessage(ctx, h, "", t.channelID, t.roomID, t.ownerID, content, roomMessageOpts{
+			source: source,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h handler) FollowChannel(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	v := validator.New()
+	body := &validation.FollowChannel{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	source_id := ctx.Params("id")
+	if source_id == "" || source_id == body.ChannelID {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	// the user needs to be able to read the announcement channel
+	var source_room_id, source_type string
+	if err = conn.QueryRow(rctx, `
+	SELECT room_id,type FROM room_channels WHERE id = $1;
+	`, source_id).Scan(&source_room_id, &source_type); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Channel not found")
+	}
+
+	if err = checkRoomReadAccess(rctx, h, uid, source_room_id); err != nil {
+		return err
+	}
+	permissions, _, err := getChannelPermissions(rctx, h, uid, source_id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if !permissions.has(permViewChannel) {
+		return fiber.NewError(fiber.StatusForbidden, "You do not have permission to view this channel")
+	}
+
+	if source_type != "ANNOUNCEMENT" {
+		return fiber.NewError(fiber.StatusBadRequest, "Only announcement channels can be followed")
+	}
+
+	// the user must own the room the posts are mirrored to
+	var target_room_id, target_author_id string
+	if err = conn.QueryRow(rctx, `
+	SELECT rooms.id,rooms.author_id FROM room_channels
+	INNER JOIN rooms ON rooms.id = room_channels.room_id
+	WHERE room_channels.id = $1;
+	`, body.ChannelID).Scan(&target_room_id, &target_author_id); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Channel not found")
+	}
+	if target_author_id != uid {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+	if target_room_id == source_room_id {
+		return fiber.NewError(fiber.StatusBadRequest, "You cannot follow a channel from the same room")
+	}
+
+	if _, err = conn.Exec(rctx, `
+	INSERT INTO channel_follows (source_channel_id,target_channel_id) VALUES($1,$2) ON CONFLICT DO NOTHING;
+	`, source_id, body.ChannelID); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	ctx.Status(fiber.StatusCreated)
+
+	return nil
+}
+
+// Either the owner of the following room or the owner of the announcement channels room can remove the follow
+func (h handler) UnfollowChannel(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	source_id := ctx.Params("id")
+	target_id := ctx.Params("target")
+	if source_id == "" || target_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	var source_author_id, target_author_id string
+	if err = h.DB.QueryRow(rctx, `
+	SELECT source_rooms.author_id,target_rooms.author_id FROM channel_follows
+	INNER JOIN room_channels AS source_channels ON source_channels.id = channel_follows.source_channel_id
+	INNER JOIN rooms AS source_rooms ON source_rooms.id = source_channels.room_id
+	INNER JOIN room_channels AS target_channels ON target_channels.id = channel_follows.target_channel_id
+	INNER JOIN rooms AS target_rooms ON target_rooms.id = target_channels.room_id
+	WHERE channel_follows.source_channel_id = $1 AND channel_follows.target_channel_id = $2;
+	`, source_id, target_id).Scan(&source_author_id, &target_author_id); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Follow not found")
+	}
+	if uid != source_author_id && uid != target_author_id {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	if _, err = h.DB.Exec(rctx, `
+	DELETE FROM channel_follows WHERE source_channel_id = $1 AND target_channel_id = $2;
+	`, source_id, target_id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	return nil
+}
@@ -55,7 +55,7 @@ func (h handler) CreateAttachmentMetadata(ctx *fiber.Ctx) error {
 		}
 	}
 	if selectDirectMsgStmt, err := conn.Conn().Prepare(rctx, "create_attachment_metadata_select_direct_message_stmt", `
-	SELECT EXISTS(SELECT 1 FROM room_messages WHERE id = $1 AND author_id = $2);
+	SELECT EXISTS(SELECT 1 FROM direct_messages WHERE id = $1 AND author_id = $2);
 	`); err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 	} else {
@@ -63,14 +63,26 @@ func (h handler) CreateAttachmentMetadata(ctx *fiber.Ctx) error {
 			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 		}
 	}
+	var isGroupMsg bool
+	if selectGroupMsgStmt, err := conn.Conn().Prepare(rctx, "create_attachment_metadata_select_group_message_stmt", `
+	SELECT EXISTS(SELECT 1 FROM group_messages WHERE id = $1 AND author_id = $2);
+	`); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		if err = conn.Conn().QueryRow(rctx, selectGroupMsgStmt.Name, body.ID, uid).Scan(&isGroupMsg); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+	}
 
-	if !isRoomMsg && !isDirectMessage {
+	if !isRoomMsg && !isDirectMessage && !isGroupMsg {
 		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
 	}
 
 	var tableName string
 	if isRoomMsg {
 		tableName = "room_message_attachment_metadata"
+	} else if isGroupMsg {
+		tableName = "group_message_attachment_metadata"
 	} else {
 		tableName = "direct_message_attachment_metadata"
 	}
@@ -92,6 +104,8 @@ func (h handler) CreateAttachmentMetadata(ctx *fiber.Ctx) error {
 	var messagesTableName string
 	if isRoomMsg {
 		messagesTableName = "room_messages"
+	} else if isGroupMsg {
+		messagesTableName = "group_messages"
 	} else {
 		messagesTableName = "direct_messages"
 	}
@@ -147,6 +161,27 @@ func (h handler) CreateAttachmentMetadata(ctx *fiber.Ctx) error {
 			},
 			MessageType: "ATTACHMENT_METADATA_CREATED",
 		}
+	} else if isGroupMsg {
+		var group_id string
+		if selectGroupStmt, err := conn.Conn().Prepare(rctx, "create_attachment_metadata_select_group_stmt", `
+		SELECT group_id FROM group_messages WHERE id = $1;
+		`); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		} else {
+			if err = conn.QueryRow(rctx, selectGroupStmt.Name, body.ID).Scan(&group_id); err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+			}
+		}
+		h.SocketServer.SendDataToSub <- socketServer.SubscriptionMessageData{
+			SubName: fmt.Sprintf("group:%v", group_id),
+			Data: socketMessages.AttachmentMetadataCreated{
+				Mime: body.Mime,
+				Size: body.Size,
+				Name: body.Name,
+				ID:   body.ID,
+			},
+			MessageType: "ATTACHMENT_METADATA_CREATED",
+		}
 	} else {
 		var recipient_id string
 		if selectRecipientStmt, err := conn.Conn().Prepare(rctx, "create_attachment_metadata_select_recipient_stmt", `
@@ -208,7 +243,7 @@ func (h handler) UploadAttachmentChunk(ctx *fiber.Ctx) error {
 		}
 	}
 	if selectDirectMsgStmt, err := conn.Conn().Prepare(rctx, "upload_attachment_chunk_select_direct_message_stmt", `
-	SELECT EXISTS(SELECT 1 FROM room_messages WHERE id = $1 AND author_id = $2);
+	SELECT EXISTS(SELECT 1 FROM direct_messages WHERE id = $1 AND author_id = $2);
 	`); err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 	} else {
@@ -216,8 +251,18 @@ func (h handler) UploadAttachmentChunk(ctx *fiber.Ctx) error {
 			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 		}
 	}
+	var isGroupMsg bool
+	if selectGroupMsgStmt, err := conn.Conn().Prepare(rctx, "upload_attachment_chunk_select_group_message_stmt", `
+	SELECT EXISTS(SELECT 1 FROM group_messages WHERE id = $1 AND author_id = $2);
+	`); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		if err = conn.Conn().QueryRow(rctx, selectGroupMsgStmt.Name, id, uid).Scan(&isGroupMsg); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+	}
 
-	if !isRoomMsg && !isDirectMessage {
+	if !isRoomMsg && !isDirectMessage && !isGroupMsg {
 		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
 	}
 
@@ -242,6 +287,29 @@ func (h handler) UploadAttachmentChunk(ctx *fiber.Ctx) error {
 
 		close(recvChan)
 
+		for k := range uidsMap {
+			uids = append(uids, k)
+		}
+	} else if isGroupMsg {
+		var group_id string
+		if selectGroupStmt, err := conn.Conn().Prepare(rctx, "upload_attachment_chunk_select_group_stmt", `
+		SELECT group_id FROM group_messages WHERE id = $1;
+		`); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		} else {
+			if err = conn.QueryRow(rctx, selectGroupStmt.Name, id).Scan(&group_id); err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+			}
+		}
+		recvChan := make(chan map[string]struct{}, 1)
+		h.SocketServer.GetSubscriptionUids <- socketServer.GetSubscriptionUids{
+			SubName:  fmt.Sprintf("group:%v", group_id),
+			RecvChan: recvChan,
+		}
+		uidsMap := <-recvChan
+
+		close(recvChan)
+
 		for k := range uidsMap {
 			uids = append(uids, k)
 		}
@@ -309,7 +377,7 @@ func (h handler) GetAttachmentMetadata(ctx *fiber.Ctx) error {
 		}
 	}
 	if selectDirectMsgStmt, err := conn.Conn().Prepare(rctx, "get_attachment_metadata_select_direct_message_stmt", `
-	SELECT EXISTS(SELECT 1 FROM room_messages WHERE id = $1 AND author_id = $2);
+	SELECT EXISTS(SELECT 1 FROM direct_messages WHERE id = $1 AND author_id = $2);
 	`); err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 	} else {
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/pgtype"
+	"github.com/jackc/pgx/v5"
+	"github.com/web-stuff-98/psql-social/pkg/helpers/authHelpers"
+	"github.com/web-stuff-98/psql-social/pkg/responses"
+)
+
+/*
+	Moderation actions in a room are recorded in the rooms audit log,
+	the action names are listed on responses.AuditLogEntry. Entries are
+	never updated or deleted, except when the room is deleted. The room
+	owner and moderators (see permModeration) can read the log.
+*/
+
+const auditLogPageSize = 30
+
+// Adds an entry to the rooms audit log. actorID and targetID can be empty, before and after can be nil.
+func recordAuditLog(ctx context.Context, h handler, roomID string, actorID string, action string, targetID string, before map[string]interface{}, after map[string]interface{}) error {
+	var actor_id, target_id, before_json, after_json interface{}
+	if actorID != "" {
+		actor_id = actorID
+	}
+	if targetID != "" {
+		target_id = targetID
+	}
+	if before != nil {
+		b, err := json.Marshal(before)
+		if err != nil {
+			return err
+		}
+		before_json = string(b)
+	}
+	if after != nil {
+		b, err := json.Marshal(after)
+		if err != nil {
+			return err
+		}
+		after_json = string(b)
+	}
+
+	_, err := h.DB.Exec(ctx, `
+	INSERT INTO room_audit_log (room_id,actor_id,action,target_id,before,after) VALUES($1,$2,$3,$4,$5,$6);
+	`, roomID, actor_id, action, target_id, before_json, after_json)
+	return err
+}
+
+// Returns the values of a channel that are recorded in the audit log when it changes
+func getChannelAuditValues(ctx context.Context, h handler, channelID string) (map[string]interface{}, error) {
+	var name, channelType, topic string
+	var main bool
+	var slow_mode int
+	var category_id *string
+	var retention_days *int
+	if err := h.DB.QueryRow(ctx, `
+	SELECT name,main,slow_mode,type,topic,category_id::TEXT,retention_days FROM room_channels WHERE id = $1;
+	`, channelID).Scan(&name, &main, &slow_mode, &channelType, &topic, &category_id, &retention_days); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]interface{})
+	values["name"] = name
+	values["main"] = main
+	values["slow_mode"] = slow_mode
+	values["type"] = channelType
+	values["topic"] = topic
+	values["category_id"] = ""
+	if category_id != nil {
+		values["category_id"] = *category_id
+	}
+	values["retention_days"] = retention_days
+	return values, nil
+}
+
+// Lists the rooms audit log newest first. Can be filtered by action, actor and target
+func (h handler) GetRoomAuditLog(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	room_id := ctx.Params("id")
+	if room_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	page := ctx.QueryInt("page", 1)
+	if page < 1 {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	offset := (page - 1) * auditLogPageSize
+
+	action := ctx.Query("action")
+	actor := ctx.Query("actor")
+	target := ctx.Query("target")
+	if len(action) > 16 || len(actor) > 36 || len(target) > 36 {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	permissions, err := getRoomPermissions(rctx, h, uid, room_id)
+	if err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Room not found")
+	}
+	if permissions&permModeration == 0 {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	// empty filters match everything
+	selectStmt, err := conn.Conn().Prepare(rctx, "get_room_audit_log_select_stmt", `
+	SELECT id,action,actor_id::TEXT,target_id::TEXT,before,after,created_at
+	FROM room_audit_log
+	WHERE room_id = $1
+	AND ($2 = '' OR action = $2)
+	AND ($3 = '' OR actor_id::TEXT = $3)
+	AND ($4 = '' OR target_id::TEXT = $4)
+	ORDER BY created_at DESC
+	LIMIT $5 OFFSET $6;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	rows, err := conn.Query(rctx, selectStmt.Name, room_id, action, actor, target, auditLogPageSize, offset)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer rows.Close()
+
+	entries := []responses.AuditLogEntry{}
+	for rows.Next() {
+		var e responses.AuditLogEntry
+		var actor_id, target_id *string
+		var created_at pgtype.Timestamptz
+		if err = rows.Scan(&e.ID, &e.Action, &actor_id, &target_id, &e.Before, &e.After, &created_at); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		if actor_id != nil {
+			e.ActorID = *actor_id
+		}
+		if target_id != nil {
+			e.TargetID = *target_id
+		}
+		e.CreatedAt = created_at.Time.Format(time.RFC3339)
+		entries = append(entries, e)
+	}
+	rows.Close()
+
+	var count int
+	if err = conn.QueryRow(rctx, `
+	SELECT COUNT(*) FROM room_audit_log
+	WHERE room_id = $1
+	AND ($2 = '' OR action = $2)
+	AND ($3 = '' OR actor_id::TEXT = $3)
+	AND ($4 = '' OR target_id::TEXT = $4);
+	`, room_id, action, actor, target).Scan(&count); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if bytes, err := json.Marshal(responses.AuditLogPage{
+		Entries: entries,
+		Count:   count,
+	}); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
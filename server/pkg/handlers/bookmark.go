@@ -0,0 +1,430 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/pgtype"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/web-stuff-98/psql-social/pkg/helpers/authHelpers"
+	"github.com/web-stuff-98/psql-social/pkg/responses"
+	socketMessages "github.com/web-stuff-98/psql-social/pkg/socketMessages"
+	"github.com/web-stuff-98/psql-social/pkg/socketServer"
+	"github.com/web-stuff-98/psql-social/pkg/validation"
+)
+
+/*
+	Users can bookmark any message they can read. Bookmarks are kept when the
+	message is deleted, or when the user can no longer read it (they left the
+	group or lost access to the channel), and show up as unavailable. Access
+	is checked again whenever bookmarks are listed. Changes are sent to the
+	user as CHANGE events with the BOOKMARK entity, so that all of the users
+	tabs stay in sync.
+*/
+
+const bookmarksPageSize = 30
+
+// The message columns are null if the message was deleted
+const selectBookmarksSQL = `
+SELECT bookmarks.id,bookmarks.kind,bookmarks.message_id,bookmarks.note,bookmarks.created_at,
+bookmarks.unavailable OR COALESCE(room_messages.id,direct_messages.id,group_messages.id) IS NULL,
+COALESCE(room_messages.content,direct_messages.content,group_messages.content),
+COALESCE(room_messages.author_id,direct_messages.author_id,group_messages.author_id)::TEXT,
+room_messages.room_channel_id::TEXT,
+room_channels.room_id::TEXT,
+(CASE WHEN direct_messages.author_id = bookmarks.user_id THEN direct_messages.recipient_id ELSE direct_messages.author_id END)::TEXT,
+group_messages.group_id::TEXT
+FROM bookmarks
+LEFT JOIN room_messages ON bookmarks.kind = 'ROOM' AND room_messages.id = bookmarks.message_id
+LEFT JOIN room_channels ON room_channels.id = room_messages.room_channel_id
+LEFT JOIN direct_messages ON bookmarks.kind = 'DIRECT' AND direct_messages.id = bookmarks.message_id
+LEFT JOIN group_messages ON bookmarks.kind = 'GROUP' AND group_messages.id = bookmarks.message_id
+`
+
+func scanBookmark(row pgx.Row) (responses.Bookmark, error) {
+	var b responses.Bookmark
+	var created_at pgtype.Timestamptz
+	var content, author_id, channel_id, room_id, conversee_id, group_id *string
+	if err := row.Scan(&b.ID, &b.Kind, &b.MsgID, &b.Note, &created_at, &b.Unavailable,
+		&content, &author_id, &channel_id, &room_id, &conversee_id, &group_id); err != nil {
+		return b, err
+	}
+	b.CreatedAt = created_at.Time.Format(time.RFC3339)
+	if b.Unavailable {
+		return b, nil
+	}
+	if content != nil {
+		b.Content = *content
+	}
+	if author_id != nil {
+		b.AuthorID = *author_id
+	}
+	if channel_id != nil {
+		b.ChannelID = *channel_id
+	}
+	if room_id != nil {
+		b.RoomID = *room_id
+	}
+	if conversee_id != nil {
+		b.ConverseeID = *conversee_id
+	}
+	if group_id != nil {
+		b.GroupID = *group_id
+	}
+	return b, nil
+}
+
+func bookmarkChangeData(b responses.Bookmark) map[string]interface{} {
+	data := make(map[string]interface{})
+	data["ID"] = b.ID
+	data["kind"] = b.Kind
+	data["msg_id"] = b.MsgID
+	data["note"] = b.Note
+	data["created_at"] = b.CreatedAt
+	data["unavailable"] = b.Unavailable
+	data["content"] = b.Content
+	data["author_id"] = b.AuthorID
+	data["channel_id"] = b.ChannelID
+	data["room_id"] = b.RoomID
+	data["conversee_id"] = b.ConverseeID
+	data["group_id"] = b.GroupID
+	return data
+}
+
+// Returns the kind of message if the user can read it
+func bookmarkMessageKind(ctx context.Context, conn *pgxpool.Conn, h handler, uid string, msgID string) (string, error) {
+	selectStmt, err := conn.Conn().Prepare(ctx, "bookmark_message_kind_select_stmt", `
+	SELECT
+	(SELECT room_channel_id::TEXT FROM room_messages WHERE id = $1),
+	(SELECT author_id = $2 OR recipient_id = $2 FROM direct_messages WHERE id = $1),
+	(SELECT group_id::TEXT FROM group_messages WHERE id = $1);
+	`)
+	if err != nil {
+		return "", fmt.Errorf("Internal error")
+	}
+
+	var channel_id, group_id *string
+	var directParticipant *bool
+	if err = conn.QueryRow(ctx, selectStmt.Name, msgID, uid).Scan(&channel_id, &directParticipant, &group_id); err != nil {
+		return "", fmt.Errorf("Internal error")
+	}
+
+	if channel_id != nil {
+		if _, _, err = roomChannelAccess(ctx, h, uid, *channel_id); err != nil {
+			return "", err
+		}
+		return "ROOM", nil
+	}
+	if directParticipant != nil {
+		if !*directParticipant {
+			return "", fmt.Errorf("Message not found")
+		}
+		return "DIRECT", nil
+	}
+	if group_id != nil {
+		if err = checkGroupMember(ctx, conn, uid, *group_id); err != nil {
+			return "", err
+		}
+		return "GROUP", nil
+	}
+
+	return "", fmt.Errorf("Message not found")
+}
+
+// Checks that the user can still read the bookmarked message, if they can't the message fields are cleared
+// and the bookmark is returned as unavailable. Nothing is stored, so the message comes back if access is regained.
+func checkBookmarkAccess(ctx context.Context, conn *pgxpool.Conn, h handler, uid string, b *responses.Bookmark) error {
+	if b.Unavailable {
+		return nil
+	}
+
+	var err error
+	switch b.Kind {
+	case "ROOM":
+		_, _, err = roomChannelAccess(ctx, h, uid, b.ChannelID)
+	case "GROUP":
+		err = checkGroupMember(ctx, conn, uid, b.GroupID)
+	}
+	if err != nil {
+		if err.Error() == "Internal error" {
+			return err
+		}
+		*b = responses.Bookmark{
+			ID:          b.ID,
+			Kind:        b.Kind,
+			MsgID:       b.MsgID,
+			Note:        b.Note,
+			CreatedAt:   b.CreatedAt,
+			Unavailable: true,
+		}
+	}
+
+	return nil
+}
+
+// Marks the bookmarks for a message that was deleted as unavailable, and tells their users
+func markBookmarksUnavailable(ctx context.Context, h handler, msgID string) error {
+	rows, err := h.DB.Query(ctx, `
+	UPDATE bookmarks SET unavailable = TRUE WHERE message_id = $1 RETURNING id,user_id;
+	`, msgID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, user_id string
+		if err = rows.Scan(&id, &user_id); err != nil {
+			return err
+		}
+		data := make(map[string]interface{})
+		data["ID"] = id
+		data["unavailable"] = true
+		h.SocketServer.SendDataToUser <- socketServer.UserMessageData{
+			Uid: user_id,
+			Data: socketMessages.ChangeEvent{
+				Type:   "UPDATE",
+				Entity: "BOOKMARK",
+				Data:   data,
+			},
+			MessageType: "CHANGE",
+		}
+	}
+
+	return rows.Err()
+}
+
+func (h handler) CreateBookmark(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	v := validator.New()
+	body := &validation.CreateBookmark{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	kind, err := bookmarkMessageKind(rctx, conn, h, uid, body.MsgID)
+	if err != nil {
+		if err.Error() == "Internal error" {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	insertStmt, err := conn.Conn().Prepare(rctx, "create_bookmark_insert_stmt", `
+	INSERT INTO bookmarks (user_id,kind,message_id,note) VALUES($1,$2,$3,$4)
+	ON CONFLICT (user_id,message_id) DO NOTHING
+	RETURNING id;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	var id string
+	if err = conn.QueryRow(rctx, insertStmt.Name, uid, kind, body.MsgID, strings.TrimSpace(body.Note)).Scan(&id); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusBadRequest, "You have already bookmarked this message")
+	}
+
+	bookmark, err := scanBookmark(conn.QueryRow(rctx, selectBookmarksSQL+"WHERE bookmarks.id = $1;", id))
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	h.SocketServer.SendDataToUser <- socketServer.UserMessageData{
+		Uid: uid,
+		Data: socketMessages.ChangeEvent{
+			Type:   "INSERT",
+			Entity: "BOOKMARK",
+			Data:   bookmarkChangeData(bookmark),
+		},
+		MessageType: "CHANGE",
+	}
+
+	ctx.Response().Header.Add("Content-Type", "text/plain")
+	ctx.WriteString(id)
+	ctx.Status(fiber.StatusCreated)
+
+	return nil
+}
+
+func (h handler) GetBookmarks(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	page := ctx.QueryInt("page", 1)
+	if page < 1 {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	offset := (page - 1) * bookmarksPageSize
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	selectStmt, err := conn.Conn().Prepare(rctx, "get_bookmarks_select_stmt", selectBookmarksSQL+`
+	WHERE bookmarks.user_id = $1
+	ORDER BY bookmarks.created_at DESC
+	LIMIT $2 OFFSET $3;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	rows, err := conn.Query(rctx, selectStmt.Name, uid, bookmarksPageSize, offset)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer rows.Close()
+
+	bookmarks := []responses.Bookmark{}
+	for rows.Next() {
+		bookmark, err := scanBookmark(rows)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		bookmarks = append(bookmarks, bookmark)
+	}
+	rows.Close()
+
+	for i := range bookmarks {
+		if err = checkBookmarkAccess(rctx, conn, h, uid, &bookmarks[i]); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+	}
+
+	var count int
+	if err = conn.QueryRow(rctx, `
+	SELECT COUNT(*) FROM bookmarks WHERE user_id = $1;
+	`, uid).Scan(&count); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if bytes, err := json.Marshal(responses.BookmarksPage{
+		Bookmarks: bookmarks,
+		Count:     count,
+	}); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
+
+func (h handler) UpdateBookmark(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	id := ctx.Params("id")
+	if id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	v := validator.New()
+	body := &validation.UpdateBookmark{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	note := strings.TrimSpace(body.Note)
+
+	if tag, err := h.DB.Exec(rctx, `
+	UPDATE bookmarks SET note = $1 WHERE id = $2 AND user_id = $3;
+	`, note, id, uid); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else if tag.RowsAffected() == 0 {
+		return fiber.NewError(fiber.StatusNotFound, "Bookmark not found")
+	}
+
+	data := make(map[string]interface{})
+	data["ID"] = id
+	data["note"] = note
+	h.SocketServer.SendDataToUser <- socketServer.UserMessageData{
+		Uid: uid,
+		Data: socketMessages.ChangeEvent{
+			Type:   "UPDATE",
+			Entity: "BOOKMARK",
+			Data:   data,
+		},
+		MessageType: "CHANGE",
+	}
+
+	return nil
+}
+
+func (h handler) DeleteBookmark(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	id := ctx.Params("id")
+	if id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	if tag, err := h.DB.Exec(rctx, `
+	DELETE FROM bookmarks WHERE id = $1 AND user_id = $2;
+	`, id, uid); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else if tag.RowsAffected() == 0 {
+		return fiber.NewError(fiber.StatusNotFound, "Bookmark not found")
+	}
+
+	data := make(map[string]interface{})
+	data["ID"] = id
+	h.SocketServer.SendDataToUser <- socketServer.UserMessageData{
+		Uid: uid,
+		Data: socketMessages.ChangeEvent{
+			Type:   "DELETE",
+			Entity: "BOOKMARK",
+			Data:   data,
+		},
+		MessageType: "CHANGE",
+	}
+
+	return nil
+}
@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/pgtype"
+	"github.com/jackc/pgx/v5"
+	"github.com/web-stuff-98/psql-social/pkg/helpers/authHelpers"
+	"github.com/web-stuff-98/psql-social/pkg/responses"
+	socketMessages "github.com/web-stuff-98/psql-social/pkg/socketMessages"
+	"github.com/web-stuff-98/psql-social/pkg/socketServer"
+	"github.com/web-stuff-98/psql-social/pkg/validation"
+)
+
+/*
+	Bots are users with bot = TRUE, created by a human owner.
+	They don't have a password, they authenticate by sending
+	"Authorization: Bot <token>" with every request (including
+	the websocket upgrade request). Only the hash of the token
+	is stored, so the token is only returned once when it is
+	generated.
+*/
+
+const maxBotsPerUser = 5
+
+func (h handler) CreateBot(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	v := validator.New()
+	body := &validation.CreateBot{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	selectOwnerStmt, err := conn.Conn().Prepare(rctx, "create_bot_select_owner_stmt", `
+	SELECT bot,(SELECT COUNT(*) FROM users WHERE owner_id = $1) FROM users WHERE id = $1;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	var isBot bool
+	var count int
+	if err = conn.QueryRow(rctx, selectOwnerStmt.Name, uid).Scan(&isBot, &count); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if isBot {
+		return fiber.NewError(fiber.StatusForbidden, "Bots cannot create bots")
+	}
+	if count >= maxBotsPerUser {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("You cannot have more than %v bots", maxBotsPerUser))
+	}
+
+	existsStmt, err := conn.Conn().Prepare(rctx, "create_bot_exists_stmt", `
+	SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(username) = LOWER($1));
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	var exists bool
+	if err = conn.QueryRow(rctx, existsStmt.Name, strings.TrimSpace(body.Username)).Scan(&exists); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if exists {
+		return fiber.NewError(fiber.StatusBadRequest, "There is already another user using that name")
+	}
+
+	filtered, allowed, err := filterName(rctx, h, uid, "USERNAME", "", strings.TrimSpace(body.Username))
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if !allowed {
+		return fiber.NewError(fiber.StatusBadRequest, "That username is not allowed")
+	}
+
+	token, err := authHelpers.GenerateBotToken()
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	tx, err := conn.Begin(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer tx.Rollback(rctx)
+
+	// bots have no password, so they can never log in with the login route
+	var id string
+	if err = tx.QueryRow(rctx, `
+	INSERT INTO users (username, password, role, bot, owner_id) VALUES ($1, '', 'USER', TRUE, $2) RETURNING id;
+	`, strings.TrimSpace(body.Username), uid).Scan(&id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if _, err = tx.Exec(rctx, `
+	INSERT INTO bot_tokens (bot_id, token_hash) VALUES ($1, $2);
+	`, id, authHelpers.HashBotToken(token)); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if err = tx.Commit(rctx); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if err = recordFilterMatches(rctx, h, uid, "USERNAME", id, "", strings.TrimSpace(body.Username), filtered); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if bytes, err := json.Marshal(responses.BotToken{
+		ID:    id,
+		Token: token,
+	}); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Status(fiber.StatusCreated)
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
+
+func (h handler) GetBots(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	selectBotsStmt, err := conn.Conn().Prepare(rctx, "get_bots_select_stmt", `
+	SELECT users.id,users.username,bot_tokens.created_at FROM users
+	INNER JOIN bot_tokens ON bot_tokens.bot_id = users.id
+	WHERE users.owner_id = $1 ORDER BY users.username ASC;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	rows, err := conn.Query(rctx, selectBotsStmt.Name, uid)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer rows.Close()
+
+	bots := []responses.Bot{}
+
+	for rows.Next() {
+		var id, username string
+		var created_at pgtype.Timestamptz
+		if err = rows.Scan(&id, &username, &created_at); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		bots = append(bots, responses.Bot{
+			ID:        id,
+			Username:  username,
+			CreatedAt: created_at.Time.Format(time.RFC3339),
+		})
+	}
+
+	if bytes, err := json.Marshal(bots); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
+
+// Replaces the bots token, the old token stops working and the bot is disconnected
+func (h handler) RegenerateBotToken(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	bot_id := ctx.Params("id")
+	if bot_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	token, err := authHelpers.GenerateBotToken()
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	updateStmt, err := conn.Conn().Prepare(rctx, "regenerate_bot_token_update_stmt", `
+	UPDATE bot_tokens SET token_hash = $1, created_at = NOW()
+	WHERE bot_id = (SELECT id FROM users WHERE id = $2 AND owner_id = $3 AND bot = TRUE)
+	RETURNING bot_id;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	var id string
+	if err = conn.QueryRow(rctx, updateStmt.Name, authHelpers.HashBotToken(token), bot_id, uid).Scan(&id); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Bot not found")
+	}
+
+	h.SocketServer.CloseConnChan <- id
+
+	if bytes, err := json.Marshal(responses.BotToken{
+		ID:    id,
+		Token: token,
+	}); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
+
+func (h handler) DeleteBot(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	bot_id := ctx.Params("id")
+	if bot_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	deleteStmt, err := conn.Conn().Prepare(rctx, "delete_bot_stmt", `
+	DELETE FROM users WHERE id = $1 AND owner_id = $2 AND bot = TRUE RETURNING id;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	var id string
+	if err = conn.QueryRow(rctx, deleteStmt.Name, bot_id, uid).Scan(&id); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Bot not found")
+	}
+
+	h.SocketServer.CloseConnChan <- id
+
+	changeData := make(map[string]interface{})
+	changeData["ID"] = id
+	h.SocketServer.SendDataToSub <- socketServer.SubscriptionMessageData{
+		SubName: fmt.Sprintf("user:%v", id),
+		Data: socketMessages.ChangeEvent{
+			Type:   "DELETE",
+			Data:   changeData,
+			Entity: "USER",
+		},
+		MessageType: "CHANGE",
+	}
+
+	return nil
+}
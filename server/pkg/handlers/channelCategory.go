@@ -0,0 +1,593 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/web-stuff-98/psql-social/pkg/helpers/authHelpers"
+	"github.com/web-stuff-98/psql-social/pkg/responses"
+	socketMessages "github.com/web-stuff-98/psql-social/pkg/socketMessages"
+	"github.com/web-stuff-98/psql-social/pkg/socketServer"
+	"github.com/web-stuff-98/psql-social/pkg/validation"
+)
+
+/*
+	Users with the manage channels permission can group channels into
+	categories, and set the order categories and channels are listed in.
+	Channels without a category are listed above the categories. Changes are sent to room:<id> as well
+	as the channel subscriptions, so the channel list updates for members
+	who aren't viewing a channel.
+*/
+
+// Rooms can have up to 50 categories
+const maxChannelCategories = 50
+
+// Returns an error if the user can't modify the rooms channels
+func checkCanModifyChannels(ctx context.Context, h handler, uid string, roomID string) error {
+	var seeded bool
+	if err := h.DB.QueryRow(ctx, `
+	SELECT seeded FROM rooms WHERE id = $1;
+	`, roomID).Scan(&seeded); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Room not found")
+	}
+	if seeded {
+		return fiber.NewError(fiber.StatusBadRequest, "You cannot modify the example rooms")
+	}
+	return requireRoomPermission(ctx, h, uid, roomID, permManageChannels)
+}
+
+// Checks that the category is in the room. Returns nil if no category was given
+func checkChannelCategory(ctx context.Context, h handler, categoryID *string, roomID string) (*string, error) {
+	if categoryID == nil || *categoryID == "" {
+		return nil, nil
+	}
+
+	var exists bool
+	if err := h.DB.QueryRow(ctx, `
+	SELECT EXISTS(SELECT 1 FROM room_channel_categories WHERE id = $1 AND room_id = $2);
+	`, *categoryID, roomID).Scan(&exists); err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if !exists {
+		return nil, fiber.NewError(fiber.StatusNotFound, "Category not found")
+	}
+
+	return categoryID, nil
+}
+
+// Sends a change event to the room subscription and all of the rooms channel subscriptions. Only for room wide
+// events, events about a single channel go through sendChannelChange so that hidden channels aren't leaked
+func sendRoomChannelsChange(ctx context.Context, h handler, roomID string, event socketMessages.ChangeEvent) error {
+	rows, err := h.DB.Query(ctx, `
+	SELECT id FROM room_channels WHERE room_id = $1;
+	`, roomID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	subNames := []string{fmt.Sprintf("room:%v", roomID)}
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return err
+		}
+		subNames = append(subNames, fmt.Sprintf("channel:%v", id))
+	}
+
+	h.SocketServer.SendDataToSubs <- socketServer.SubscriptionsMessageData{
+		SubNames:    subNames,
+		Data:        event,
+		MessageType: "CHANGE",
+	}
+
+	return nil
+}
+
+// Sends the new order of the rooms categories and channels. Each user only gets the channels they can view.
+func sendChannelOrderChange(ctx context.Context, h handler, roomID string, categories []string, channels []map[string]interface{}) error {
+	uids, err := getRoomSubscriberUids(ctx, h, roomID)
+	if err != nil {
+		return err
+	}
+
+	for uid := range uids {
+		channelPermissions, err := getRoomChannelsPermissions(ctx, h, uid, roomID, "")
+		if err != nil {
+			return err
+		}
+
+		visibleChannels := []map[string]interface{}{}
+		for _, c := range channels {
+			if channelPermissions[c["ID"].(string)].has(permViewChannel) {
+				visibleChannels = append(visibleChannels, c)
+			}
+		}
+
+		changeData := make(map[string]interface{})
+		changeData["ID"] = roomID
+		changeData["categories"] = categories
+		changeData["channels"] = visibleChannels
+		h.SocketServer.SendDataToUser <- socketServer.UserMessageData{
+			Uid: uid,
+			Data: socketMessages.ChangeEvent{
+				Type:   "UPDATE",
+				Entity: "CHANNEL_ORDER",
+				Data:   changeData,
+			},
+			MessageType: "CHANGE",
+		}
+	}
+
+	return nil
+}
+
+func (h handler) CreateChannelCategory(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	v := validator.New()
+	body := &validation.CreateUpdateChannelCategory{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	room_id := ctx.Params("id")
+	if room_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	if err = checkCanModifyChannels(rctx, h, uid, room_id); err != nil {
+		return err
+	}
+
+	var count int
+	if err = h.DB.QueryRow(rctx, `
+	SELECT COUNT(*) FROM room_channel_categories WHERE room_id = $1;
+	`, room_id).Scan(&count); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if count >= maxChannelCategories {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Rooms can only have %v categories", maxChannelCategories))
+	}
+
+	filtered, allowed, err := filterName(rctx, h, uid, "CATEGORY_NAME", "", name)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if !allowed {
+		return fiber.NewError(fiber.StatusBadRequest, "That category name is not allowed")
+	}
+
+	// new categories go to the bottom of the list
+	var id string
+	var position int
+	if err = h.DB.QueryRow(rctx, `
+	INSERT INTO room_channel_categories (room_id,name,position)
+	VALUES($1,$2,(SELECT COALESCE(MAX(position) + 1, 0) FROM room_channel_categories WHERE room_id = $1))
+	RETURNING id,position;
+	`, room_id, name).Scan(&id, &position); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if err = recordFilterMatches(rctx, h, uid, "CATEGORY_NAME", id, "", name, filtered); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	after := make(map[string]interface{})
+	after["name"] = name
+	after["position"] = position
+	if err = recordAuditLog(rctx, h, room_id, uid, "CATEGORY_CREATE", id, nil, after); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	changeData := make(map[string]interface{})
+	changeData["ID"] = id
+	changeData["room_id"] = room_id
+	changeData["name"] = name
+	changeData["position"] = position
+	if err = sendRoomChannelsChange(rctx, h, room_id, socketMessages.ChangeEvent{
+		Type:   "INSERT",
+		Entity: "CATEGORY",
+		Data:   changeData,
+	}); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	ctx.Response().Header.Add("Content-Type", "text/plain")
+	ctx.WriteString(id)
+	ctx.Status(fiber.StatusCreated)
+
+	return nil
+}
+
+func (h handler) GetChannelCategories(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	room_id := ctx.Params("id")
+	if room_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	if err = checkRoomReadAccess(rctx, h, uid, room_id); err != nil {
+		return err
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	selectStmt, err := conn.Conn().Prepare(rctx, "get_channel_categories_select_stmt", `
+	SELECT id,name,position FROM room_channel_categories WHERE room_id = $1 ORDER BY position, name;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	rows, err := conn.Query(rctx, selectStmt.Name, room_id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer rows.Close()
+
+	categories := []responses.RoomChannelCategory{}
+	for rows.Next() {
+		var c responses.RoomChannelCategory
+		if err = rows.Scan(&c.ID, &c.Name, &c.Position); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		categories = append(categories, c)
+	}
+	rows.Close()
+
+	if bytes, err := json.Marshal(categories); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
+
+func (h handler) UpdateChannelCategory(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	v := validator.New()
+	body := &validation.CreateUpdateChannelCategory{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	id := ctx.Params("id")
+	if id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	var room_id, prevName string
+	if err = h.DB.QueryRow(rctx, `
+	SELECT room_id,name FROM room_channel_categories WHERE id = $1;
+	`, id).Scan(&room_id, &prevName); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Category not found")
+	}
+
+	if err = checkCanModifyChannels(rctx, h, uid, room_id); err != nil {
+		return err
+	}
+
+	filtered, allowed, err := filterName(rctx, h, uid, "CATEGORY_NAME", id, name)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if !allowed {
+		return fiber.NewError(fiber.StatusBadRequest, "That category name is not allowed")
+	}
+
+	if _, err = h.DB.Exec(rctx, `
+	UPDATE room_channel_categories SET name = $1 WHERE id = $2;
+	`, name, id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if err = recordFilterMatches(rctx, h, uid, "CATEGORY_NAME", id, "", name, filtered); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	before := make(map[string]interface{})
+	before["name"] = prevName
+	after := make(map[string]interface{})
+	after["name"] = name
+	if err = recordAuditLog(rctx, h, room_id, uid, "CATEGORY_UPDATE", id, before, after); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	changeData := make(map[string]interface{})
+	changeData["ID"] = id
+	changeData["name"] = name
+	if err = sendRoomChannelsChange(rctx, h, room_id, socketMessages.ChangeEvent{
+		Type:   "UPDATE",
+		Entity: "CATEGORY",
+		Data:   changeData,
+	}); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	return nil
+}
+
+// Channels in the category are kept, they are moved out of the category
+func (h handler) DeleteChannelCategory(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	id := ctx.Params("id")
+	if id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	var room_id, name string
+	var position int
+	if err = h.DB.QueryRow(rctx, `
+	SELECT room_id,name,position FROM room_channel_categories WHERE id = $1;
+	`, id).Scan(&room_id, &name, &position); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Category not found")
+	}
+
+	if err = checkCanModifyChannels(rctx, h, uid, room_id); err != nil {
+		return err
+	}
+
+	if _, err = h.DB.Exec(rctx, `
+	DELETE FROM room_channel_categories WHERE id = $1;
+	`, id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	before := make(map[string]interface{})
+	before["name"] = name
+	before["position"] = position
+	if err = recordAuditLog(rctx, h, room_id, uid, "CATEGORY_DELETE", id, before, nil); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	changeData := make(map[string]interface{})
+	changeData["ID"] = id
+	if err = sendRoomChannelsChange(rctx, h, room_id, socketMessages.ChangeEvent{
+		Type:   "DELETE",
+		Entity: "CATEGORY",
+		Data:   changeData,
+	}); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	return nil
+}
+
+// Sets the position of every category and channel in the room, and which category each channel is in
+func (h handler) UpdateChannelOrder(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	v := validator.New()
+	body := &validation.UpdateChannelOrder{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	room_id := ctx.Params("id")
+	if room_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	if err = checkCanModifyChannels(rctx, h, uid, room_id); err != nil {
+		return err
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	tx, err := conn.Begin(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer tx.Rollback(rctx)
+
+	// lock the rooms channels and categories so they can't be created or deleted while reordering
+	// the previous positions are kept for the audit log
+	categoryIDs := make(map[string]int)
+	if rows, err := tx.Query(rctx, `
+	SELECT id,position FROM room_channel_categories WHERE room_id = $1 FOR UPDATE;
+	`, room_id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		defer rows.Close()
+		for rows.Next() {
+			var id string
+			var position int
+			if err = rows.Scan(&id, &position); err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+			}
+			categoryIDs[id] = position
+		}
+		rows.Close()
+	}
+
+	type channelPlacement struct {
+		position   int
+		categoryID string
+	}
+	channelIDs := make(map[string]channelPlacement)
+	if rows, err := tx.Query(rctx, `
+	SELECT id,position,COALESCE(category_id::TEXT, '') FROM room_channels WHERE room_id = $1 FOR UPDATE;
+	`, room_id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		defer rows.Close()
+		for rows.Next() {
+			var id string
+			var p channelPlacement
+			if err = rows.Scan(&id, &p.position, &p.categoryID); err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+			}
+			channelIDs[id] = p
+		}
+		rows.Close()
+	}
+
+	// every category and channel must be included exactly once
+	if len(body.Categories) != len(categoryIDs) || len(body.Channels) != len(channelIDs) {
+		return fiber.NewError(fiber.StatusBadRequest, "Every category and channel in the room must be included")
+	}
+	seen := make(map[string]struct{})
+	for _, id := range body.Categories {
+		if _, ok := categoryIDs[id]; !ok {
+			return fiber.NewError(fiber.StatusBadRequest, "Category not found")
+		}
+		if _, ok := seen[id]; ok {
+			return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+		}
+		seen[id] = struct{}{}
+	}
+	for _, c := range body.Channels {
+		if _, ok := channelIDs[c.ID]; !ok {
+			return fiber.NewError(fiber.StatusBadRequest, "Channel not found")
+		}
+		if _, ok := seen[c.ID]; ok {
+			return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+		}
+		seen[c.ID] = struct{}{}
+		if c.CategoryID != "" {
+			if _, ok := categoryIDs[c.CategoryID]; !ok {
+				return fiber.NewError(fiber.StatusBadRequest, "Category not found")
+			}
+		}
+	}
+
+	for i, id := range body.Categories {
+		if _, err = tx.Exec(rctx, `
+		UPDATE room_channel_categories SET position = $1 WHERE id = $2;
+		`, i, id); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+	}
+	outChannels := []map[string]interface{}{}
+	for i, c := range body.Channels {
+		var category_id *string
+		if c.CategoryID != "" {
+			category_id = &c.CategoryID
+		}
+		if _, err = tx.Exec(rctx, `
+		UPDATE room_channels SET position = $1, category_id = $2 WHERE id = $3;
+		`, i, category_id, c.ID); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		outChannels = append(outChannels, map[string]interface{}{
+			"ID":          c.ID,
+			"category_id": c.CategoryID,
+			"position":    i,
+		})
+	}
+
+	if err = tx.Commit(rctx); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	// only the categories and channels that moved are recorded
+	for i, id := range body.Categories {
+		if categoryIDs[id] == i {
+			continue
+		}
+		before := make(map[string]interface{})
+		before["position"] = categoryIDs[id]
+		after := make(map[string]interface{})
+		after["position"] = i
+		if err = recordAuditLog(rctx, h, room_id, uid, "CATEGORY_UPDATE", id, before, after); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+	}
+	for i, c := range body.Channels {
+		prev := channelIDs[c.ID]
+		if prev.position == i && prev.categoryID == c.CategoryID {
+			continue
+		}
+		before := make(map[string]interface{})
+		before["position"] = prev.position
+		before["category_id"] = prev.categoryID
+		after := make(map[string]interface{})
+		after["position"] = i
+		after["category_id"] = c.CategoryID
+		if err = recordAuditLog(rctx, h, room_id, uid, "CHANNEL_UPDATE", c.ID, before, after); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+	}
+
+	if err = sendChannelOrderChange(rctx, h, room_id, body.Categories, outChannels); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	return nil
+}
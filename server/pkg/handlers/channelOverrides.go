@@ -0,0 +1,613 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/web-stuff-98/psql-social/pkg/helpers/authHelpers"
+	"github.com/web-stuff-98/psql-social/pkg/responses"
+	socketMessages "github.com/web-stuff-98/psql-social/pkg/socketMessages"
+	"github.com/web-stuff-98/psql-social/pkg/socketServer"
+	"github.com/web-stuff-98/psql-social/pkg/validation"
+)
+
+/*
+	Channel overrides allow or deny permissions in a single channel, for
+	everyone in the room, for a role or for a user. Denying permViewChannel
+	for everyone and allowing it for a role makes a staff only channel.
+	Overrides don't apply to the owner of the room.
+
+	Override targets are given in the url as "everyone", "role:<id>" or
+	"user:<id>".
+*/
+
+type channelOverrideSet struct {
+	everyoneAllow, everyoneDeny roomPermissions
+	rolesAllow, rolesDeny       roomPermissions
+	userAllow, userDeny         roomPermissions
+}
+
+func (o channelOverrideSet) apply(permissions roomPermissions) roomPermissions {
+	permissions = (permissions &^ o.everyoneDeny) | o.everyoneAllow
+	permissions = (permissions &^ o.rolesDeny) | o.rolesAllow
+	return (permissions &^ o.userDeny) | o.userAllow
+}
+
+// Returns the users permissions in each of the rooms channels, or only in one channel if channelID isn't empty
+func getRoomChannelsPermissions(ctx context.Context, h handler, uid string, roomID string, channelID string) (map[string]roomPermissions, error) {
+	var author_id string
+	if err := h.DB.QueryRow(ctx, `
+	SELECT author_id FROM rooms WHERE id = $1;
+	`, roomID).Scan(&author_id); err != nil {
+		return nil, err
+	}
+
+	permissions, err := getRoomPermissions(ctx, h, uid, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := h.DB.Query(ctx, `
+	SELECT id FROM room_channels WHERE room_id = $1 AND ($2 = '' OR id::TEXT = $2);
+	`, roomID, channelID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := make(map[string]*channelOverrideSet)
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		overrides[id] = &channelOverrideSet{}
+	}
+	rows.Close()
+
+	channelPermissions := make(map[string]roomPermissions)
+
+	// the owner has every permission and banned users have none, overrides don't change that
+	if author_id == uid || permissions == 0 {
+		for id := range overrides {
+			channelPermissions[id] = permissions
+		}
+		return channelPermissions, nil
+	}
+
+	rows, err = h.DB.Query(ctx, `
+	SELECT channel_overrides.channel_id,channel_overrides.user_id IS NOT NULL,channel_overrides.role_id IS NOT NULL,allow,deny
+	FROM channel_overrides
+	INNER JOIN room_channels ON room_channels.id = channel_overrides.channel_id
+	WHERE room_channels.room_id = $1 AND ($2 = '' OR room_channels.id::TEXT = $2) AND (
+		channel_overrides.user_id = $3
+		OR channel_overrides.role_id IN (SELECT role_id FROM member_roles WHERE user_id = $3 AND room_id = $1)
+		OR (channel_overrides.user_id IS NULL AND channel_overrides.role_id IS NULL)
+	);
+	`, roomID, channelID, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var isUser, isRole bool
+		var allow, deny int64
+		if err = rows.Scan(&id, &isUser, &isRole, &allow, &deny); err != nil {
+			return nil, err
+		}
+		o, ok := overrides[id]
+		if !ok {
+			continue
+		}
+		switch {
+		case isUser:
+			o.userAllow, o.userDeny = roomPermissions(allow), roomPermissions(deny)
+		case isRole:
+			o.rolesAllow |= roomPermissions(allow)
+			o.rolesDeny |= roomPermissions(deny)
+		default:
+			o.everyoneAllow, o.everyoneDeny = roomPermissions(allow), roomPermissions(deny)
+		}
+	}
+	rows.Close()
+
+	for id, o := range overrides {
+		channelPermissions[id] = o.apply(permissions)
+	}
+
+	return channelPermissions, nil
+}
+
+// Returns the users permissions in the channel, and the ID of the room. Returns pgx.ErrNoRows if the channel doesn't exist
+func getChannelPermissions(ctx context.Context, h handler, uid string, channelID string) (roomPermissions, string, error) {
+	var room_id string
+	if err := h.DB.QueryRow(ctx, `
+	SELECT room_id FROM room_channels WHERE id = $1;
+	`, channelID).Scan(&room_id); err != nil {
+		return 0, "", err
+	}
+
+	channelPermissions, err := getRoomChannelsPermissions(ctx, h, uid, room_id, channelID)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return channelPermissions[channelID], room_id, nil
+}
+
+// Returns an error if the user can't view the channel, for socket event handlers
+func checkCanViewChannel(ctx context.Context, h handler, uid string, channelID string) error {
+	permissions, _, err := getChannelPermissions(ctx, h, uid, channelID)
+	if err != nil {
+		if err != pgx.ErrNoRows {
+			return fmt.Errorf("Internal error")
+		}
+		return fmt.Errorf("Channel not found")
+	}
+	if !permissions.has(permViewChannel) {
+		return fmt.Errorf("You do not have permission to view this channel")
+	}
+	return nil
+}
+
+// Returns the uids of the users subscribed to the room, or to any of its channels
+func getRoomSubscriberUids(ctx context.Context, h handler, roomID string) (map[string]struct{}, error) {
+	rows, err := h.DB.Query(ctx, `
+	SELECT id FROM room_channels WHERE room_id = $1;
+	`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subNames := []string{fmt.Sprintf("room:%v", roomID)}
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		subNames = append(subNames, fmt.Sprintf("channel:%v", id))
+	}
+	rows.Close()
+
+	uids := make(map[string]struct{})
+	for _, subName := range subNames {
+		recvChan := make(chan map[string]struct{})
+		h.SocketServer.GetSubscriptionUids <- socketServer.GetSubscriptionUids{
+			SubName:  subName,
+			RecvChan: recvChan,
+		}
+		for uid := range <-recvChan {
+			uids[uid] = struct{}{}
+		}
+	}
+
+	return uids, nil
+}
+
+// Sends a change event about a channel to the users subscribed to the room or its channels. Users who can't
+// view the channel get a DELETE with only the channel ID instead (nothing for INSERT), so hidden channels aren't leaked.
+func sendChannelChange(ctx context.Context, h handler, roomID string, channelID string, event socketMessages.ChangeEvent) error {
+	uids, err := getRoomSubscriberUids(ctx, h, roomID)
+	if err != nil {
+		return err
+	}
+
+	hiddenData := make(map[string]interface{})
+	hiddenData["ID"] = channelID
+
+	for uid := range uids {
+		permissions, _, err := getChannelPermissions(ctx, h, uid, channelID)
+		if err != nil {
+			return err
+		}
+		if permissions.has(permViewChannel) {
+			h.SocketServer.SendDataToUser <- socketServer.UserMessageData{
+				Uid:         uid,
+				Data:        event,
+				MessageType: "CHANGE",
+			}
+		} else if event.Type != "INSERT" {
+			h.SocketServer.SendDataToUser <- socketServer.UserMessageData{
+				Uid: uid,
+				Data: socketMessages.ChangeEvent{
+					Type:   "DELETE",
+					Entity: "CHANNEL",
+					Data:   hiddenData,
+				},
+				MessageType: "CHANGE",
+			}
+		}
+	}
+
+	return nil
+}
+
+// Takes users who can no longer view the channel out of the channel subscription
+func removeHiddenChannelSubs(ctx context.Context, h handler, channelID string) error {
+	subName := fmt.Sprintf("channel:%v", channelID)
+
+	recvChan := make(chan map[string]struct{})
+	h.SocketServer.GetSubscriptionUids <- socketServer.GetSubscriptionUids{
+		SubName:  subName,
+		RecvChan: recvChan,
+	}
+	uids := <-recvChan
+
+	for uid := range uids {
+		permissions, _, err := getChannelPermissions(ctx, h, uid, channelID)
+		if err != nil {
+			return err
+		}
+		if permissions.has(permViewChannel) {
+			continue
+		}
+
+		connChan := make(chan *websocket.Conn, 1)
+		h.SocketServer.GetConnection <- socketServer.GetConnection{
+			RecvChan: connChan,
+			Uid:      uid,
+		}
+		c := <-connChan
+		close(connChan)
+
+		if c != nil {
+			h.SocketServer.LeaveSubscriptionByWs <- socketServer.RegisterUnregisterSubsConnWs{
+				Conn:    c,
+				SubName: subName,
+			}
+		}
+	}
+
+	return nil
+}
+
+// Runs removeHiddenChannelSubs for every channel in the room, for when roles change
+func removeHiddenRoomSubs(ctx context.Context, h handler, roomID string) error {
+	rows, err := h.DB.Query(ctx, `
+	SELECT id FROM room_channels WHERE room_id = $1;
+	`, roomID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if err = removeHiddenChannelSubs(ctx, h, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Parses "everyone", "role:<id>" or "user:<id>", and checks that the role or user exists
+func parseOverrideTarget(ctx context.Context, h handler, target string, roomID string) (kind string, id string, err error) {
+	if target == "everyone" {
+		return "EVERYONE", "", nil
+	}
+
+	kind, id, found := strings.Cut(target, ":")
+	if !found || id == "" {
+		return "", "", fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	var exists bool
+	switch kind {
+	case "role":
+		if err = h.DB.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM room_roles WHERE id = $1 AND room_id = $2);
+		`, id, roomID).Scan(&exists); err != nil {
+			return "", "", fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		if !exists {
+			return "", "", fiber.NewError(fiber.StatusNotFound, "Role not found")
+		}
+		return "ROLE", id, nil
+	case "user":
+		if err = h.DB.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM users WHERE id = $1);
+		`, id).Scan(&exists); err != nil {
+			return "", "", fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		if !exists {
+			return "", "", fiber.NewError(fiber.StatusNotFound, "User not found")
+		}
+		return "USER", id, nil
+	}
+
+	return "", "", fiber.NewError(fiber.StatusBadRequest, "Bad request")
+}
+
+// Returns the audit log values of the override, or nil if there is no override for the target
+func getChannelOverrideAuditValues(ctx context.Context, h handler, channelID string, kind string, targetID string) (map[string]interface{}, error) {
+	var user_id, role_id string
+	switch kind {
+	case "USER":
+		user_id = targetID
+	case "ROLE":
+		role_id = targetID
+	}
+
+	var allow, deny int64
+	if err := h.DB.QueryRow(ctx, `
+	SELECT allow,deny FROM channel_overrides
+	WHERE channel_id = $1 AND COALESCE(user_id::TEXT, '') = $2 AND COALESCE(role_id::TEXT, '') = $3;
+	`, channelID, user_id, role_id).Scan(&allow, &deny); err != nil {
+		if err != pgx.ErrNoRows {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	values := make(map[string]interface{})
+	values["type"] = kind
+	values["target_id"] = targetID
+	values["allow"] = allow
+	values["deny"] = deny
+	return values, nil
+}
+
+// Returns the ID of the channels room, if the user can manage its channels
+func checkCanManageChannel(ctx context.Context, h handler, uid string, channelID string) (string, error) {
+	var room_id string
+	if err := h.DB.QueryRow(ctx, `
+	SELECT room_id FROM room_channels WHERE id = $1;
+	`, channelID).Scan(&room_id); err != nil {
+		if err != pgx.ErrNoRows {
+			return "", fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return "", fiber.NewError(fiber.StatusNotFound, "Channel not found")
+	}
+
+	if err := checkCanModifyChannels(ctx, h, uid, room_id); err != nil {
+		return "", err
+	}
+
+	return room_id, nil
+}
+
+func (h handler) GetChannelOverrides(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	channel_id := ctx.Params("id")
+	if channel_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	var room_id string
+	if err = h.DB.QueryRow(rctx, `
+	SELECT room_id FROM room_channels WHERE id = $1;
+	`, channel_id).Scan(&room_id); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Channel not found")
+	}
+	if err = requireRoomPermission(rctx, h, uid, room_id, permManageChannels); err != nil {
+		return err
+	}
+
+	rows, err := h.DB.Query(rctx, `
+	SELECT user_id,role_id,allow,deny FROM channel_overrides WHERE channel_id = $1;
+	`, channel_id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer rows.Close()
+
+	overrides := []responses.ChannelOverride{}
+	for rows.Next() {
+		var user_id, role_id *string
+		var o responses.ChannelOverride
+		if err = rows.Scan(&user_id, &role_id, &o.Allow, &o.Deny); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		switch {
+		case user_id != nil:
+			o.Type = "USER"
+			o.TargetID = *user_id
+		case role_id != nil:
+			o.Type = "ROLE"
+			o.TargetID = *role_id
+		default:
+			o.Type = "EVERYONE"
+		}
+		overrides = append(overrides, o)
+	}
+	rows.Close()
+
+	if bytes, err := json.Marshal(overrides); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
+
+func (h handler) UpdateChannelOverride(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	v := validator.New()
+	body := &validation.UpdateChannelOverride{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if body.Allow&body.Deny != 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "A permission cannot be allowed and denied")
+	}
+
+	channel_id := ctx.Params("id")
+	if channel_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	room_id, err := checkCanManageChannel(rctx, h, uid, channel_id)
+	if err != nil {
+		return err
+	}
+
+	kind, target_id, err := parseOverrideTarget(rctx, h, ctx.Params("target"), room_id)
+	if err != nil {
+		return err
+	}
+
+	var user_id, role_id *string
+	switch kind {
+	case "USER":
+		user_id = &target_id
+	case "ROLE":
+		role_id = &target_id
+	}
+
+	before, err := getChannelOverrideAuditValues(rctx, h, channel_id, kind, target_id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if _, err = h.DB.Exec(rctx, `
+	INSERT INTO channel_overrides (channel_id,user_id,role_id,allow,deny) VALUES($1,$2,$3,$4,$5)
+	ON CONFLICT (channel_id, COALESCE(user_id, role_id, channel_id)) DO UPDATE SET allow = EXCLUDED.allow, deny = EXCLUDED.deny;
+	`, channel_id, user_id, role_id, body.Allow, body.Deny); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	after := make(map[string]interface{})
+	after["type"] = kind
+	after["target_id"] = target_id
+	after["allow"] = body.Allow
+	after["deny"] = body.Deny
+	if err = recordAuditLog(rctx, h, room_id, uid, "OVERRIDE_UPDATE", channel_id, before, after); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if err = removeHiddenChannelSubs(rctx, h, channel_id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	changeData := make(map[string]interface{})
+	changeData["ID"] = channel_id
+	changeData["type"] = kind
+	changeData["target_id"] = target_id
+	changeData["allow"] = body.Allow
+	changeData["deny"] = body.Deny
+	if err = sendChannelChange(rctx, h, room_id, channel_id, socketMessages.ChangeEvent{
+		Type:   "UPDATE",
+		Entity: "CHANNEL_OVERRIDE",
+		Data:   changeData,
+	}); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	return nil
+}
+
+func (h handler) DeleteChannelOverride(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	channel_id := ctx.Params("id")
+	if channel_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	room_id, err := checkCanManageChannel(rctx, h, uid, channel_id)
+	if err != nil {
+		return err
+	}
+
+	kind, target_id, err := parseOverrideTarget(rctx, h, ctx.Params("target"), room_id)
+	if err != nil {
+		return err
+	}
+
+	before, err := getChannelOverrideAuditValues(rctx, h, channel_id, kind, target_id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	switch kind {
+	case "USER":
+		_, err = h.DB.Exec(rctx, `
+		DELETE FROM channel_overrides WHERE channel_id = $1 AND user_id = $2;
+		`, channel_id, target_id)
+	case "ROLE":
+		_, err = h.DB.Exec(rctx, `
+		DELETE FROM channel_overrides WHERE channel_id = $1 AND role_id = $2;
+		`, channel_id, target_id)
+	default:
+		_, err = h.DB.Exec(rctx, `
+		DELETE FROM channel_overrides WHERE channel_id = $1 AND user_id IS NULL AND role_id IS NULL;
+		`, channel_id)
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	// nothing is recorded if there was no override to delete
+	if before != nil {
+		if err = recordAuditLog(rctx, h, room_id, uid, "OVERRIDE_DELETE", channel_id, before, nil); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+	}
+
+	// removing a user or role override can take away an allow
+	if err = removeHiddenChannelSubs(rctx, h, channel_id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	changeData := make(map[string]interface{})
+	changeData["ID"] = channel_id
+	changeData["type"] = kind
+	changeData["target_id"] = target_id
+	if err = sendChannelChange(rctx, h, room_id, channel_id, socketMessages.ChangeEvent{
+		Type:   "DELETE",
+		Entity: "CHANNEL_OVERRIDE",
+		Data:   changeData,
+	}); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	return nil
+}
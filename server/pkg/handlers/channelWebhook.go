@@ -0,0 +1,405 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/pgtype"
+	"github.com/jackc/pgx/v5"
+	"github.com/web-stuff-98/psql-social/pkg/helpers/authHelpers"
+	"github.com/web-stuff-98/psql-social/pkg/responses"
+	socketMessages "github.com/web-stuff-98/psql-social/pkg/socketMessages"
+	"github.com/web-stuff-98/psql-social/pkg/validation"
+)
+
+/*
+	Incoming webhooks post messages into a channel. The webhook URL is
+	/api/webhook/:id/:token, anyone with the URL can post, so only the
+	hash of the token is stored and the URL is only sent to the room
+	owner when the webhook is created or the token is rotated.
+*/
+
+const maxWebhooksPerChannel = 10
+
+// returns the token and its hash
+func generateChannelWebhookToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := hex.EncodeToString(b)
+	sum := sha256.Sum256([]byte(token))
+	return token, hex.EncodeToString(sum[:]), nil
+}
+
+func channelWebhookURL(id string, token string) string {
+	return fmt.Sprintf("/api/webhook/%v/%v", id, token)
+}
+
+func (h handler) CreateChannelWebhook(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	channel_id := ctx.Params("id")
+	if channel_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	v := validator.New()
+	body := &validation.CreateChannelWebhook{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	selectRoomStmt, err := conn.Conn().Prepare(rctx, "create_channel_webhook_select_room_stmt", `
+	SELECT rooms.author_id,(SELECT COUNT(*) FROM channel_webhooks WHERE channel_id = $1) FROM room_channels
+	INNER JOIN rooms ON rooms.id = room_channels.room_id
+	WHERE room_channels.id = $1;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	var author_id string
+	var count int
+	if err = conn.QueryRow(rctx, selectRoomStmt.Name, channel_id).Scan(&author_id, &count); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Channel not found")
+	}
+	if author_id != uid {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+	if count >= maxWebhooksPerChannel {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("A channel cannot have more than %v webhooks", maxWebhooksPerChannel))
+	}
+
+	token, hash, err := generateChannelWebhookToken()
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	insertStmt, err := conn.Conn().Prepare(rctx, "create_channel_webhook_insert_stmt", `
+	INSERT INTO channel_webhooks (channel_id,name,token_hash) VALUES($1,$2,$3) RETURNING id;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	var id string
+	if err = conn.QueryRow(rctx, insertStmt.Name, channel_id, strings.TrimSpace(body.Name), hash).Scan(&id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if bytes, err := json.Marshal(responses.ChannelWebhookURL{
+		ID:  id,
+		URL: channelWebhookURL(id, token),
+	}); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Status(fiber.StatusCreated)
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
+
+func (h handler) GetChannelWebhooks(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	channel_id := ctx.Params("id")
+	if channel_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	var author_id string
+	if err = conn.QueryRow(rctx, `
+	SELECT rooms.author_id FROM room_channels INNER JOIN rooms ON rooms.id = room_channels.room_id WHERE room_channels.id = $1;
+	`, channel_id).Scan(&author_id); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Channel not found")
+	}
+	if author_id != uid {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	selectStmt, err := conn.Conn().Prepare(rctx, "get_channel_webhooks_select_stmt", `
+	SELECT id,name,created_at FROM channel_webhooks WHERE channel_id = $1 ORDER BY created_at ASC;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	rows, err := conn.Query(rctx, selectStmt.Name, channel_id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer rows.Close()
+
+	webhooks := []responses.ChannelWebhook{}
+
+	for rows.Next() {
+		var id, name string
+		var created_at pgtype.Timestamptz
+		if err = rows.Scan(&id, &name, &created_at); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		webhooks = append(webhooks, responses.ChannelWebhook{
+			ID:        id,
+			Name:      name,
+			CreatedAt: created_at.Time.Format(time.RFC3339),
+		})
+	}
+
+	if bytes, err := json.Marshal(webhooks); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
+
+// Replaces the token, the old URL stops working
+func (h handler) RotateChannelWebhook(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	webhook_id := ctx.Params("id")
+	if webhook_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	token, hash, err := generateChannelWebhookToken()
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	updateStmt, err := conn.Conn().Prepare(rctx, "rotate_channel_webhook_update_stmt", `
+	UPDATE channel_webhooks SET token_hash = $1
+	WHERE id = $2 AND channel_id IN (
+		SELECT room_channels.id FROM room_channels
+		INNER JOIN rooms ON rooms.id = room_channels.room_id
+		WHERE rooms.author_id = $3
+	) RETURNING id;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	var id string
+	if err = conn.QueryRow(rctx, updateStmt.Name, hash, webhook_id, uid).Scan(&id); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Webhook not found")
+	}
+
+	if bytes, err := json.Marshal(responses.ChannelWebhookURL{
+		ID:  id,
+		URL: channelWebhookURL(id, token),
+	}); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
+
+// Deletes the webhook. Messages it posted are kept.
+func (h handler) RevokeChannelWebhook(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	webhook_id := ctx.Params("id")
+	if webhook_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	deleteStmt, err := conn.Conn().Prepare(rctx, "revoke_channel_webhook_stmt", `
+	DELETE FROM channel_webhooks
+	WHERE id = $1 AND channel_id IN (
+		SELECT room_channels.id FROM room_channels
+		INNER JOIN rooms ON rooms.id = room_channels.room_id
+		WHERE rooms.author_id = $2
+	) RETURNING id;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	var id string
+	if err = conn.QueryRow(rctx, deleteStmt.Name, webhook_id, uid).Scan(&id); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Webhook not found")
+	}
+
+	return nil
+}
+
+// The public endpoint incoming webhooks post to. There is no session, the token in the URL is the authorization.
+func (h handler) IncomingChannelWebhook(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	webhook_id := ctx.Params("id")
+	token := ctx.Params("token")
+	if webhook_id == "" || token == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	v := validator.New()
+	body := &validation.IncomingWebhookMessage{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if body.AvatarURL != "" {
+		if u, err := url.Parse(body.AvatarURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fiber.NewError(fiber.StatusBadRequest, "Avatar URL must be http or https")
+		}
+	}
+
+	content := strings.TrimSpace(body.Content)
+	if content == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	selectStmt, err := conn.Conn().Prepare(rctx, "incoming_channel_webhook_select_stmt", `
+	SELECT channel_webhooks.token_hash,channel_webhooks.name,channel_webhooks.channel_id,room_channels.room_id,rooms.author_id
+	FROM channel_webhooks
+	INNER JOIN room_channels ON room_channels.id = channel_webhooks.channel_id
+	INNER JOIN rooms ON rooms.id = room_channels.room_id
+	WHERE channel_webhooks.id = $1;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	var token_hash, name, channel_id, room_id, owner_id string
+	if err = conn.QueryRow(rctx, selectStmt.Name, webhook_id).Scan(&token_hash, &name, &channel_id, &room_id, &owner_id); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Webhook not found")
+	}
+
+	sum := sha256.Sum256([]byte(token))
+	if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(token_hash)) != 1 {
+		return fiber.NewError(fiber.StatusNotFound, "Webhook not found")
+	}
+
+	username := name
+	if body.Username != "" {
+		username = strings.TrimSpace(body.Username)
+		_, allowed, err := filterName(rctx, h, "", "USERNAME", webhook_id, username)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		if !allowed {
+			return fiber.NewError(fiber.StatusBadRequest, "That username is not allowed")
+		}
+	}
+
+	filtered, err := filterMessage(rctx, h, "", "ROOM_MESSAGE", room_id, content, 0)
+	if err != nil {
+		if err.Error() == "Internal error" {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	id, err := postRoomMessage(rctx, h, "", channel_id, room_id, owner_id, filtered.Content, roomMessageOpts{
+		webhook: &socketMessages.RoomMessageWebhook{
+			ID:        webhook_id,
+			Username:  username,
+			AvatarURL: body.AvatarURL,
+		},
+	})
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if err = recordFilterMatches(rctx, h, "", "ROOM_MESSAGE", id, room_id, content, filtered); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	ctx.Status(fiber.StatusNoContent)
+
+	return nil
+}
@@ -0,0 +1,414 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/pgtype"
+	"github.com/jackc/pgx/v5"
+	contentFilter "github.com/web-stuff-98/psql-social/pkg/contentFilter"
+	"github.com/web-stuff-98/psql-social/pkg/helpers/authHelpers"
+	"github.com/web-stuff-98/psql-social/pkg/responses"
+	"github.com/web-stuff-98/psql-social/pkg/validation"
+)
+
+/*
+	Room, direct and group messages (including edits, polls, webhook posts and
+	command output), join request messages, channel topics, usernames (including bots and webhook
+	overrides) and the names of rooms, channels, categories, roles and groups
+	are run through the content filter. Messages and topics can be masked,
+	names are rejected if they are masked or blocked. Every match is recorded in
+	content_filter_matches, room owners can review the matches for their room
+	and admins can review all of them.
+*/
+
+const filterMatchesPageSize = 30
+
+// Runs content through the filter, using the rooms word list instead of the default one if the room has one
+func filterContent(ctx context.Context, h handler, content string, roomID string) (contentFilter.Result, error) {
+	var words contentFilter.Stage
+	if roomID != "" {
+		var list []string
+		var action string
+		if err := h.DB.QueryRow(ctx, `
+		SELECT words,action FROM room_word_filters WHERE room_id = $1;
+		`, roomID).Scan(&list, &action); err != nil {
+			if err != pgx.ErrNoRows {
+				return contentFilter.Result{}, err
+			}
+		} else {
+			words = contentFilter.NewWordList(list, contentFilter.Action(action))
+		}
+	}
+	return h.ContentFilter.Run(content, words), nil
+}
+
+// Records the matches from a filter result. uid, targetID and roomID can be empty.
+func recordFilterMatches(ctx context.Context, h handler, uid string, target string, targetID string, roomID string, content string, res contentFilter.Result) error {
+	if len(res.Matches) == 0 {
+		return nil
+	}
+
+	var user_id, target_id, room_id interface{}
+	if uid != "" {
+		user_id = uid
+	}
+	if targetID != "" {
+		target_id = targetID
+	}
+	if roomID != "" {
+		room_id = roomID
+	}
+
+	for _, m := range res.Matches {
+		if _, err := h.DB.Exec(ctx, `
+		INSERT INTO content_filter_matches (user_id,target,target_id,room_id,stage,rule,action,matched,content)
+		VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9);
+		`, user_id, target, target_id, room_id, m.Stage, m.Rule, string(m.Action), m.Text, content); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Filters message content. Blocked content is recorded and returned as an error, the caller records the result
+// for allowed content once it has the target id. Masks can make content longer, so the masked content is cut down
+// to maxLength characters (the size of the column it is stored in), 0 for no limit.
+func filterMessage(ctx context.Context, h handler, uid string, target string, roomID string, content string, maxLength int) (contentFilter.Result, error) {
+	res, err := filterContent(ctx, h, content, roomID)
+	if err != nil {
+		return res, fmt.Errorf("Internal error")
+	}
+	if res.Blocked {
+		if err = recordFilterMatches(ctx, h, uid, target, "", roomID, content, res); err != nil {
+			return res, fmt.Errorf("Internal error")
+		}
+		return res, fmt.Errorf("Your message was blocked by the content filter")
+	}
+	res.Content = fitContent(res.Content, maxLength)
+	return res, nil
+}
+
+// Channel topics are shown to everyone in the channel, so they are filtered like messages. Like filterMessage,
+// blocked topics are recorded here and the caller records the result for allowed topics.
+func filterChannelTopic(ctx context.Context, h handler, uid string, roomID string, topic string) (contentFilter.Result, error) {
+	res, err := filterContent(ctx, h, topic, roomID)
+	if err != nil {
+		return res, fmt.Errorf("Internal error")
+	}
+	if res.Blocked {
+		if err = recordFilterMatches(ctx, h, uid, "CHANNEL_TOPIC", "", roomID, topic, res); err != nil {
+			return res, fmt.Errorf("Internal error")
+		}
+		return res, fmt.Errorf("That topic is not allowed")
+	}
+	res.Content = fitContent(res.Content, 100)
+	return res, nil
+}
+
+// Cuts content down to maxLength characters, 0 for no limit
+func fitContent(content string, maxLength int) string {
+	if maxLength > 0 {
+		if runes := []rune(content); len(runes) > maxLength {
+			return string(runes[:maxLength])
+		}
+	}
+	return content
+}
+
+// Names cannot be masked, so they are rejected if anything in them would be masked or blocked. Rejected
+// names are recorded here, the caller records the result for allowed names once it has the target id.
+func filterName(ctx context.Context, h handler, uid string, target string, targetID string, name string) (contentFilter.Result, bool, error) {
+	res, err := filterContent(ctx, h, name, "")
+	if err != nil {
+		return res, false, err
+	}
+	if res.Blocked || res.Masked {
+		if err = recordFilterMatches(ctx, h, uid, target, targetID, "", name, res); err != nil {
+			return res, false, err
+		}
+		return res, false, nil
+	}
+	return res, true, nil
+}
+
+func (h handler) GetRoomWordFilter(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	room_id := ctx.Params("id")
+	if room_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	var author_id string
+	if err = conn.QueryRow(rctx, `
+	SELECT author_id FROM rooms WHERE id = $1;
+	`, room_id).Scan(&author_id); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Room not found")
+	}
+	if author_id != uid {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	// rooms without their own word list use the default one
+	filter := responses.RoomWordFilter{
+		Words:   []string{},
+		Action:  string(contentFilter.Mask),
+		Enabled: false,
+	}
+	var words []string
+	var action string
+	if err = conn.QueryRow(rctx, `
+	SELECT words,action FROM room_word_filters WHERE room_id = $1;
+	`, room_id).Scan(&words, &action); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+	} else {
+		filter.Words = words
+		filter.Action = action
+		filter.Enabled = true
+	}
+
+	if bytes, err := json.Marshal(filter); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
+
+func (h handler) UpdateRoomWordFilter(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	v := validator.New()
+	body := &validation.UpdateRoomWordFilter{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	room_id := ctx.Params("id")
+	if room_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	var author_id string
+	if err = conn.QueryRow(rctx, `
+	SELECT author_id FROM rooms WHERE id = $1;
+	`, room_id).Scan(&author_id); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Room not found")
+	}
+	if author_id != uid {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	before := make(map[string]interface{})
+	before["word_filter_enabled"] = true
+	var prev_words []string
+	var prev_action string
+	if err = conn.QueryRow(rctx, `
+	SELECT words,action FROM room_word_filters WHERE room_id = $1;
+	`, room_id).Scan(&prev_words, &prev_action); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		before["word_filter_enabled"] = false
+	} else {
+		before["word_filter_words"] = prev_words
+		before["word_filter_action"] = prev_action
+	}
+
+	// disabling the rooms word list goes back to using the default one
+	if !body.Enabled {
+		if _, err = conn.Exec(rctx, `
+		DELETE FROM room_word_filters WHERE room_id = $1;
+		`, room_id); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+
+		after := make(map[string]interface{})
+		after["word_filter_enabled"] = false
+		if err = recordAuditLog(rctx, h, room_id, uid, "ROOM_UPDATE", room_id, before, after); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return nil
+	}
+
+	upsertStmt, err := conn.Conn().Prepare(rctx, "update_room_word_filter_upsert_stmt", `
+	INSERT INTO room_word_filters (room_id,words,action) VALUES($1,$2,$3)
+	ON CONFLICT (room_id) DO UPDATE SET words = EXCLUDED.words, action = EXCLUDED.action;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	action := body.Action
+	if action == "" {
+		action = string(contentFilter.Mask)
+	}
+
+	if _, err = conn.Exec(rctx, upsertStmt.Name, room_id, body.Words, action); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	after := make(map[string]interface{})
+	after["word_filter_enabled"] = true
+	after["word_filter_words"] = body.Words
+	after["word_filter_action"] = action
+	if err = recordAuditLog(rctx, h, room_id, uid, "ROOM_UPDATE", room_id, before, after); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	return nil
+}
+
+func writeFilterMatchesPage(ctx *fiber.Ctx, rctx context.Context, h handler, roomID string) error {
+	page := ctx.QueryInt("page", 1)
+	if page < 1 {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	offset := (page - 1) * filterMatchesPageSize
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	// an empty room id lists the matches from everywhere
+	selectStmt, err := conn.Conn().Prepare(rctx, "get_filter_matches_select_stmt", `
+	SELECT id,user_id::TEXT,target,target_id::TEXT,room_id::TEXT,stage,rule,action,matched,content,created_at
+	FROM content_filter_matches
+	WHERE $1 = '' OR room_id::TEXT = $1
+	ORDER BY created_at DESC
+	LIMIT $2 OFFSET $3;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	rows, err := conn.Query(rctx, selectStmt.Name, roomID, filterMatchesPageSize, offset)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer rows.Close()
+
+	matches := []responses.FilterMatch{}
+	for rows.Next() {
+		var m responses.FilterMatch
+		var user_id, target_id, room_id *string
+		var created_at pgtype.Timestamptz
+		if err = rows.Scan(&m.ID, &user_id, &m.Target, &target_id, &room_id, &m.Stage, &m.Rule, &m.Action, &m.Matched, &m.Content, &created_at); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		if user_id != nil {
+			m.UserID = *user_id
+		}
+		if target_id != nil {
+			m.TargetID = *target_id
+		}
+		if room_id != nil {
+			m.RoomID = *room_id
+		}
+		m.CreatedAt = created_at.Time.Format(time.RFC3339)
+		matches = append(matches, m)
+	}
+	rows.Close()
+
+	var count int
+	if err = conn.QueryRow(rctx, `
+	SELECT COUNT(*) FROM content_filter_matches WHERE $1 = '' OR room_id::TEXT = $1;
+	`, roomID).Scan(&count); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if bytes, err := json.Marshal(responses.FilterMatchesPage{
+		Matches: matches,
+		Count:   count,
+	}); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
+
+func (h handler) GetRoomFilterMatches(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	room_id := ctx.Params("id")
+	if room_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	var author_id string
+	if err = h.DB.QueryRow(rctx, `
+	SELECT author_id FROM rooms WHERE id = $1;
+	`, room_id).Scan(&author_id); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Room not found")
+	}
+	if author_id != uid {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	return writeFilterMatchesPage(ctx, rctx, h, room_id)
+}
+
+// Admin only, see middleware.AdminOnly
+func (h handler) GetFilterMatches(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	return writeFilterMatchesPage(ctx, rctx, h, "")
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/pgtype"
+	"github.com/jackc/pgx/v5"
+	"github.com/web-stuff-98/psql-social/pkg/helpers/authHelpers"
+	"github.com/web-stuff-98/psql-social/pkg/responses"
+)
+
+/*
+	Room owners can export a channel, and users can export a conversation
+	they are part of. The export is created as PENDING and built by the
+	export server, which sends EXPORT_COMPLETE to the user when it's done.
+	Only the user who requested an export can download it.
+*/
+
+// A user can only have one export pending at a time
+func queueExport(rctx context.Context, h handler, uid string, kind string, targetID string) (string, error) {
+	var pending bool
+	if err := h.DB.QueryRow(rctx, `
+	SELECT EXISTS(SELECT 1 FROM exports WHERE user_id = $1 AND status = 'PENDING');
+	`, uid).Scan(&pending); err != nil {
+		return "", fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if pending {
+		return "", fiber.NewError(fiber.StatusBadRequest, "You already have an export in progress")
+	}
+
+	var id string
+	if err := h.DB.QueryRow(rctx, `
+	INSERT INTO exports (user_id,kind,target_id) VALUES($1,$2,$3) RETURNING id;
+	`, uid, kind, targetID).Scan(&id); err != nil {
+		return "", fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	h.ExportServer.QueueExport <- id
+
+	return id, nil
+}
+
+func (h handler) CreateChannelExport(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	channel_id := ctx.Params("id")
+	if channel_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	var author_id string
+	if err = h.DB.QueryRow(rctx, `
+	SELECT rooms.author_id FROM room_channels
+	INNER JOIN rooms ON rooms.id = room_channels.room_id
+	WHERE room_channels.id = $1;
+	`, channel_id).Scan(&author_id); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Channel not found")
+	}
+	if author_id != uid {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	id, err := queueExport(rctx, h, uid, "CHANNEL", channel_id)
+	if err != nil {
+		return err
+	}
+
+	ctx.Response().Header.Add("Content-Type", "text/plain")
+	ctx.WriteString(id)
+	ctx.Status(fiber.StatusCreated)
+
+	return nil
+}
+
+func (h handler) CreateConversationExport(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	user_id := ctx.Params("id")
+	if user_id == "" || user_id == uid {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	var exists bool
+	if err = h.DB.QueryRow(rctx, `
+	SELECT EXISTS(SELECT 1 FROM users WHERE id = $1);
+	`, user_id).Scan(&exists); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if !exists {
+		return fiber.NewError(fiber.StatusNotFound, "User not found")
+	}
+
+	id, err := queueExport(rctx, h, uid, "CONVERSATION", user_id)
+	if err != nil {
+		return err
+	}
+
+	ctx.Response().Header.Add("Content-Type", "text/plain")
+	ctx.WriteString(id)
+	ctx.Status(fiber.StatusCreated)
+
+	return nil
+}
+
+func (h handler) GetExports(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	selectStmt, err := conn.Conn().Prepare(rctx, "get_exports_select_stmt", `
+	SELECT id,kind,target_id,status,size,created_at,expires_at FROM exports WHERE user_id = $1 ORDER BY created_at DESC;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	rows, err := conn.Query(rctx, selectStmt.Name, uid)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer rows.Close()
+
+	exports := []responses.Export{}
+	for rows.Next() {
+		var e responses.Export
+		var created_at, expires_at pgtype.Timestamptz
+		if err = rows.Scan(&e.ID, &e.Kind, &e.TargetID, &e.Status, &e.Size, &created_at, &expires_at); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		e.CreatedAt = created_at.Time.Format(time.RFC3339)
+		if expires_at.Status == pgtype.Present {
+			e.ExpiresAt = expires_at.Time.Format(time.RFC3339)
+		}
+		if e.Status == "COMPLETE" {
+			e.URL = fmt.Sprintf("/api/export/%v", e.ID)
+		}
+		exports = append(exports, e)
+	}
+	rows.Close()
+
+	if bytes, err := json.Marshal(exports); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
+
+func (h handler) DownloadExport(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	id := ctx.Params("id")
+	if id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	var user_id, status string
+	var size int
+	var created_at pgtype.Timestamptz
+	if err = conn.QueryRow(rctx, `
+	SELECT user_id,status,size,created_at FROM exports WHERE id = $1;
+	`, id).Scan(&user_id, &status, &size, &created_at); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Export not found")
+	}
+	if user_id != uid {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+	if status != "COMPLETE" {
+		return fiber.NewError(fiber.StatusBadRequest, "Export not ready")
+	}
+
+	ctx.Response().Header.SetContentType("application/zip")
+	ctx.Response().Header.SetContentLength(size)
+	ctx.Response().Header.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="export-%v.zip"`, created_at.Time.Format("2006-01-02-150405")))
+
+	var chunkBytes pgtype.Bytea
+	for index := 0; ; index++ {
+		if err = conn.QueryRow(rctx, `
+		SELECT bytes FROM export_chunks WHERE export_id = $1 AND chunk_index = $2;
+		`, id, index).Scan(&chunkBytes); err != nil {
+			if err == pgx.ErrNoRows {
+				break
+			}
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		if _, err = ctx.Write(chunkBytes.Bytes); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,1145 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/jackc/pgx/pgtype"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nfnt/resize"
+	"github.com/web-stuff-98/psql-social/pkg/helpers/authHelpers"
+	"github.com/web-stuff-98/psql-social/pkg/responses"
+	socketMessages "github.com/web-stuff-98/psql-social/pkg/socketMessages"
+	"github.com/web-stuff-98/psql-social/pkg/socketServer"
+	socketValidation "github.com/web-stuff-98/psql-social/pkg/socketValidation"
+	"github.com/web-stuff-98/psql-social/pkg/validation"
+)
+
+/*
+	Group conversations are direct messages between more than two users.
+	Messages are sent out on the group:<id> subscription, which users join
+	when they open the conversation. Members who don't have it open get a
+	notification instead. Only the owner can remove members, rename the
+	group or change its picture, any member can add users.
+*/
+
+const maxGroupMembers = 10
+
+// Returns the group with the uids of its members
+func getGroup(ctx context.Context, conn *pgxpool.Conn, groupID string) (*responses.Group, error) {
+	selectGroupStmt, err := conn.Conn().Prepare(ctx, "get_group_select_stmt", `
+	SELECT name,owner_id,created_at,
+	ARRAY(SELECT user_id::TEXT FROM group_conversation_members WHERE group_id = $1 ORDER BY joined_at ASC)
+	FROM group_conversations WHERE id = $1;
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	var name string
+	var owner_id *string
+	var created_at pgtype.Timestamptz
+	var members []string
+	if err = conn.QueryRow(ctx, selectGroupStmt.Name, groupID).Scan(&name, &owner_id, &created_at, &members); err != nil {
+		return nil, err
+	}
+
+	group := &responses.Group{
+		ID:        groupID,
+		Name:      name,
+		Members:   members,
+		CreatedAt: created_at.Time.Format(time.RFC3339),
+	}
+	if owner_id != nil {
+		group.OwnerID = *owner_id
+	}
+
+	return group, nil
+}
+
+func groupChangeData(group *responses.Group) map[string]interface{} {
+	data := make(map[string]interface{})
+	data["ID"] = group.ID
+	data["name"] = group.Name
+	data["owner_id"] = group.OwnerID
+	data["members"] = group.Members
+	data["created_at"] = group.CreatedAt
+	return data
+}
+
+// Sends the group to all of its members, and to the users passed in, which is for users who were just removed
+func sendGroupChange(ctx context.Context, conn *pgxpool.Conn, h handler, groupID string, removed ...string) error {
+	group, err := getGroup(ctx, conn, groupID)
+	if err != nil {
+		return err
+	}
+
+	h.SocketServer.SendDataToUsers <- socketServer.UsersMessageData{
+		Uids: group.Members,
+		Data: socketMessages.ChangeEvent{
+			Type:   "UPDATE",
+			Entity: "GROUP",
+			Data:   groupChangeData(group),
+		},
+		MessageType: "CHANGE",
+	}
+
+	if len(removed) > 0 {
+		data := make(map[string]interface{})
+		data["ID"] = groupID
+		h.SocketServer.SendDataToUsers <- socketServer.UsersMessageData{
+			Uids: removed,
+			Data: socketMessages.ChangeEvent{
+				Type:   "DELETE",
+				Entity: "GROUP",
+				Data:   data,
+			},
+			MessageType: "CHANGE",
+		}
+	}
+
+	return nil
+}
+
+// Returns an error if the group doesn't exist or the user isn't a member
+func checkGroupMember(ctx context.Context, conn *pgxpool.Conn, uid string, groupID string) error {
+	selectStmt, err := conn.Conn().Prepare(ctx, "check_group_member_select_stmt", `
+	SELECT EXISTS(SELECT 1 FROM group_conversations WHERE id = $1),
+	EXISTS(SELECT 1 FROM group_conversation_members WHERE group_id = $1 AND user_id = $2);
+	`)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	var exists, member bool
+	if err = conn.QueryRow(ctx, selectStmt.Name, groupID, uid).Scan(&exists, &member); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	if !exists {
+		return fmt.Errorf("Group not found")
+	}
+	if !member {
+		return fmt.Errorf("You are not a member of this group")
+	}
+
+	return nil
+}
+
+// Returns an error if the user cannot be added to a group by uid because of blocks
+func checkCanAddToGroup(ctx context.Context, conn *pgxpool.Conn, uid string, target string) error {
+	selectStmt, err := conn.Conn().Prepare(ctx, "check_can_add_to_group_select_stmt", `
+	SELECT EXISTS(SELECT 1 FROM users WHERE id = $2),
+	EXISTS(SELECT 1 FROM blocks WHERE blocker = $1 AND blocked = $2),
+	EXISTS(SELECT 1 FROM blocks WHERE blocker = $2 AND blocked = $1);
+	`)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	var exists, blocker, blocked bool
+	if err = conn.QueryRow(ctx, selectStmt.Name, uid, target).Scan(&exists, &blocker, &blocked); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	if !exists {
+		return fmt.Errorf("User not found")
+	}
+	if blocker {
+		return fmt.Errorf("You have blocked this user, you must unblock them to add them")
+	}
+	if blocked {
+		return fmt.Errorf("This user has blocked your account")
+	}
+
+	return nil
+}
+
+func (h handler) CreateGroup(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	v := validator.New()
+	body := &validation.CreateGroup{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	uids := []string{}
+	seen := map[string]struct{}{uid: {}}
+	for _, id := range body.Uids {
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			uids = append(uids, id)
+		}
+	}
+	if len(uids) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "A group needs at least one other member")
+	}
+	if len(uids)+1 > maxGroupMembers {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("A group cannot have more than %v members", maxGroupMembers))
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	for _, id := range uids {
+		if err = checkCanAddToGroup(rctx, conn, uid, id); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+	}
+
+	filtered, allowed, err := filterName(rctx, h, uid, "GROUP_NAME", "", strings.TrimSpace(body.Name))
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if !allowed {
+		return fiber.NewError(fiber.StatusBadRequest, "That group name is not allowed")
+	}
+
+	tx, err := conn.Begin(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer tx.Rollback(rctx)
+
+	var id string
+	if err = tx.QueryRow(rctx, `
+	INSERT INTO group_conversations (name, owner_id) VALUES ($1, $2) RETURNING id;
+	`, strings.TrimSpace(body.Name), uid).Scan(&id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	for _, member := range append([]string{uid}, uids...) {
+		if _, err = tx.Exec(rctx, `
+		INSERT INTO group_conversation_members (group_id, user_id) VALUES ($1, $2);
+		`, id, member); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+	}
+
+	if err = tx.Commit(rctx); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if err = recordFilterMatches(rctx, h, uid, "GROUP_NAME", id, "", strings.TrimSpace(body.Name), filtered); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	group, err := getGroup(rctx, conn, id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	h.SocketServer.SendDataToUsers <- socketServer.UsersMessageData{
+		Uids: group.Members,
+		Data: socketMessages.ChangeEvent{
+			Type:   "INSERT",
+			Entity: "GROUP",
+			Data:   groupChangeData(group),
+		},
+		MessageType: "CHANGE",
+	}
+
+	ctx.Response().Header.Add("Content-Type", "text/plain")
+	ctx.WriteString(id)
+	ctx.Status(fiber.StatusCreated)
+
+	return nil
+}
+
+func (h handler) GetGroups(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	selectStmt, err := conn.Conn().Prepare(rctx, "get_groups_select_stmt", `
+	SELECT group_id FROM group_conversation_members WHERE user_id = $1 ORDER BY joined_at DESC;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	rows, err := conn.Query(rctx, selectStmt.Name, uid)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	groups := []responses.Group{}
+	for _, id := range ids {
+		group, err := getGroup(rctx, conn, id)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		groups = append(groups, *group)
+	}
+
+	if bytes, err := json.Marshal(groups); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
+
+func (h handler) GetGroup(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	id := ctx.Params("id")
+	if id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	if err = checkGroupMember(rctx, conn, uid, id); err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "Group not found")
+	}
+
+	group, err := getGroup(rctx, conn, id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if bytes, err := json.Marshal(group); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
+
+func (h handler) GetGroupMessages(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	id := ctx.Params("id")
+	if id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	if err = checkGroupMember(rctx, conn, uid, id); err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "Group not found")
+	}
+
+	selectStmt, err := conn.Conn().Prepare(rctx, "get_group_messages_select_stmt", `
+	SELECT id,content,author_id,created_at,has_attachment FROM group_messages WHERE group_id = $1 ORDER BY created_at ASC LIMIT 50;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	rows, err := conn.Query(rctx, selectStmt.Name, id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer rows.Close()
+
+	messages := []responses.GroupMessage{}
+	for rows.Next() {
+		var msg_id, content, author_id string
+		var created_at pgtype.Timestamptz
+		var has_attachment bool
+		if err = rows.Scan(&msg_id, &content, &author_id, &created_at, &has_attachment); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		messages = append(messages, responses.GroupMessage{
+			ID:            msg_id,
+			Content:       content,
+			AuthorID:      author_id,
+			GroupID:       id,
+			CreatedAt:     created_at.Time.Format(time.RFC3339),
+			HasAttachment: has_attachment,
+		})
+	}
+
+	if bytes, err := json.Marshal(messages); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
+
+func (h handler) UpdateGroup(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	id := ctx.Params("id")
+	if id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	v := validator.New()
+	body := &validation.UpdateGroup{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	filtered, allowed, err := filterName(rctx, h, uid, "GROUP_NAME", id, strings.TrimSpace(body.Name))
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if !allowed {
+		return fiber.NewError(fiber.StatusBadRequest, "That group name is not allowed")
+	}
+
+	updateStmt, err := conn.Conn().Prepare(rctx, "update_group_stmt", `
+	UPDATE group_conversations SET name = $1 WHERE id = $2 AND owner_id = $3;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if tag, err := conn.Exec(rctx, updateStmt.Name, strings.TrimSpace(body.Name), id, uid); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else if tag.RowsAffected() == 0 {
+		return fiber.NewError(fiber.StatusNotFound, "Group not found")
+	}
+
+	if err = recordFilterMatches(rctx, h, uid, "GROUP_NAME", id, "", strings.TrimSpace(body.Name), filtered); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if err = sendGroupChange(rctx, conn, h, id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	return nil
+}
+
+func (h handler) UploadGroupImage(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	id := ctx.Params("id")
+	if id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	var owner_id *string
+	if err = conn.QueryRow(rctx, `
+	SELECT owner_id FROM group_conversations WHERE id = $1;
+	`, id).Scan(&owner_id); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Group not found")
+	}
+	if owner_id == nil || *owner_id != uid {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	fh, err := ctx.FormFile("file")
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Error loading file")
+	}
+	if fh.Size > 30*1024*1024 {
+		return fiber.NewError(fiber.StatusBadRequest, "Maximum 30mb")
+	}
+
+	mime := fh.Header.Get("Content-Type")
+	if mime != "image/jpeg" && mime != "image/png" {
+		return fiber.NewError(fiber.StatusBadRequest, "Only jpeg and png allowed")
+	}
+
+	file, err := fh.Open()
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Error loading file")
+	}
+	defer file.Close()
+
+	var img image.Image
+	var decodeErr error
+	switch mime {
+	case "image/jpeg":
+		img, decodeErr = jpeg.Decode(file)
+	case "image/png":
+		img, decodeErr = png.Decode(file)
+	default:
+		return fiber.NewError(fiber.StatusBadRequest, "Only jpeg and png allowed")
+	}
+	if decodeErr != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	buf := &bytes.Buffer{}
+	if img.Bounds().Dx() > img.Bounds().Dy() {
+		img = resize.Resize(300, 0, img, resize.Lanczos3)
+	} else {
+		img = resize.Resize(0, 300, img, resize.Lanczos3)
+	}
+	if err := jpeg.Encode(buf, img, nil); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	imgBytes := buf.Bytes()
+
+	exists := false
+	if err = conn.QueryRow(rctx, `
+	SELECT EXISTS(SELECT 1 FROM group_conversation_pictures WHERE group_id = $1);
+	`, id).Scan(&exists); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if exists {
+		if _, err := conn.Exec(rctx, `
+		UPDATE group_conversation_pictures SET picture_data = $1 WHERE group_id = $2;
+		`, imgBytes, id); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+	} else {
+		if _, err := conn.Exec(rctx, `
+		INSERT INTO group_conversation_pictures (group_id,picture_data,mime) VALUES ($1,$2,'image/jpeg');
+		`, id, imgBytes); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+	}
+
+	msgData := make(map[string]interface{})
+	msgData["ID"] = id
+	h.SocketServer.SendDataToSub <- socketServer.SubscriptionMessageData{
+		SubName: fmt.Sprintf("group:%v", id),
+		Data: socketMessages.ChangeEvent{
+			Type:   "UPDATE_IMAGE",
+			Entity: "GROUP",
+			Data:   msgData,
+		},
+		MessageType: "CHANGE",
+	}
+
+	return nil
+}
+
+func (h handler) GetGroupImage(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	id := ctx.Params("id")
+	if id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	if err = checkGroupMember(rctx, conn, uid, id); err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "Group not found")
+	}
+
+	selectStmt, err := conn.Conn().Prepare(rctx, "get_group_image_select_stmt", `
+	SELECT picture_data,mime FROM group_conversation_pictures WHERE group_id = $1;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	var pictureData pgtype.Bytea
+	var mime string
+	if err = conn.QueryRow(rctx, selectStmt.Name, id).Scan(&pictureData, &mime); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Picture not found")
+	}
+
+	ctx.Response().Header.Add("Content-Type", strings.TrimSpace(mime))
+	ctx.Response().Header.Add("Content-Length", strconv.Itoa(len(pictureData.Bytes)))
+	ctx.Write(pictureData.Bytes)
+
+	return nil
+}
+
+func groupOpened(inData map[string]interface{}, h handler, uid string, c *websocket.Conn) error {
+	data := &socketValidation.GroupOpenedClosedLeave{}
+	if err := UnmarshalMap(inData, data); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	conn, err := h.DB.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	defer conn.Release()
+
+	if err = checkGroupMember(ctx, conn, uid, data.GroupID); err != nil {
+		return err
+	}
+
+	if _, err = conn.Exec(ctx, `
+	DELETE FROM group_message_notifications WHERE user_id = $1 AND group_id = $2;
+	`, uid, data.GroupID); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	h.SocketServer.JoinSubscriptionByWs <- socketServer.RegisterUnregisterSubsConnWs{
+		SubName: fmt.Sprintf("group:%v", data.GroupID),
+		Conn:    c,
+	}
+
+	return nil
+}
+
+func groupClosed(inData map[string]interface{}, h handler, uid string, c *websocket.Conn) error {
+	data := &socketValidation.GroupOpenedClosedLeave{}
+	if err := UnmarshalMap(inData, data); err != nil {
+		return err
+	}
+
+	h.SocketServer.LeaveSubscriptionByWs <- socketServer.RegisterUnregisterSubsConnWs{
+		SubName: fmt.Sprintf("group:%v", data.GroupID),
+		Conn:    c,
+	}
+
+	return nil
+}
+
+// Returns the uids of the groups members who don't have the conversation open
+func groupMembersNotInSub(ctx context.Context, conn *pgxpool.Conn, h handler, groupID string) ([]string, error) {
+	recvChan := make(chan map[string]struct{})
+	h.SocketServer.GetSubscriptionUids <- socketServer.GetSubscriptionUids{
+		SubName:  fmt.Sprintf("group:%v", groupID),
+		RecvChan: recvChan,
+	}
+	uidsMap := <-recvChan
+
+	rows, err := conn.Query(ctx, `
+	SELECT user_id FROM group_conversation_members WHERE group_id = $1;
+	`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uids := []string{}
+	for rows.Next() {
+		var uid string
+		if err = rows.Scan(&uid); err != nil {
+			return nil, err
+		}
+		if _, ok := uidsMap[uid]; !ok {
+			uids = append(uids, uid)
+		}
+	}
+
+	return uids, rows.Err()
+}
+
+func groupMessage(inData map[string]interface{}, h handler, uid string, c *websocket.Conn) error {
+	data := &socketValidation.GroupMessage{}
+	if err := UnmarshalMap(inData, data); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	conn, err := h.DB.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	defer conn.Release()
+
+	if err = checkGroupMember(ctx, conn, uid, data.GroupID); err != nil {
+		return err
+	}
+
+	insertStmt, err := conn.Conn().Prepare(ctx, "insert_group_message_stmt", `
+	INSERT INTO group_messages (content, author_id, group_id, has_attachment) VALUES ($1, $2, $3, $4) RETURNING id;
+	`)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	original := strings.TrimSpace(data.Content)
+	filtered, err := filterMessage(ctx, h, uid, "GROUP_MESSAGE", "", original, 200)
+	if err != nil {
+		return err
+	}
+
+	var id string
+	content := filtered.Content
+	if err = conn.QueryRow(ctx, insertStmt.Name, content, uid, data.GroupID, data.HasAttachment).Scan(&id); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	if err = recordFilterMatches(ctx, h, uid, "GROUP_MESSAGE", id, "", original, filtered); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	receiveNotifications, err := groupMembersNotInSub(ctx, conn, h, data.GroupID)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	for _, v := range receiveNotifications {
+		if _, err = conn.Exec(ctx, `
+		INSERT INTO group_message_notifications (user_id,group_id,message_id) VALUES($1,$2,$3);
+		`, v, data.GroupID, id); err != nil {
+			return fmt.Errorf("Internal error")
+		}
+	}
+
+	h.SocketServer.SendDataToUsers <- socketServer.UsersMessageData{
+		Uids: receiveNotifications,
+		Data: socketMessages.GroupMessageNotify{
+			GroupID: data.GroupID,
+		},
+		MessageType: "GROUP_MESSAGE_NOTIFY",
+	}
+
+	h.SocketServer.SendDataToSub <- socketServer.SubscriptionMessageData{
+		SubName: fmt.Sprintf("group:%v", data.GroupID),
+		Data: socketMessages.GroupMessage{
+			ID:            id,
+			Content:       content,
+			CreatedAt:     time.Now().Format(time.RFC3339),
+			AuthorID:      uid,
+			GroupID:       data.GroupID,
+			HasAttachment: data.HasAttachment,
+		},
+		MessageType: "GROUP_MESSAGE",
+	}
+
+	if data.HasAttachment {
+		h.SocketServer.SendDataToUser <- socketServer.UserMessageData{
+			Uid: uid,
+			Data: socketMessages.RequestAttachment{
+				ID: id,
+			},
+			MessageType: "REQUEST_ATTACHMENT",
+		}
+	}
+
+	return nil
+}
+
+func groupMessageUpdate(inData map[string]interface{}, h handler, uid string, c *websocket.Conn) error {
+	data := &socketValidation.GroupMessageUpdate{}
+	if err := UnmarshalMap(inData, data); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	conn, err := h.DB.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	defer conn.Release()
+
+	updateStmt, err := conn.Conn().Prepare(ctx, "group_message_update_stmt", `
+	UPDATE group_messages SET content = $1, edited_at = NOW() WHERE id = $2 AND author_id = $3 RETURNING group_id;
+	`)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	original := strings.TrimSpace(data.Content)
+	filtered, err := filterMessage(ctx, h, uid, "GROUP_MESSAGE", "", original, 200)
+	if err != nil {
+		return err
+	}
+	content := filtered.Content
+
+	var group_id string
+	if err = conn.QueryRow(ctx, updateStmt.Name, content, data.MsgID, uid).Scan(&group_id); err != nil {
+		if err != pgx.ErrNoRows {
+			return fmt.Errorf("Internal error")
+		}
+		return fmt.Errorf("Message not found")
+	}
+
+	if err = recordFilterMatches(ctx, h, uid, "GROUP_MESSAGE", data.MsgID, "", original, filtered); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	h.SocketServer.SendDataToSub <- socketServer.SubscriptionMessageData{
+		SubName: fmt.Sprintf("group:%v", group_id),
+		Data: socketMessages.GroupMessageUpdate{
+			ID:      data.MsgID,
+			Content: content,
+			GroupID: group_id,
+		},
+		MessageType: "GROUP_MESSAGE_UPDATE",
+	}
+
+	return nil
+}
+
+func groupMessageDelete(inData map[string]interface{}, h handler, uid string, c *websocket.Conn) error {
+	data := &socketValidation.GroupMessageDelete{}
+	if err := UnmarshalMap(inData, data); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	conn, err := h.DB.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	defer conn.Release()
+
+	deleteStmt, err := conn.Conn().Prepare(ctx, "group_message_delete_stmt", `
+	DELETE FROM group_messages WHERE id = $1 AND author_id = $2 RETURNING group_id;
+	`)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	var group_id string
+	if err = conn.QueryRow(ctx, deleteStmt.Name, data.MsgID, uid).Scan(&group_id); err != nil {
+		if err != pgx.ErrNoRows {
+			return fmt.Errorf("Internal error")
+		}
+		return fmt.Errorf("Message not found")
+	}
+
+	if err = markBookmarksUnavailable(ctx, h, data.MsgID); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	h.SocketServer.SendDataToSub <- socketServer.SubscriptionMessageData{
+		SubName: fmt.Sprintf("group:%v", group_id),
+		Data: socketMessages.GroupMessageDelete{
+			ID:      data.MsgID,
+			GroupID: group_id,
+		},
+		MessageType: "GROUP_MESSAGE_DELETE",
+	}
+
+	if uids, err := groupMembersNotInSub(ctx, conn, h, group_id); err == nil {
+		h.SocketServer.SendDataToUsers <- socketServer.UsersMessageData{
+			Uids: uids,
+			Data: socketMessages.GroupMessageNotify{
+				GroupID: group_id,
+			},
+			MessageType: "GROUP_MESSAGE_NOTIFY_DELETE",
+		}
+	}
+
+	return nil
+}
+
+func groupAddMember(inData map[string]interface{}, h handler, uid string, c *websocket.Conn) error {
+	data := &socketValidation.GroupAddRemoveMember{}
+	if err := UnmarshalMap(inData, data); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	conn, err := h.DB.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	defer conn.Release()
+
+	if err = checkGroupMember(ctx, conn, uid, data.GroupID); err != nil {
+		return err
+	}
+
+	if err = checkCanAddToGroup(ctx, conn, uid, data.Uid); err != nil {
+		return err
+	}
+
+	var count int
+	var alreadyMember bool
+	if err = conn.QueryRow(ctx, `
+	SELECT COUNT(*),COALESCE(BOOL_OR(user_id = $2), FALSE) FROM group_conversation_members WHERE group_id = $1;
+	`, data.GroupID, data.Uid).Scan(&count, &alreadyMember); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	if alreadyMember {
+		return fmt.Errorf("This user is already a member of the group")
+	}
+	if count >= maxGroupMembers {
+		return fmt.Errorf("A group cannot have more than %v members", maxGroupMembers)
+	}
+
+	if _, err = conn.Exec(ctx, `
+	INSERT INTO group_conversation_members (group_id, user_id) VALUES ($1, $2);
+	`, data.GroupID, data.Uid); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	if err = sendGroupChange(ctx, conn, h, data.GroupID); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	return nil
+}
+
+func groupRemoveMember(inData map[string]interface{}, h handler, uid string, c *websocket.Conn) error {
+	data := &socketValidation.GroupAddRemoveMember{}
+	if err := UnmarshalMap(inData, data); err != nil {
+		return err
+	}
+
+	if data.Uid == uid {
+		return fmt.Errorf("You cannot remove yourself, leave the group instead")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	conn, err := h.DB.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	defer conn.Release()
+
+	var owner_id *string
+	if err = conn.QueryRow(ctx, `
+	SELECT owner_id FROM group_conversations WHERE id = $1;
+	`, data.GroupID).Scan(&owner_id); err != nil {
+		if err != pgx.ErrNoRows {
+			return fmt.Errorf("Internal error")
+		}
+		return fmt.Errorf("Group not found")
+	}
+	if owner_id == nil || *owner_id != uid {
+		return fmt.Errorf("Unauthorized")
+	}
+
+	if tag, err := conn.Exec(ctx, `
+	DELETE FROM group_conversation_members WHERE group_id = $1 AND user_id = $2;
+	`, data.GroupID, data.Uid); err != nil {
+		return fmt.Errorf("Internal error")
+	} else if tag.RowsAffected() == 0 {
+		return fmt.Errorf("This user is not a member of the group")
+	}
+
+	if _, err = conn.Exec(ctx, `
+	DELETE FROM group_message_notifications WHERE group_id = $1 AND user_id = $2;
+	`, data.GroupID, data.Uid); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	leaveGroupSubByUid(h, data.GroupID, data.Uid)
+
+	if err = sendGroupChange(ctx, conn, h, data.GroupID, data.Uid); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	return nil
+}
+
+func groupLeave(inData map[string]interface{}, h handler, uid string, c *websocket.Conn) error {
+	data := &socketValidation.GroupOpenedClosedLeave{}
+	if err := UnmarshalMap(inData, data); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	conn, err := h.DB.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	defer conn.Release()
+
+	if err = checkGroupMember(ctx, conn, uid, data.GroupID); err != nil {
+		return err
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err = tx.Exec(ctx, `
+	DELETE FROM group_conversation_members WHERE group_id = $1 AND user_id = $2;
+	`, data.GroupID, uid); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	if _, err = tx.Exec(ctx, `
+	DELETE FROM group_message_notifications WHERE group_id = $1 AND user_id = $2;
+	`, data.GroupID, uid); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	// the group is deleted when the last member leaves
+	var remaining int
+	if err = tx.QueryRow(ctx, `
+	SELECT COUNT(*) FROM group_conversation_members WHERE group_id = $1;
+	`, data.GroupID).Scan(&remaining); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	if remaining == 0 {
+		if _, err = tx.Exec(ctx, `
+		DELETE FROM group_conversations WHERE id = $1;
+		`, data.GroupID); err != nil {
+			return fmt.Errorf("Internal error")
+		}
+	} else {
+		// if the owner left, the member who has been in the group the longest becomes the owner
+		if _, err = tx.Exec(ctx, `
+		UPDATE group_conversations SET owner_id = (
+			SELECT user_id FROM group_conversation_members WHERE group_id = $1 ORDER BY joined_at ASC LIMIT 1
+		) WHERE id = $1 AND (owner_id = $2 OR owner_id IS NULL);
+		`, data.GroupID, uid); err != nil {
+			return fmt.Errorf("Internal error")
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	h.SocketServer.LeaveSubscriptionByWs <- socketServer.RegisterUnregisterSubsConnWs{
+		SubName: fmt.Sprintf("group:%v", data.GroupID),
+		Conn:    c,
+	}
+
+	if remaining == 0 {
+		changeData := make(map[string]interface{})
+		changeData["ID"] = data.GroupID
+		h.SocketServer.SendDataToUser <- socketServer.UserMessageData{
+			Uid: uid,
+			Data: socketMessages.ChangeEvent{
+				Type:   "DELETE",
+				Entity: "GROUP",
+				Data:   changeData,
+			},
+			MessageType: "CHANGE",
+		}
+		return nil
+	}
+
+	if err = sendGroupChange(ctx, conn, h, data.GroupID, uid); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	return nil
+}
+
+func leaveGroupSubByUid(h handler, groupID string, uid string) {
+	recvChan := make(chan *websocket.Conn, 1)
+	h.SocketServer.GetConnection <- socketServer.GetConnection{
+		RecvChan: recvChan,
+		Uid:      uid,
+	}
+	c := <-recvChan
+
+	close(recvChan)
+
+	if c == nil {
+		return
+	}
+
+	h.SocketServer.LeaveSubscriptionByWs <- socketServer.RegisterUnregisterSubsConnWs{
+		SubName: fmt.Sprintf("group:%v", groupID),
+		Conn:    c,
+	}
+}
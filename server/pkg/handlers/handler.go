@@ -6,8 +6,11 @@ import (
 	attachmentServer "github.com/web-stuff-98/psql-social/pkg/attachmentServer"
 	callServer "github.com/web-stuff-98/psql-social/pkg/callServer"
 	"github.com/web-stuff-98/psql-social/pkg/channelRTCserver"
+	contentFilter "github.com/web-stuff-98/psql-social/pkg/contentFilter"
+	exportServer "github.com/web-stuff-98/psql-social/pkg/exportServer"
 	socketLimiter "github.com/web-stuff-98/psql-social/pkg/socketLimiter"
 	"github.com/web-stuff-98/psql-social/pkg/socketServer"
+	webhookServer "github.com/web-stuff-98/psql-social/pkg/webhookServer"
 )
 
 type handler struct {
@@ -18,6 +21,9 @@ type handler struct {
 	ChannelRTCServer *channelRTCserver.ChannelRTCServer
 	AttachmentServer *attachmentServer.AttachmentServer
 	SocketLimiter    *socketLimiter.SocketLimiter
+	WebhookServer    *webhookServer.WebhookServer
+	ContentFilter    *contentFilter.ContentFilter
+	ExportServer     *exportServer.ExportServer
 }
 
 func New(
@@ -27,7 +33,10 @@ func New(
 	cs *callServer.CallServer,
 	cRTCs *channelRTCserver.ChannelRTCServer,
 	as *attachmentServer.AttachmentServer,
-	sl *socketLimiter.SocketLimiter) handler {
+	sl *socketLimiter.SocketLimiter,
+	ws *webhookServer.WebhookServer,
+	cf *contentFilter.ContentFilter,
+	es *exportServer.ExportServer) handler {
 	return handler{
 		db,
 		rdb,
@@ -36,5 +45,8 @@ func New(
 		cRTCs,
 		as,
 		sl,
+		ws,
+		cf,
+		es,
 	}
 }
@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/pgtype"
+	"github.com/jackc/pgx/v5"
+	"github.com/web-stuff-98/psql-social/pkg/helpers/authHelpers"
+	"github.com/web-stuff-98/psql-social/pkg/responses"
+	socketMessages "github.com/web-stuff-98/psql-social/pkg/socketMessages"
+	"github.com/web-stuff-98/psql-social/pkg/socketServer"
+	"github.com/web-stuff-98/psql-social/pkg/validation"
+)
+
+/*
+	Users can ask to join private rooms with a message. The owner gets a
+	JOIN_REQUEST event, members who can invite people (see permInvite) can
+	list, approve and deny requests. The requester gets a
+	JOIN_REQUEST_RESPONSE event either way, and the request is deleted.
+*/
+
+func (h handler) CreateJoinRequest(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	v := validator.New()
+	body := &validation.CreateJoinRequest{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	room_id := ctx.Params("id")
+	if room_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	var private, banned, member bool
+	var author_id string
+	if err = h.DB.QueryRow(rctx, `
+	SELECT private,author_id,
+	EXISTS(SELECT 1 FROM bans WHERE user_id = $1 AND room_id = $2),
+	EXISTS(SELECT 1 FROM members WHERE user_id = $1 AND room_id = $2)
+	FROM rooms WHERE id = $2;
+	`, uid, room_id).Scan(&private, &author_id, &banned, &member); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Room not found")
+	}
+	if banned {
+		return fiber.NewError(fiber.StatusForbidden, "You are banned from this room")
+	}
+	if member || author_id == uid {
+		return fiber.NewError(fiber.StatusBadRequest, "You are already a member of this room")
+	}
+	if !private {
+		return fiber.NewError(fiber.StatusBadRequest, "Anyone can join this room")
+	}
+
+	content := strings.TrimSpace(body.Message)
+	filtered, err := filterMessage(rctx, h, uid, "JOIN_REQUEST", room_id, content, 200)
+	if err != nil {
+		if err.Error() == "Internal error" {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	message := filtered.Content
+
+	createdAt := time.Now()
+	if tag, err := h.DB.Exec(rctx, `
+	INSERT INTO join_requests (room_id,user_id,message,created_at) VALUES($1,$2,$3,$4) ON CONFLICT DO NOTHING;
+	`, room_id, uid, message, createdAt); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else if tag.RowsAffected() == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "You have already asked to join this room")
+	}
+
+	// join requests have no ID of their own
+	if err = recordFilterMatches(rctx, h, uid, "JOIN_REQUEST", "", room_id, content, filtered); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	h.SocketServer.SendDataToUser <- socketServer.UserMessageData{
+		Uid: author_id,
+		Data: socketMessages.JoinRequest{
+			RoomID:    room_id,
+			UserID:    uid,
+			Message:   message,
+			CreatedAt: createdAt.Format(time.RFC3339),
+		},
+		MessageType: "JOIN_REQUEST",
+	}
+
+	ctx.Status(fiber.StatusCreated)
+
+	return nil
+}
+
+// Withdraws the users own request to join the room
+func (h handler) DeleteJoinRequest(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	room_id := ctx.Params("id")
+	if room_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	if tag, err := h.DB.Exec(rctx, `
+	DELETE FROM join_requests WHERE room_id = $1 AND user_id = $2;
+	`, room_id, uid); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else if tag.RowsAffected() == 0 {
+		return fiber.NewError(fiber.StatusNotFound, "Join request not found")
+	}
+
+	return nil
+}
+
+// Lists pending requests to join the room, oldest first
+func (h handler) GetJoinRequests(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	room_id := ctx.Params("id")
+	if room_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	if err = requireRoomPermission(rctx, h, uid, room_id, permInvite); err != nil {
+		return err
+	}
+
+	rows, err := h.DB.Query(rctx, `
+	SELECT user_id,message,created_at FROM join_requests WHERE room_id = $1 ORDER BY created_at ASC;
+	`, room_id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer rows.Close()
+
+	requests := []responses.JoinRequest{}
+	for rows.Next() {
+		var r responses.JoinRequest
+		var created_at pgtype.Timestamptz
+		if err = rows.Scan(&r.UserID, &r.Message, &created_at); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		r.CreatedAt = created_at.Time.Format(time.RFC3339)
+		requests = append(requests, r)
+	}
+	rows.Close()
+
+	if bytes, err := json.Marshal(requests); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
+
+// Approves or denies a request to join the room
+func (h handler) RespondToJoinRequest(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	v := validator.New()
+	body := &validation.JoinRequestResponse{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	room_id := ctx.Params("id")
+	user_id := ctx.Params("uid")
+	if room_id == "" || user_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	if err = requireRoomPermission(rctx, h, uid, room_id, permInvite); err != nil {
+		return err
+	}
+
+	tx, err := h.DB.Begin(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer tx.Rollback(rctx)
+
+	var message string
+	if err = tx.QueryRow(rctx, `
+	DELETE FROM join_requests WHERE room_id = $1 AND user_id = $2 RETURNING message;
+	`, room_id, user_id).Scan(&message); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Join request not found")
+	}
+
+	if body.Accepted {
+		var banned bool
+		if err = tx.QueryRow(rctx, `
+		SELECT EXISTS(SELECT 1 FROM bans WHERE user_id = $1 AND room_id = $2);
+		`, user_id, room_id).Scan(&banned); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		if banned {
+			return fiber.NewError(fiber.StatusBadRequest, "That user is banned from this room")
+		}
+
+		if _, err = tx.Exec(rctx, `
+		INSERT INTO members (user_id,room_id) VALUES($1, $2) ON CONFLICT DO NOTHING;
+		`, user_id, room_id); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		// an invitation to the room isn't needed anymore
+		if _, err = tx.Exec(rctx, `
+		DELETE FROM invitations WHERE invited = $1 AND room_id = $2;
+		`, user_id, room_id); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+	}
+
+	if err = tx.Commit(rctx); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	action := "JOIN_DENY"
+	if body.Accepted {
+		action = "JOIN_APPROVE"
+	}
+	before := make(map[string]interface{})
+	before["message"] = message
+	if err = recordAuditLog(rctx, h, room_id, uid, action, user_id, before, nil); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if body.Accepted {
+		dispatchRoomWebhookEvent(h, room_id, "MEMBER_JOINED", map[string]interface{}{
+			"user_id":     user_id,
+			"approved_by": uid,
+		})
+	}
+
+	h.SocketServer.SendDataToUsers <- socketServer.UsersMessageData{
+		Uids: []string{user_id, uid},
+		Data: socketMessages.JoinRequestResponse{
+			RoomID:   room_id,
+			UserID:   user_id,
+			Accepted: body.Accepted,
+		},
+		MessageType: "JOIN_REQUEST_RESPONSE",
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+	"github.com/web-stuff-98/psql-social/pkg/helpers/authHelpers"
+)
+
+// Only lets users with the ADMIN role through. Bots are never admins
+func AdminOnly(next fiber.Handler, rdb *redis.Client, db *pgxpool.Pool) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		rctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		defer cancel()
+
+		uid, sid, err := authHelpers.GetUidAndSid(rdb, ctx, rctx, db)
+		if err != nil || sid == "" {
+			return errMsg(ctx, fiber.StatusUnauthorized, "Unauthorized")
+		}
+
+		var role string
+		if err = db.QueryRow(rctx, `
+		SELECT role FROM users WHERE id = $1;
+		`, uid).Scan(&role); err != nil {
+			return errMsg(ctx, fiber.StatusInternalServerError, "Internal error")
+		}
+		if role != "ADMIN" {
+			return errMsg(ctx, fiber.StatusUnauthorized, "Unauthorized")
+		}
+
+		return next(ctx)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
+	"github.com/web-stuff-98/psql-social/pkg/helpers/authHelpers"
 )
 
 type BlockInfo struct {
@@ -24,6 +25,14 @@ type SimpleLimiterOpts struct {
 	RouteName     string        `json:"-"`
 }
 
+// Bots are limited by their token instead of by IP, with the same options on every route
+var BotLimiterOpts = SimpleLimiterOpts{
+	Window:        time.Second * 10,
+	MaxReqs:       60,
+	BlockDuration: time.Minute * 5,
+	Message:       "Too many requests",
+}
+
 func errMsg(ctx *fiber.Ctx, s int, m string) error {
 	ctx.Set("Content-Type", "text/plain")
 	return ctx.Status(s).SendString(m)
@@ -39,8 +48,31 @@ func BasicRateLimiter(next fiber.Handler, opts SimpleLimiterOpts, rdb *redis.Cli
 		rctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 		defer cancel()
 
+		// copied so that the bot options don't overwrite the routes options
+		opts := opts
+
 		// Find IP block info on redis
 		ipInfoKey := "simple-api-limiter-info:" + ctx.IP() + "=" + opts.RouteName
+		if token := authHelpers.GetBotToken(ctx); token != "" {
+			// only tokens that exist get their own bucket, otherwise made up tokens could be used to get around the IP limit
+			tokenHash := authHelpers.HashBotToken(token)
+			var tokenExists bool
+			if err := db.QueryRow(rctx, `
+			SELECT EXISTS(SELECT 1 FROM bot_tokens WHERE token_hash = $1);
+			`, tokenHash).Scan(&tokenExists); err != nil {
+				return errMsg(ctx, fiber.StatusInternalServerError, "Internal error")
+			}
+			if tokenExists {
+				ipInfoKey = "simple-api-limiter-info:bot-" + tokenHash + "=" + opts.RouteName
+				opts = SimpleLimiterOpts{
+					Window:        BotLimiterOpts.Window,
+					MaxReqs:       BotLimiterOpts.MaxReqs,
+					BlockDuration: BotLimiterOpts.BlockDuration,
+					Message:       BotLimiterOpts.Message,
+					RouteName:     opts.RouteName,
+				}
+			}
+		}
 		ipInfoCmd := rdb.Get(rctx, ipInfoKey)
 		ipInfo := &BlockInfo{}
 		if ipInfoCmd.Err() == nil {
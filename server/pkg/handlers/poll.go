@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/websocket/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	contentFilter "github.com/web-stuff-98/psql-social/pkg/contentFilter"
+	pollHelpers "github.com/web-stuff-98/psql-social/pkg/helpers/pollHelpers"
+	socketMessages "github.com/web-stuff-98/psql-social/pkg/socketMessages"
+	"github.com/web-stuff-98/psql-social/pkg/socketServer"
+	socketValidation "github.com/web-stuff-98/psql-social/pkg/socketValidation"
+)
+
+/*
+	Polls are room messages with options attached, the question is the
+	message content. Tallies are sent out on the channel subscription
+	whenever someone votes, and when the poll closes. Polls with a closing
+	time are closed by watchPollDeadlines in main.go.
+*/
+
+const maxPollDuration = time.Hour * 24 * 30
+
+type newPoll struct {
+	options   []string
+	multiple  bool
+	anonymous bool
+	closesAt  *time.Time
+}
+
+func roomPoll(inData map[string]interface{}, h handler, uid string, c *websocket.Conn) error {
+	data := &socketValidation.RoomPoll{}
+	var err error
+	if err = UnmarshalMap(inData, data); err != nil {
+		return err
+	}
+
+	question := strings.TrimSpace(data.Question)
+	if question == "" {
+		return fmt.Errorf("Bad request")
+	}
+
+	options := []string{}
+	seen := make(map[string]struct{})
+	for _, o := range data.Options {
+		o = strings.TrimSpace(o)
+		if o == "" {
+			return fmt.Errorf("Poll options cannot be empty")
+		}
+		if _, ok := seen[o]; ok {
+			return fmt.Errorf("Poll options must be unique")
+		}
+		seen[o] = struct{}{}
+		options = append(options, o)
+	}
+
+	// the options are set once they have been through the content filter
+	poll := &newPoll{
+		multiple:  data.Multiple,
+		anonymous: data.Anonymous,
+	}
+	if data.ClosesAt != "" {
+		closesAt, err := time.Parse(time.RFC3339, data.ClosesAt)
+		if err != nil {
+			return fmt.Errorf("Bad request")
+		}
+		if !closesAt.After(time.Now()) {
+			return fmt.Errorf("The closing time must be in the future")
+		}
+		if closesAt.After(time.Now().Add(maxPollDuration)) {
+			return fmt.Errorf("Polls cannot stay open for longer than 30 days")
+		}
+		poll.closesAt = &closesAt
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	room_id, author_id, err := roomChannelAccess(ctx, h, uid, data.ChannelID)
+	if err != nil {
+		return err
+	}
+
+	if err = checkNotMuted(ctx, h, uid, room_id); err != nil {
+		return err
+	}
+
+	if err = checkAcceptedRules(ctx, h, uid, room_id); err != nil {
+		return err
+	}
+
+	if err = checkCanPost(ctx, h, uid, data.ChannelID, author_id); err != nil {
+		return err
+	}
+
+	if err = checkSlowMode(ctx, h, uid, data.ChannelID, author_id); err != nil {
+		return err
+	}
+
+	filteredQuestion, err := filterMessage(ctx, h, uid, "ROOM_MESSAGE", room_id, question, 0)
+	if err != nil {
+		return err
+	}
+	filteredOptions := make([]contentFilter.Result, len(options))
+	poll.options = make([]string, len(options))
+	for i, o := range options {
+		if filteredOptions[i], err = filterMessage(ctx, h, uid, "ROOM_MESSAGE", room_id, o, 100); err != nil {
+			return err
+		}
+		poll.options[i] = filteredOptions[i].Content
+	}
+
+	id, err := postRoomMessage(ctx, h, uid, data.ChannelID, room_id, author_id, filteredQuestion.Content, roomMessageOpts{
+		poll: poll,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = recordFilterMatches(ctx, h, uid, "ROOM_MESSAGE", id, room_id, question, filteredQuestion); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	for i, o := range options {
+		if err = recordFilterMatches(ctx, h, uid, "ROOM_MESSAGE", id, room_id, o, filteredOptions[i]); err != nil {
+			return fmt.Errorf("Internal error")
+		}
+	}
+
+	return nil
+}
+
+// Inserts the poll and its options for a message that was just inserted. If it fails the message is deleted.
+func insertPoll(ctx context.Context, conn *pgxpool.Conn, messageID string, p *newPoll) (*socketMessages.Poll, error) {
+	poll, err := func() (*socketMessages.Poll, error) {
+		tx, err := conn.Begin(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer tx.Rollback(ctx)
+
+		poll := &socketMessages.Poll{
+			MessageID: messageID,
+			Multiple:  p.multiple,
+			Anonymous: p.anonymous,
+			Options:   []socketMessages.PollOption{},
+		}
+		if p.closesAt != nil {
+			poll.ClosesAt = p.closesAt.Format(time.RFC3339)
+		}
+
+		if err = tx.QueryRow(ctx, `
+		INSERT INTO polls (message_id,multiple,anonymous,closes_at) VALUES($1,$2,$3,$4) RETURNING id;
+		`, messageID, p.multiple, p.anonymous, p.closesAt).Scan(&poll.ID); err != nil {
+			return nil, err
+		}
+
+		for i, o := range p.options {
+			var id string
+			if err = tx.QueryRow(ctx, `
+			INSERT INTO poll_options (poll_id,content,position) VALUES($1,$2,$3) RETURNING id;
+			`, poll.ID, o, i).Scan(&id); err != nil {
+				return nil, err
+			}
+			poll.Options = append(poll.Options, socketMessages.PollOption{
+				ID:      id,
+				Content: o,
+			})
+		}
+
+		return poll, tx.Commit(ctx)
+	}()
+	if err != nil {
+		conn.Exec(ctx, "DELETE FROM room_messages WHERE id = $1;", messageID)
+		return nil, fmt.Errorf("Internal error")
+	}
+
+	return poll, nil
+}
+
+// Returns the channel ID of an open poll after checking the user has access to the channel
+func openPollChannel(ctx context.Context, conn *pgxpool.Conn, h handler, uid string, pollID string) (channelID string, multiple bool, err error) {
+	selectStmt, err := conn.Conn().Prepare(ctx, "open_poll_channel_select_stmt", `
+	SELECT room_messages.room_channel_id,polls.multiple,polls.closed OR COALESCE(polls.closes_at <= NOW(), FALSE)
+	FROM polls
+	INNER JOIN room_messages ON room_messages.id = polls.message_id
+	WHERE polls.id = $1;
+	`)
+	if err != nil {
+		return "", false, fmt.Errorf("Internal error")
+	}
+
+	var closed bool
+	if err = conn.QueryRow(ctx, selectStmt.Name, pollID).Scan(&channelID, &multiple, &closed); err != nil {
+		if err != pgx.ErrNoRows {
+			return "", false, fmt.Errorf("Internal error")
+		}
+		return "", false, fmt.Errorf("Poll not found")
+	}
+
+	if _, _, err = roomChannelAccess(ctx, h, uid, channelID); err != nil {
+		return "", false, err
+	}
+
+	if closed {
+		return "", false, fmt.Errorf("This poll is closed")
+	}
+
+	return channelID, multiple, nil
+}
+
+// Replaces the users votes on the poll
+func pollVote(inData map[string]interface{}, h handler, uid string, c *websocket.Conn) error {
+	data := &socketValidation.PollVote{}
+	var err error
+	if err = UnmarshalMap(inData, data); err != nil {
+		return err
+	}
+
+	optionIDs := []string{}
+	seen := make(map[string]struct{})
+	for _, id := range data.OptionIDs {
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			optionIDs = append(optionIDs, id)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	conn, err := h.DB.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	defer conn.Release()
+
+	channel_id, multiple, err := openPollChannel(ctx, conn, h, uid, data.PollID)
+	if err != nil {
+		return err
+	}
+
+	if !multiple && len(optionIDs) != 1 {
+		return fmt.Errorf("You can only vote for one option on this poll")
+	}
+
+	countStmt, err := conn.Conn().Prepare(ctx, "poll_vote_count_options_stmt", `
+	SELECT COUNT(*) FROM poll_options WHERE poll_id = $1 AND id = ANY($2::UUID[]);
+	`)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	var count int
+	if err = conn.QueryRow(ctx, countStmt.Name, data.PollID, optionIDs).Scan(&count); err != nil {
+		return fmt.Errorf("Bad request")
+	}
+	if count != len(optionIDs) {
+		return fmt.Errorf("Option not found")
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err = tx.Exec(ctx, `
+	DELETE FROM poll_votes WHERE poll_id = $1 AND user_id = $2;
+	`, data.PollID, uid); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	for _, id := range optionIDs {
+		if _, err = tx.Exec(ctx, `
+		INSERT INTO poll_votes (poll_id,option_id,user_id) VALUES($1,$2,$3);
+		`, data.PollID, id, uid); err != nil {
+			return fmt.Errorf("Internal error")
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	return sendPollTally(ctx, conn, h.SocketServer, channel_id, data.PollID)
+}
+
+func pollRetract(inData map[string]interface{}, h handler, uid string, c *websocket.Conn) error {
+	data := &socketValidation.PollRetract{}
+	var err error
+	if err = UnmarshalMap(inData, data); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	conn, err := h.DB.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	defer conn.Release()
+
+	channel_id, _, err := openPollChannel(ctx, conn, h, uid, data.PollID)
+	if err != nil {
+		return err
+	}
+
+	if _, err = conn.Exec(ctx, `
+	DELETE FROM poll_votes WHERE poll_id = $1 AND user_id = $2;
+	`, data.PollID, uid); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	return sendPollTally(ctx, conn, h.SocketServer, channel_id, data.PollID)
+}
+
+// Sends the current tally to everyone in the channel
+func sendPollTally(ctx context.Context, conn *pgxpool.Conn, ss *socketServer.SocketServer, channelID string, pollID string) error {
+	poll, err := pollHelpers.GetPollTally(conn, ctx, pollID)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	ss.SendDataToSub <- socketServer.SubscriptionMessageData{
+		SubName:     fmt.Sprintf("channel:%v", channelID),
+		Data:        poll,
+		MessageType: "POLL_TALLY",
+	}
+
+	return nil
+}
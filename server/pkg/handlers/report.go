@@ -0,0 +1,544 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/pgtype"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/web-stuff-98/psql-social/pkg/helpers/authHelpers"
+	"github.com/web-stuff-98/psql-social/pkg/responses"
+	socketMessages "github.com/web-stuff-98/psql-social/pkg/socketMessages"
+	"github.com/web-stuff-98/psql-social/pkg/socketServer"
+	"github.com/web-stuff-98/psql-social/pkg/validation"
+)
+
+/*
+	Users can report room messages, direct messages, users and rooms.
+	Reports go into a queue that users with the ADMIN role work through,
+	an admin claims a report so that other admins leave it alone, then
+	resolves it (optionally deleting the content, suspending the user or
+	deleting the room) or dismisses it. The decision is kept on the report.
+
+	The admin endpoints are wrapped in middleware.AdminOnly.
+*/
+
+const reportsPageSize = 30
+
+// The pool or a transaction, so that reports can be locked while they are resolved
+type reportQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+}
+
+type report struct {
+	targetType     string
+	targetID       string
+	reportedUserID *string
+	status         string
+	claimedBy      *string
+}
+
+func (h handler) CreateReport(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	v := validator.New()
+	body := &validation.CreateReport{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	reason := strings.TrimSpace(body.Reason)
+	if reason == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	// the reporter has to be able to see what they are reporting
+	var reported_user_id *string
+	var content string
+	switch body.TargetType {
+	case "ROOM_MESSAGE":
+		var channel_id string
+		if err = h.DB.QueryRow(rctx, `
+		SELECT author_id,content,room_channel_id FROM room_messages WHERE id = $1;
+		`, body.TargetID).Scan(&reported_user_id, &content, &channel_id); err != nil {
+			if err != pgx.ErrNoRows {
+				return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+			}
+			return fiber.NewError(fiber.StatusNotFound, "Message not found")
+		}
+		permissions, room_id, err := getChannelPermissions(rctx, h, uid, channel_id)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		if err = checkRoomReadAccess(rctx, h, uid, room_id); err != nil {
+			return err
+		}
+		if !permissions.has(permViewChannel) {
+			return fiber.NewError(fiber.StatusNotFound, "Message not found")
+		}
+	case "DIRECT_MESSAGE":
+		var recipient_id string
+		if err = h.DB.QueryRow(rctx, `
+		SELECT author_id,recipient_id,content FROM direct_messages WHERE id = $1;
+		`, body.TargetID).Scan(&reported_user_id, &recipient_id, &content); err != nil {
+			if err != pgx.ErrNoRows {
+				return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+			}
+			return fiber.NewError(fiber.StatusNotFound, "Message not found")
+		}
+		if recipient_id != uid {
+			return fiber.NewError(fiber.StatusNotFound, "Message not found")
+		}
+	case "USER":
+		if err = h.DB.QueryRow(rctx, `
+		SELECT id,username FROM users WHERE id = $1;
+		`, body.TargetID).Scan(&reported_user_id, &content); err != nil {
+			if err != pgx.ErrNoRows {
+				return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+			}
+			return fiber.NewError(fiber.StatusNotFound, "User not found")
+		}
+	case "ROOM":
+		if err = h.DB.QueryRow(rctx, `
+		SELECT author_id,name FROM rooms WHERE id = $1;
+		`, body.TargetID).Scan(&reported_user_id, &content); err != nil {
+			if err != pgx.ErrNoRows {
+				return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+			}
+			return fiber.NewError(fiber.StatusNotFound, "Room not found")
+		}
+		if err = checkRoomReadAccess(rctx, h, uid, body.TargetID); err != nil {
+			return err
+		}
+	}
+
+	if reported_user_id != nil && *reported_user_id == uid {
+		return fiber.NewError(fiber.StatusBadRequest, "You cannot report yourself")
+	}
+
+	var exists bool
+	if err = h.DB.QueryRow(rctx, `
+	SELECT EXISTS(SELECT 1 FROM reports WHERE reporter_id = $1 AND target_id = $2 AND status IN ('OPEN','CLAIMED'));
+	`, uid, body.TargetID).Scan(&exists); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if exists {
+		return fiber.NewError(fiber.StatusBadRequest, "You have already reported this")
+	}
+
+	var id string
+	if err = h.DB.QueryRow(rctx, `
+	INSERT INTO reports (reporter_id,reported_user_id,target_type,target_id,reason,content)
+	VALUES($1,$2,$3,$4,$5,$6) RETURNING id;
+	`, uid, reported_user_id, body.TargetType, body.TargetID, reason, content).Scan(&id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	ctx.Response().Header.Add("Content-Type", "text/plain")
+	ctx.WriteString(id)
+	ctx.Status(fiber.StatusCreated)
+
+	return nil
+}
+
+// The columns scanned by scanReports
+const reportColumns = `id,reporter_id::TEXT,reported_user_id::TEXT,target_type,target_id::TEXT,reason,content,status,claimed_by::TEXT,
+	created_at,decided_by::TEXT,decided_at,decided_actions,decision_note`
+
+func scanReports(rows pgx.Rows) ([]responses.Report, error) {
+	reports := []responses.Report{}
+	for rows.Next() {
+		var r responses.Report
+		var reporter_id, reported_user_id, claimed_by, decided_by *string
+		var created_at, decided_at pgtype.Timestamptz
+		if err := rows.Scan(&r.ID, &reporter_id, &reported_user_id, &r.TargetType, &r.TargetID, &r.Reason, &r.Content, &r.Status, &claimed_by,
+			&created_at, &decided_by, &decided_at, &r.DecidedActions, &r.DecisionNote); err != nil {
+			return nil, err
+		}
+		if reporter_id != nil {
+			r.ReporterID = *reporter_id
+		}
+		if reported_user_id != nil {
+			r.ReportedUserID = *reported_user_id
+		}
+		if claimed_by != nil {
+			r.ClaimedBy = *claimed_by
+		}
+		if decided_by != nil {
+			r.DecidedBy = *decided_by
+		}
+		r.CreatedAt = created_at.Time.Format(time.RFC3339)
+		if decided_at.Status == pgtype.Present {
+			r.DecidedAt = decided_at.Time.Format(time.RFC3339)
+		}
+		reports = append(reports, r)
+	}
+	return reports, rows.Err()
+}
+
+// Lists reports oldest first, filtered by status if the status query is set
+func (h handler) GetReports(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	page := ctx.QueryInt("page", 1)
+	if page < 1 {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	offset := (page - 1) * reportsPageSize
+
+	status := ctx.Query("status")
+	switch status {
+	case "", "OPEN", "CLAIMED", "RESOLVED", "DISMISSED":
+	default:
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	selectStmt, err := conn.Conn().Prepare(rctx, "get_reports_select_stmt", `
+	SELECT `+reportColumns+`
+	FROM reports
+	WHERE $1 = '' OR status = $1
+	ORDER BY created_at ASC
+	LIMIT $2 OFFSET $3;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	rows, err := conn.Query(rctx, selectStmt.Name, status, reportsPageSize, offset)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer rows.Close()
+
+	reports, err := scanReports(rows)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	rows.Close()
+
+	var count int
+	if err = conn.QueryRow(rctx, `
+	SELECT COUNT(*) FROM reports WHERE $1 = '' OR status = $1;
+	`, status).Scan(&count); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if bytes, err := json.Marshal(responses.ReportsPage{
+		Reports: reports,
+		Count:   count,
+	}); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
+
+// Returns the report if it is still open, and isn't claimed by another admin.
+// Inside a transaction the report stays locked until the transaction ends
+func getUndecidedReport(ctx context.Context, db reportQuerier, uid string, id string) (*report, error) {
+	r := &report{}
+	if err := db.QueryRow(ctx, `
+	SELECT target_type,target_id::TEXT,reported_user_id::TEXT,status,claimed_by::TEXT FROM reports WHERE id = $1 FOR UPDATE;
+	`, id).Scan(&r.targetType, &r.targetID, &r.reportedUserID, &r.status, &r.claimedBy); err != nil {
+		if err != pgx.ErrNoRows {
+			return nil, fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return nil, fiber.NewError(fiber.StatusNotFound, "Report not found")
+	}
+	if r.status != "OPEN" && r.status != "CLAIMED" {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "This report has already been closed")
+	}
+	if r.status == "CLAIMED" && (r.claimedBy == nil || *r.claimedBy != uid) {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "This report has been claimed by another admin")
+	}
+	return r, nil
+}
+
+// Claims the report, or releases it if the request method is DELETE
+func (h handler) ClaimReport(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	id := ctx.Params("id")
+	if id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	if _, err = getUndecidedReport(rctx, h.DB, uid, id); err != nil {
+		return err
+	}
+
+	// the conditions are checked again by the update, in case another admin got there first
+	var tag pgconn.CommandTag
+	if ctx.Method() == fiber.MethodDelete {
+		tag, err = h.DB.Exec(rctx, `
+		UPDATE reports SET status = 'OPEN', claimed_by = NULL
+		WHERE id = $1 AND (status = 'OPEN' OR (status = 'CLAIMED' AND claimed_by = $2));
+		`, id, uid)
+	} else {
+		tag, err = h.DB.Exec(rctx, `
+		UPDATE reports SET status = 'CLAIMED', claimed_by = $1
+		WHERE id = $2 AND (status = 'OPEN' OR (status = 'CLAIMED' AND claimed_by = $1));
+		`, uid, id)
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if tag.RowsAffected() == 0 {
+		if _, err = getUndecidedReport(rctx, h.DB, uid, id); err != nil {
+			return err
+		}
+		return fiber.NewError(fiber.StatusBadRequest, "This report has been claimed by another admin")
+	}
+
+	return nil
+}
+
+func (h handler) ResolveReport(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	v := validator.New()
+	body := &validation.ResolveReport{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	id := ctx.Params("id")
+	if id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	// the report is locked until it is decided, so that two admins can't both take action on it
+	tx, err := conn.Begin(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer tx.Rollback(rctx)
+
+	r, err := getUndecidedReport(rctx, tx, uid, id)
+	if err != nil {
+		return err
+	}
+
+	// check every action can be taken before taking any of them
+	actions := make(map[string]struct{})
+	decided_actions := []string{}
+	for _, action := range body.Actions {
+		switch action {
+		case "DELETE_CONTENT":
+			if r.targetType != "ROOM_MESSAGE" && r.targetType != "DIRECT_MESSAGE" {
+				return fiber.NewError(fiber.StatusBadRequest, "Only reported messages can be deleted")
+			}
+		case "DELETE_ROOM":
+			if r.targetType != "ROOM" {
+				return fiber.NewError(fiber.StatusBadRequest, "Only reported rooms can be deleted")
+			}
+		case "SUSPEND_USER":
+			if r.reportedUserID == nil {
+				return fiber.NewError(fiber.StatusBadRequest, "There is no user to suspend")
+			}
+			var role string
+			if err = h.DB.QueryRow(rctx, `
+			SELECT role FROM users WHERE id = $1;
+			`, *r.reportedUserID).Scan(&role); err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+			}
+			if role == "ADMIN" {
+				return fiber.NewError(fiber.StatusBadRequest, "You cannot suspend an admin")
+			}
+		}
+		if _, ok := actions[action]; !ok {
+			decided_actions = append(decided_actions, action)
+		}
+		actions[action] = struct{}{}
+	}
+
+	if _, ok := actions["DELETE_CONTENT"]; ok {
+		if r.targetType == "ROOM_MESSAGE" {
+			err = deleteReportedRoomMessage(rctx, h, uid, r.targetID)
+		} else {
+			err = deleteReportedDirectMessage(rctx, h, r.targetID)
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+	}
+	if _, ok := actions["SUSPEND_USER"]; ok {
+		if err = suspendUser(rctx, h, *r.reportedUserID, body.SuspendDays); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+	}
+	if _, ok := actions["DELETE_ROOM"]; ok {
+		if err = removeRoom(rctx, h, r.targetID); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+	}
+
+	if err = decideReport(rctx, tx, uid, id, "RESOLVED", decided_actions, body.Note); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(rctx); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	return nil
+}
+
+func (h handler) DismissReport(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	v := validator.New()
+	body := &validation.DismissReport{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	id := ctx.Params("id")
+	if id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	if _, err = getUndecidedReport(rctx, h.DB, uid, id); err != nil {
+		return err
+	}
+
+	return decideReport(rctx, h.DB, uid, id, "DISMISSED", []string{}, body.Note)
+}
+
+// Closes the report and records the decision
+func decideReport(ctx context.Context, db reportQuerier, uid string, id string, status string, actions []string, note string) error {
+	tag, err := db.Exec(ctx, `
+	UPDATE reports SET status = $1, claimed_by = NULL, decided_by = $2, decided_at = NOW(), decided_actions = $3, decision_note = $4
+	WHERE id = $5 AND status IN ('OPEN','CLAIMED');
+	`, status, uid, actions, strings.TrimSpace(note), id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if tag.RowsAffected() == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "This report has already been closed")
+	}
+	return nil
+}
+
+// Deletes a reported room message. Recorded in the rooms audit log with the admin as the actor
+func deleteReportedRoomMessage(ctx context.Context, h handler, uid string, msgID string) error {
+	var author_id *string
+	var channel_id, room_id, content string
+	if err := h.DB.QueryRow(ctx, `
+	DELETE FROM room_messages USING room_channels
+	WHERE room_messages.id = $1 AND room_channels.id = room_messages.room_channel_id
+	RETURNING room_messages.author_id,room_messages.room_channel_id,room_channels.room_id,room_messages.content;
+	`, msgID).Scan(&author_id, &channel_id, &room_id, &content); err != nil {
+		if err != pgx.ErrNoRows {
+			return err
+		}
+		// already deleted
+		return nil
+	}
+
+	if err := markBookmarksUnavailable(ctx, h, msgID); err != nil {
+		return err
+	}
+
+	before := make(map[string]interface{})
+	before["author_id"] = author_id
+	before["channel_id"] = channel_id
+	before["content"] = content
+	if err := recordAuditLog(ctx, h, room_id, uid, "MESSAGE_DELETE", msgID, before, nil); err != nil {
+		return err
+	}
+
+	h.SocketServer.SendDataToSub <- socketServer.SubscriptionMessageData{
+		SubName: fmt.Sprintf("channel:%v", channel_id),
+		Data: socketMessages.RoomMessageDelete{
+			ID: msgID,
+		},
+		MessageType: "ROOM_MESSAGE_DELETE",
+	}
+
+	return nil
+}
+
+func deleteReportedDirectMessage(ctx context.Context, h handler, msgID string) error {
+	var author_id, recipient_id string
+	if err := h.DB.QueryRow(ctx, `
+	DELETE FROM direct_messages WHERE id = $1 RETURNING author_id,recipient_id;
+	`, msgID).Scan(&author_id, &recipient_id); err != nil {
+		if err != pgx.ErrNoRows {
+			return err
+		}
+		// already deleted
+		return nil
+	}
+
+	if err := markBookmarksUnavailable(ctx, h, msgID); err != nil {
+		return err
+	}
+
+	h.SocketServer.SendDataToUsers <- socketServer.UsersMessageData{
+		Uids: []string{author_id, recipient_id},
+		Data: socketMessages.DirectMessageDelete{
+			ID:          msgID,
+			AuthorID:    author_id,
+			RecipientID: recipient_id,
+		},
+		MessageType: "DIRECT_MESSAGE_DELETE",
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/web-stuff-98/psql-social/pkg/helpers/authHelpers"
+	socketMessages "github.com/web-stuff-98/psql-social/pkg/socketMessages"
+	"github.com/web-stuff-98/psql-social/pkg/socketServer"
+	"github.com/web-stuff-98/psql-social/pkg/validation"
+)
+
+/*
+	Room owners can set how many days messages are kept for, for the
+	whole room or for a single channel. Expired messages are purged by
+	purgeExpiredMessages in main.go.
+
+	For rooms a null or 0 retention keeps messages forever. For channels
+	null uses the rooms retention and 0 keeps messages forever.
+*/
+
+func (h handler) UpdateRoomRetention(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	v := validator.New()
+	body := &validation.UpdateRetention{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	room_id := ctx.Params("id")
+	if room_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	var author_id string
+	var seeded bool
+	var prev_days *int
+	if err = h.DB.QueryRow(rctx, `
+	SELECT author_id,seeded,retention_days FROM rooms WHERE id = $1;
+	`, room_id).Scan(&author_id, &seeded, &prev_days); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Room not found")
+	}
+	if seeded {
+		return fiber.NewError(fiber.StatusBadRequest, "You cannot modify the example rooms")
+	}
+	if author_id != uid {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	days := body.Days
+	if days != nil && *days == 0 {
+		days = nil
+	}
+
+	if _, err = h.DB.Exec(rctx, `
+	UPDATE rooms SET retention_days = $1 WHERE id = $2;
+	`, days, room_id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	before := make(map[string]interface{})
+	before["retention_days"] = prev_days
+	after := make(map[string]interface{})
+	after["retention_days"] = days
+	if err = recordAuditLog(rctx, h, room_id, uid, "ROOM_UPDATE", room_id, before, after); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	outChangeData := make(map[string]interface{})
+	outChangeData["ID"] = room_id
+	outChangeData["retention_days"] = days
+
+	h.SocketServer.SendDataToSub <- socketServer.SubscriptionMessageData{
+		SubName: fmt.Sprintf("room:%v", room_id),
+		Data: socketMessages.ChangeEvent{
+			Type:   "UPDATE",
+			Entity: "ROOM",
+			Data:   outChangeData,
+		},
+		MessageType: "CHANGE",
+	}
+
+	return nil
+}
+
+func (h handler) UpdateRoomChannelRetention(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	v := validator.New()
+	body := &validation.UpdateRetention{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	channel_id := ctx.Params("id")
+	if channel_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	var room_id, author_id string
+	var seeded bool
+	var prev_days *int
+	if err = h.DB.QueryRow(rctx, `
+	SELECT rooms.id,rooms.author_id,rooms.seeded,room_channels.retention_days FROM room_channels
+	INNER JOIN rooms ON rooms.id = room_channels.room_id
+	WHERE room_channels.id = $1;
+	`, channel_id).Scan(&room_id, &author_id, &seeded, &prev_days); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Channel not found")
+	}
+	if seeded {
+		return fiber.NewError(fiber.StatusBadRequest, "You cannot modify the example rooms")
+	}
+	if author_id != uid {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	if _, err = h.DB.Exec(rctx, `
+	UPDATE room_channels SET retention_days = $1 WHERE id = $2;
+	`, body.Days, channel_id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	before := make(map[string]interface{})
+	before["retention_days"] = prev_days
+	after := make(map[string]interface{})
+	after["retention_days"] = body.Days
+	if err = recordAuditLog(rctx, h, room_id, uid, "CHANNEL_UPDATE", channel_id, before, after); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	outChangeData := make(map[string]interface{})
+	outChangeData["ID"] = channel_id
+	outChangeData["retention_days"] = body.Days
+
+	if err = sendChannelChange(rctx, h, room_id, channel_id, socketMessages.ChangeEvent{
+		Type:   "UPDATE",
+		Entity: "CHANNEL",
+		Data:   outChangeData,
+	}); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	return nil
+}
@@ -19,6 +19,7 @@ import (
 	"github.com/nfnt/resize"
 	"github.com/web-stuff-98/psql-social/pkg/channelRTCserver"
 	"github.com/web-stuff-98/psql-social/pkg/helpers/authHelpers"
+	pollHelpers "github.com/web-stuff-98/psql-social/pkg/helpers/pollHelpers"
 	"github.com/web-stuff-98/psql-social/pkg/responses"
 	socketMessages "github.com/web-stuff-98/psql-social/pkg/socketMessages"
 	"github.com/web-stuff-98/psql-social/pkg/socketServer"
@@ -45,6 +46,25 @@ func (h handler) CreateRoom(ctx *fiber.Ctx) error {
 
 	name := strings.TrimSpace(body.Name)
 
+	// bot rooms would be deleted along with the bot, without being passed on
+	var isBot bool
+	if err = h.DB.QueryRow(rctx, `
+	SELECT bot FROM users WHERE id = $1;
+	`, uid).Scan(&isBot); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if isBot {
+		return fiber.NewError(fiber.StatusForbidden, "Bots cannot own rooms")
+	}
+
+	filtered, allowed, err := filterName(rctx, h, uid, "ROOM_NAME", "", name)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if !allowed {
+		return fiber.NewError(fiber.StatusBadRequest, "That room name is not allowed")
+	}
+
 	conn, err := h.DB.Acquire(rctx)
 	if err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
@@ -89,6 +109,10 @@ func (h handler) CreateRoom(ctx *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 	}
 
+	if err = recordFilterMatches(rctx, h, uid, "ROOM_NAME", id, "", name, filtered); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
 	outChangeData := make(map[string]interface{})
 	outChangeData["ID"] = id
 	outChangeData["name"] = name
@@ -152,14 +176,15 @@ func (h handler) UpdateRoom(ctx *fiber.Ctx) error {
 	defer conn.Release()
 
 	selectStmt, err := conn.Conn().Prepare(rctx, "update_room_select_stmt", `
-	SELECT author_id FROM rooms WHERE id = $1;
+	SELECT author_id,name,private FROM rooms WHERE id = $1;
 	`)
 	if err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 	}
 
-	var author_id string
-	if err := conn.QueryRow(rctx, selectStmt.Name, room_id).Scan(&author_id); err != nil {
+	var author_id, prev_name string
+	var prev_private bool
+	if err := conn.QueryRow(rctx, selectStmt.Name, room_id).Scan(&author_id, &prev_name, &prev_private); err != nil {
 		if err != pgx.ErrNoRows {
 			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 		} else {
@@ -179,10 +204,32 @@ func (h handler) UpdateRoom(ctx *fiber.Ctx) error {
 	}
 
 	name := strings.TrimSpace(body.Name)
+	filtered, allowed, err := filterName(rctx, h, uid, "ROOM_NAME", room_id, name)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if !allowed {
+		return fiber.NewError(fiber.StatusBadRequest, "That room name is not allowed")
+	}
+
 	if _, err := conn.Exec(rctx, updateStmt.Name, name, body.Private, room_id); err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 	}
 
+	if err = recordFilterMatches(rctx, h, uid, "ROOM_NAME", room_id, "", name, filtered); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	before := make(map[string]interface{})
+	before["name"] = prev_name
+	before["private"] = prev_private
+	after := make(map[string]interface{})
+	after["name"] = name
+	after["private"] = body.Private
+	if err = recordAuditLog(rctx, h, room_id, uid, "ROOM_UPDATE", room_id, before, after); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
 	outChangeData := make(map[string]interface{})
 	outChangeData["ID"] = room_id
 	outChangeData["name"] = name
@@ -224,6 +271,11 @@ func (h handler) UpdateRoomChannel(ctx *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
 	}
 
+	channelType := body.Type
+	if channelType == "" {
+		channelType = "TEXT"
+	}
+
 	conn, err := h.DB.Acquire(rctx)
 	if err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
@@ -247,13 +299,12 @@ func (h handler) UpdateRoomChannel(ctx *fiber.Ctx) error {
 
 	var seeded bool
 	selectRoomStmt, err := conn.Conn().Prepare(rctx, "update_channel_select_room_stmt", `
-	SELECT seeded,author_id FROM rooms WHERE id = $1;
+	SELECT seeded FROM rooms WHERE id = $1;
 	`)
 	if err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 	}
-	var author_id string
-	if err = conn.QueryRow(rctx, selectRoomStmt.Name, room_id).Scan(&seeded, &author_id); err != nil {
+	if err = conn.QueryRow(rctx, selectRoomStmt.Name, room_id).Scan(&seeded); err != nil {
 		if err != pgx.ErrNoRows {
 			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 		} else {
@@ -265,8 +316,21 @@ func (h handler) UpdateRoomChannel(ctx *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusBadRequest, "You cannot modify the example rooms")
 	}
 
-	if author_id != uid {
-		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	if err = requireRoomPermission(rctx, h, uid, room_id, permManageChannels); err != nil {
+		return err
+	}
+
+	filteredName, allowed, err := filterName(rctx, h, uid, "CHANNEL_NAME", channel_id, body.Name)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if !allowed {
+		return fiber.NewError(fiber.StatusBadRequest, "That channel name is not allowed")
+	}
+
+	before, err := getChannelAuditValues(rctx, h, channel_id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 	}
 
 	if body.Main {
@@ -276,63 +340,100 @@ func (h handler) UpdateRoomChannel(ctx *fiber.Ctx) error {
 		}
 
 		updateChannelStmt, err := conn.Conn().Prepare(rctx, "update_channel_update_with_main_stmt", `
-		UPDATE room_channels SET name = $1, main = $2 WHERE id = $3;
+		UPDATE room_channels SET name = $1, main = $2, slow_mode = $3, type = $4 WHERE id = $5;
 		`)
 		if err != nil {
 			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 		}
-		if _, err = conn.Exec(rctx, updateChannelStmt.Name, body.Name, body.Main, channel_id); err != nil {
+		if _, err = conn.Exec(rctx, updateChannelStmt.Name, body.Name, body.Main, body.SlowMode, channelType, channel_id); err != nil {
 			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 		}
 	} else {
 		// otherwise don't update main
 		updateChannelStmt, err := conn.Conn().Prepare(rctx, "update_channel_update_without_main_stmt", `
-		UPDATE room_channels SET name = $1 WHERE id = $2;
+		UPDATE room_channels SET name = $1, slow_mode = $2, type = $3 WHERE id = $4;
 		`)
 		if err != nil {
 			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 		}
-		if _, err = conn.Exec(rctx, updateChannelStmt.Name, body.Name, channel_id); err != nil {
+		if _, err = conn.Exec(rctx, updateChannelStmt.Name, body.Name, body.SlowMode, channelType, channel_id); err != nil {
 			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 		}
 	}
 
-	selectChannelsStmt, err := conn.Conn().Prepare(rctx, "update_channel_select_channels_stmt", `
-	SELECT id FROM room_channels WHERE room_id = $1;
-	`)
-	if err != nil {
+	if err = recordFilterMatches(rctx, h, uid, "CHANNEL_NAME", channel_id, "", body.Name, filteredName); err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 	}
-	channel_sub_names := []string{}
 
-	if rows, err := conn.Query(rctx, selectChannelsStmt.Name, room_id); err != nil {
-		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
-	} else {
-		defer rows.Close()
-
-		for rows.Next() {
-			var id string
-			if err = rows.Scan(&id); err != nil {
+	if body.Topic != nil {
+		filtered, err := filterChannelTopic(rctx, h, uid, room_id, *body.Topic)
+		if err != nil {
+			if err.Error() == "Internal error" {
 				return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 			}
-			channel_sub_names = append(channel_sub_names, fmt.Sprintf("channel:%v", id))
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
 		}
+		if _, err = conn.Exec(rctx, `
+		UPDATE room_channels SET topic = $1 WHERE id = $2;
+		`, filtered.Content, channel_id); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		if err = recordFilterMatches(rctx, h, uid, "CHANNEL_TOPIC", channel_id, room_id, *body.Topic, filtered); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		body.Topic = &filtered.Content
+	}
+
+	if body.CategoryID != nil {
+		category_id, err := checkChannelCategory(rctx, h, body.CategoryID, room_id)
+		if err != nil {
+			return err
+		}
+		if _, err = conn.Exec(rctx, `
+		UPDATE room_channels SET category_id = $1 WHERE id = $2;
+		`, category_id, channel_id); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+	}
+
+	// only announcement channels can be followed
+	if channelType != "ANNOUNCEMENT" {
+		if _, err = conn.Exec(rctx, `
+		DELETE FROM channel_follows WHERE source_channel_id = $1;
+		`, channel_id); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+	}
+
+	after, err := getChannelAuditValues(rctx, h, channel_id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if err = recordAuditLog(rctx, h, room_id, uid, "CHANNEL_UPDATE", channel_id, before, after); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 	}
 
 	changeData := make(map[string]interface{})
 	changeData["ID"] = channel_id
 	changeData["name"] = body.Name
+	changeData["slow_mode"] = body.SlowMode
+	changeData["type"] = channelType
 	if body.Main {
 		changeData["main"] = true
 	}
-	h.SocketServer.SendDataToSubs <- socketServer.SubscriptionsMessageData{
-		SubNames: channel_sub_names,
-		Data: socketMessages.ChangeEvent{
-			Type:   "UPDATE",
-			Entity: "CHANNEL",
-			Data:   changeData,
-		},
-		MessageType: "CHANGE",
+	if body.Topic != nil {
+		changeData["topic"] = *body.Topic
+	}
+	if body.CategoryID != nil {
+		changeData["category_id"] = *body.CategoryID
+	}
+	// members who aren't viewing a channel still see the channel list, unless the channel is hidden from them
+	if err = sendChannelChange(rctx, h, room_id, channel_id, socketMessages.ChangeEvent{
+		Type:   "UPDATE",
+		Entity: "CHANNEL",
+		Data:   changeData,
+	}); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 	}
 
 	return nil
@@ -376,13 +477,12 @@ func (h handler) DeleteRoomChannel(ctx *fiber.Ctx) error {
 
 	var seeded bool
 	selectRoomStmt, err := conn.Conn().Prepare(rctx, "delete_channel_select_room_stmt", `
-	SELECT seeded,author_id FROM rooms WHERE id = $1;
+	SELECT seeded FROM rooms WHERE id = $1;
 	`)
 	if err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 	}
-	var author_id string
-	if err = conn.QueryRow(rctx, selectRoomStmt.Name, room_id).Scan(&seeded, &author_id); err != nil {
+	if err = conn.QueryRow(rctx, selectRoomStmt.Name, room_id).Scan(&seeded); err != nil {
 		if err != pgx.ErrNoRows {
 			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 		} else {
@@ -394,14 +494,19 @@ func (h handler) DeleteRoomChannel(ctx *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusBadRequest, "You cannot modify the example rooms")
 	}
 
-	if author_id != uid {
-		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	if err = requireRoomPermission(rctx, h, uid, room_id, permManageChannels); err != nil {
+		return err
 	}
 
 	if main {
 		return fiber.NewError(fiber.StatusBadRequest, "You cannot delete the main channel, create a new main channel first, or promote another channel")
 	}
 
+	before, err := getChannelAuditValues(rctx, h, channel_id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
 	deleteChannelStmt, err := conn.Conn().Prepare(rctx, "delete_channel_delete_stmt", `
 	DELETE FROM room_channels WHERE id = $1;
 	`)
@@ -412,6 +517,10 @@ func (h handler) DeleteRoomChannel(ctx *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 	}
 
+	if err = recordAuditLog(rctx, h, room_id, uid, "CHANNEL_DELETE", channel_id, before, nil); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
 	selectChannelsStmt, err := conn.Conn().Prepare(rctx, "delete_channel_select_channels_stmt", `
 	SELECT id FROM room_channels WHERE room_id = $1;
 	`)
@@ -434,6 +543,8 @@ func (h handler) DeleteRoomChannel(ctx *fiber.Ctx) error {
 		}
 	}
 
+	channel_sub_names = append(channel_sub_names, fmt.Sprintf("room:%v", room_id))
+
 	changeData := make(map[string]interface{})
 	changeData["ID"] = channel_id
 	h.SocketServer.SendDataToSubs <- socketServer.SubscriptionsMessageData{
@@ -467,6 +578,11 @@ func (h handler) CreateRoomChannel(ctx *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
 	}
 
+	channelType := body.Type
+	if channelType == "" {
+		channelType = "TEXT"
+	}
+
 	conn, err := h.DB.Acquire(rctx)
 	if err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
@@ -479,22 +595,21 @@ func (h handler) CreateRoomChannel(ctx *fiber.Ctx) error {
 	}
 
 	selectRoomStmt, err := conn.Conn().Prepare(rctx, "create_channel_select_room_stmt", `
-	SELECT author_id,seeded FROM rooms WHERE id = $1;
+	SELECT seeded FROM rooms WHERE id = $1;
 	`)
 	if err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 	}
-	var author_id string
 	var seeded bool
-	if err = conn.Conn().QueryRow(rctx, selectRoomStmt.Name, room_id).Scan(&author_id, &seeded); err != nil {
+	if err = conn.Conn().QueryRow(rctx, selectRoomStmt.Name, room_id).Scan(&seeded); err != nil {
 		if err != pgx.ErrNoRows {
 			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 		} else {
 			return fiber.NewError(fiber.StatusNotFound, "Room not found")
 		}
 	}
-	if author_id != uid {
-		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	if err = requireRoomPermission(rctx, h, uid, room_id, permManageChannels); err != nil {
+		return err
 	}
 
 	if seeded {
@@ -515,6 +630,14 @@ func (h handler) CreateRoomChannel(ctx *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusBadRequest, "You already have another channel by that name")
 	}
 
+	filteredName, allowed, err := filterName(rctx, h, uid, "CHANNEL_NAME", "", body.Name)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if !allowed {
+		return fiber.NewError(fiber.StatusBadRequest, "That channel name is not allowed")
+	}
+
 	// if the new channel being created is the main channel, set "main" on other channels to false
 	if body.Main {
 		updateMainStmt, err := conn.Conn().Prepare(rctx, "create_channel_update_main_stmt", `
@@ -528,52 +651,74 @@ func (h handler) CreateRoomChannel(ctx *fiber.Ctx) error {
 		}
 	}
 
+	topic := ""
+	if body.Topic != nil {
+		topic = *body.Topic
+	}
+	filteredTopic, err := filterChannelTopic(rctx, h, uid, room_id, topic)
+	if err != nil {
+		if err.Error() == "Internal error" {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	category_id, err := checkChannelCategory(rctx, h, body.CategoryID, room_id)
+	if err != nil {
+		return err
+	}
+
+	// new channels go to the bottom of the list
 	insertStmt, err := conn.Conn().Prepare(rctx, "create_channel_insert_stmt", `
-	INSERT INTO room_channels (name,main,room_id) VALUES($1,$2,$3) RETURNING id;
+	INSERT INTO room_channels (name,main,room_id,slow_mode,type,topic,category_id,position)
+	VALUES($1,$2,$3,$4,$5,$6,$7,(SELECT COALESCE(MAX(position) + 1, 0) FROM room_channels WHERE room_id = $3))
+	RETURNING id,position;
 	`)
 	if err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 	}
 	var channel_id string
-	if err = conn.Conn().QueryRow(rctx, insertStmt.Name, body.Name, body.Main, room_id).Scan(&channel_id); err != nil {
+	var position int
+	if err = conn.Conn().QueryRow(rctx, insertStmt.Name, body.Name, body.Main, room_id, body.SlowMode, channelType, filteredTopic.Content, category_id).Scan(&channel_id, &position); err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 	}
 
-	selectChannelsStmt, err := conn.Conn().Prepare(rctx, "create_channel_select_channels_stmt", `
-	SELECT id FROM room_channels WHERE room_id = $1;
-	`)
-	if err != nil {
+	if err = recordFilterMatches(rctx, h, uid, "CHANNEL_NAME", channel_id, "", body.Name, filteredName); err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 	}
-	channel_sub_names := []string{}
-	if rows, err := conn.Query(rctx, selectChannelsStmt.Name, room_id); err != nil {
+	if err = recordFilterMatches(rctx, h, uid, "CHANNEL_TOPIC", channel_id, room_id, topic, filteredTopic); err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
-	} else {
-		defer rows.Close()
+	}
 
-		for rows.Next() {
-			var id string
-			if err = rows.Scan(&id); err != nil {
-				return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
-			}
-			channel_sub_names = append(channel_sub_names, fmt.Sprintf("channel:%v", id))
-		}
+	after, err := getChannelAuditValues(rctx, h, channel_id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if err = recordAuditLog(rctx, h, room_id, uid, "CHANNEL_CREATE", channel_id, nil, after); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 	}
 
 	changeData := make(map[string]interface{})
 	changeData["ID"] = channel_id
 	changeData["name"] = body.Name
 	changeData["main"] = body.Main
-	h.SocketServer.SendDataToSubs <- socketServer.SubscriptionsMessageData{
-		SubNames: channel_sub_names,
-		Data: socketMessages.ChangeEvent{
-			Type:   "INSERT",
-			Entity: "CHANNEL",
-			Data:   changeData,
-		},
-		MessageType: "CHANGE",
+	changeData["slow_mode"] = body.SlowMode
+	changeData["type"] = channelType
+	changeData["topic"] = filteredTopic.Content
+	changeData["category_id"] = ""
+	if category_id != nil {
+		changeData["category_id"] = *category_id
+	}
+	changeData["position"] = position
+	if err = sendChannelChange(rctx, h, room_id, channel_id, socketMessages.ChangeEvent{
+		Type:   "INSERT",
+		Entity: "CHANNEL",
+		Data:   changeData,
+	}); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 	}
 
+	dispatchRoomWebhookEvent(h, room_id, "CHANNEL_CREATED", changeData)
+
 	return nil
 }
 
@@ -615,15 +760,17 @@ func (h handler) GetRoom(ctx *fiber.Ctx) error {
 	}
 
 	selectStmt, err := conn.Conn().Prepare(rctx, "get_room_select_stmt", `
-	SELECT id,name,author_id,private FROM rooms WHERE id = $1;
+	SELECT id,name,author_id,private,retention_days,succession,successor_id::TEXT FROM rooms WHERE id = $1;
 	`)
 	if err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 	}
 
 	var id, name, author_id string
-	var private bool
-	if err := conn.QueryRow(rctx, selectStmt.Name, room_id).Scan(&id, &name, &author_id, &private); err != nil {
+	var private, succession bool
+	var retention_days *int
+	var successor_id *string
+	if err := conn.QueryRow(rctx, selectStmt.Name, room_id).Scan(&id, &name, &author_id, &private, &retention_days, &succession, &successor_id); err != nil {
 		if err != pgx.ErrNoRows {
 			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 		} else {
@@ -650,12 +797,40 @@ func (h handler) GetRoom(ctx *fiber.Ctx) error {
 		}
 	}
 
-	if bytes, err := json.Marshal(responses.Room{
-		ID:       id,
-		Name:     name,
-		AuthorID: author_id,
-		Private:  private,
-	}); err != nil {
+	channelRetentionDays := make(map[string]int)
+	if rows, err := conn.Query(rctx, `
+	SELECT id,retention_days FROM room_channels WHERE room_id = $1 AND retention_days IS NOT NULL;
+	`, room_id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		defer rows.Close()
+		for rows.Next() {
+			var channel_id string
+			var days int
+			if err = rows.Scan(&channel_id, &days); err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+			}
+			channelRetentionDays[channel_id] = days
+		}
+		rows.Close()
+	}
+
+	room := responses.Room{
+		ID:                   id,
+		Name:                 name,
+		AuthorID:             author_id,
+		Private:              private,
+		RetentionDays:        retention_days,
+		ChannelRetentionDays: channelRetentionDays,
+	}
+	if uid == author_id {
+		room.Succession = &succession
+		if successor_id != nil {
+			room.SuccessorID = *successor_id
+		}
+	}
+
+	if bytes, err := json.Marshal(room); err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 	} else {
 		ctx.Response().Header.Add("Content-Type", "application/json")
@@ -1263,10 +1438,23 @@ func (h handler) GetRoomChannel(ctx *fiber.Ctx) error {
 		}
 	}
 
+	permissions, _, err := getChannelPermissions(rctx, h, uid, room_channel_id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if !permissions.has(permViewChannel) {
+		return fiber.NewError(fiber.StatusForbidden, "You do not have permission to view this channel")
+	}
+
 	selectChannelStmt, err := conn.Conn().Prepare(rctx, "get_room_channel_select_channel_stmt", `
-	SELECT id,content,author_id,created_at,has_attachment
-	FROM room_messages WHERE room_channel_id = $1
-	ORDER BY created_at ASC LIMIT 50;
+	SELECT room_messages.id,content,room_messages.author_id,room_messages.created_at,has_attachment,webhook_id,webhook_username,webhook_avatar,polls.id,
+	source_channel_id,source_author_id,source_channels.name,source_rooms.id,source_rooms.name
+	FROM room_messages
+	LEFT JOIN polls ON polls.message_id = room_messages.id
+	LEFT JOIN room_channels AS source_channels ON source_channels.id = room_messages.source_channel_id
+	LEFT JOIN rooms AS source_rooms ON source_rooms.id = source_channels.room_id
+	WHERE room_channel_id = $1
+	ORDER BY room_messages.created_at ASC LIMIT 50;
 	`)
 	if err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
@@ -1278,24 +1466,90 @@ func (h handler) GetRoomChannel(ctx *fiber.Ctx) error {
 	}
 	defer rows.Close()
 	messages := []responses.RoomMessage{}
+	// poll ids by the index of the message, the polls are selected after the rows are closed
+	pollIDs := make(map[int]string)
 	for rows.Next() {
-		var id, content, author_id string
+		var id, content string
+		// author_id is null for webhook messages and mirrored messages
+		var author_id, webhook_id, webhook_username, webhook_avatar, poll_id *string
+		var source_channel_id, source_author_id, source_channel_name, source_room_id, source_room_name *string
 		var created_at pgtype.Timestamptz
 		var has_attachment bool
 
-		err = rows.Scan(&id, &content, &author_id, &created_at, &has_attachment)
+		err = rows.Scan(&id, &content, &author_id, &created_at, &has_attachment, &webhook_id, &webhook_username, &webhook_avatar, &poll_id,
+			&source_channel_id, &source_author_id, &source_channel_name, &source_room_id, &source_room_name)
 
 		if err != nil {
 			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 		}
 
-		messages = append(messages, responses.RoomMessage{
+		msg := responses.RoomMessage{
 			ID:            id,
 			Content:       content,
-			AuthorID:      author_id,
 			CreatedAt:     created_at.Time.Format(time.RFC3339),
 			HasAttachment: has_attachment,
-		})
+		}
+		if author_id != nil {
+			msg.AuthorID = *author_id
+		}
+		if webhook_username != nil {
+			msg.Webhook = &responses.RoomMessageWebhook{
+				Username: *webhook_username,
+			}
+			if webhook_id != nil {
+				msg.Webhook.ID = *webhook_id
+			}
+			if webhook_avatar != nil {
+				msg.Webhook.AvatarURL = *webhook_avatar
+			}
+		}
+		if source_channel_id != nil || source_author_id != nil {
+			msg.Source = &responses.RoomMessageSource{}
+			if source_channel_id != nil {
+				msg.Source.ChannelID = *source_channel_id
+				msg.Source.ChannelName = *source_channel_name
+				msg.Source.RoomID = *source_room_id
+				msg.Source.RoomName = *source_room_name
+			}
+			if source_author_id != nil {
+				msg.Source.AuthorID = *source_author_id
+			}
+		}
+		if poll_id != nil {
+			pollIDs[len(messages)] = *poll_id
+		}
+
+		messages = append(messages, msg)
+	}
+	rows.Close()
+
+	for i, poll_id := range pollIDs {
+		poll, err := pollHelpers.GetPollTally(conn, rctx, poll_id)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		myVotes, err := pollHelpers.GetUserVotes(conn, rctx, poll_id, uid)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		options := []responses.PollOption{}
+		for _, o := range poll.Options {
+			options = append(options, responses.PollOption{
+				ID:      o.ID,
+				Content: o.Content,
+				Votes:   o.Votes,
+				Voters:  o.Voters,
+			})
+		}
+		messages[i].Poll = &responses.Poll{
+			ID:        poll.ID,
+			Multiple:  poll.Multiple,
+			Anonymous: poll.Anonymous,
+			ClosesAt:  poll.ClosesAt,
+			Closed:    poll.Closed,
+			Options:   options,
+			MyVotes:   myVotes,
+		}
 	}
 
 	recvChan := make(chan map[string]struct{}, 1)
@@ -1400,12 +1654,21 @@ func (h handler) GetRoomChannels(ctx *fiber.Ctx) error {
 	}
 
 	selectChannelsStatement, err := conn.Conn().Prepare(rctx, "get_room_channels_select_stmt", `
-	SELECT id,name,main FROM room_channels WHERE room_id = $1;
+	SELECT room_channels.id,room_channels.name,main,topic,slow_mode,type,category_id,room_channels.position FROM room_channels
+	LEFT JOIN room_channel_categories ON room_channel_categories.id = room_channels.category_id
+	WHERE room_channels.room_id = $1
+	ORDER BY room_channel_categories.position NULLS FIRST, room_channels.position, room_channels.name;
 	`)
 	if err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 	}
 
+	// channels the user can't view are left out
+	channelPermissions, err := getRoomChannelsPermissions(rctx, h, uid, room_id, "")
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
 	channels := []responses.RoomChannelBase{}
 
 	if rows, err := conn.Query(rctx, selectChannelsStatement.Name, room_id); err != nil {
@@ -1413,17 +1676,30 @@ func (h handler) GetRoomChannels(ctx *fiber.Ctx) error {
 	} else {
 		defer rows.Close()
 		for rows.Next() {
-			var id, name string
+			var id, name, topic, channel_type string
+			var category_id *string
 			var main bool
-			if err = rows.Scan(&id, &name, &main); err != nil {
+			var slow_mode, position int
+			if err = rows.Scan(&id, &name, &main, &topic, &slow_mode, &channel_type, &category_id, &position); err != nil {
 				return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 			}
+			if !channelPermissions[id].has(permViewChannel) {
+				continue
+			}
 
-			channels = append(channels, responses.RoomChannelBase{
-				ID:   id,
-				Name: name,
-				Main: main,
-			})
+			channel := responses.RoomChannelBase{
+				ID:       id,
+				Name:     name,
+				Main:     main,
+				Topic:    topic,
+				SlowMode: slow_mode,
+				Type:     channel_type,
+				Position: position,
+			}
+			if category_id != nil {
+				channel.CategoryID = *category_id
+			}
+			channels = append(channels, channel)
 		}
 
 		if data, err := json.Marshal(channels); err != nil {
@@ -0,0 +1,505 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/websocket/v2"
+	"github.com/jackc/pgx/v5"
+	socketMessages "github.com/web-stuff-98/psql-social/pkg/socketMessages"
+	"github.com/web-stuff-98/psql-social/pkg/socketServer"
+	socketValidation "github.com/web-stuff-98/psql-social/pkg/socketValidation"
+)
+
+/*
+	Room messages starting with a forward slash are dispatched
+	to the command registry instead of being posted. Each command
+	declares its arguments so that the client can autocomplete them,
+	the list is sent out on the ROOM_COMMANDS event.
+
+	The last argument of a command can be TEXT, in which case it
+	gets the rest of the message including spaces.
+*/
+
+type roomCommandContext struct {
+	h         handler
+	ctx       context.Context
+	uid       string
+	channelID string
+	roomID    string
+	ownerID   string
+}
+
+type roomCommandArg struct {
+	name string
+	// USER/TEXT/DICE/DURATION
+	argType  string
+	required bool
+}
+
+var sanctionCommandArgs = []roomCommandArg{
+	{name: "user", argType: "USER", required: true},
+	{name: "duration", argType: "DURATION"},
+	{name: "reason", argType: "TEXT"},
+}
+
+type roomCommand struct {
+	description string
+	args        []roomCommandArg
+	// the room permission needed to use the command, 0 if anyone can use it
+	permission roomPermissions
+	run        func(cmd *roomCommandContext, args []string) error
+}
+
+var roomCommands = map[string]roomCommand{
+	"me": {
+		description: "Post an action",
+		args:        []roomCommandArg{{name: "action", argType: "TEXT", required: true}},
+		run:         meCommand,
+	},
+	"topic": {
+		description: "Set the channel topic, leave empty to clear it",
+		args:        []roomCommandArg{{name: "topic", argType: "TEXT"}},
+		permission:  permManageChannels,
+		run:         topicCommand,
+	},
+	"kick": {
+		description: "Remove a user from the room",
+		args:        []roomCommandArg{{name: "user", argType: "USER", required: true}},
+		permission:  permKick,
+		run:         kickCommand,
+	},
+	"ban": {
+		description: "Ban a user from the room, for example /ban bob 7d spamming. Bans are permanent without a duration",
+		args:        sanctionCommandArgs,
+		permission:  permBan,
+		run:         banCommand,
+	},
+	"unban": {
+		description: "Unban a user from the room",
+		args:        []roomCommandArg{{name: "user", argType: "USER", required: true}},
+		permission:  permBan,
+		run:         unbanCommand,
+	},
+	"invite": {
+		description: "Invite a user to the room",
+		args:        []roomCommandArg{{name: "user", argType: "USER", required: true}},
+		permission:  permInvite,
+		run:         inviteCommand,
+	},
+	"mute": {
+		description: "Stop a user from posting messages or joining voice in the room, for example /mute bob 30m",
+		args:        sanctionCommandArgs,
+		permission:  permKick,
+		run:         muteCommand,
+	},
+	"unmute": {
+		description: "Allow a muted user to post messages again",
+		args:        []roomCommandArg{{name: "user", argType: "USER", required: true}},
+		permission:  permKick,
+		run:         unmuteCommand,
+	},
+	"roll": {
+		description: "Roll dice, for example 2d6",
+		args:        []roomCommandArg{{name: "dice", argType: "DICE"}},
+		run:         rollCommand,
+	},
+}
+
+func handleRoomCommand(cmd *roomCommandContext, content string) error {
+	fields := strings.Fields(strings.TrimPrefix(content, "/"))
+	if len(fields) == 0 {
+		return fmt.Errorf("Unknown command")
+	}
+
+	name := strings.ToLower(fields[0])
+	command, ok := roomCommands[name]
+	if !ok {
+		return fmt.Errorf("Unknown command /%v", name)
+	}
+
+	if command.permission != 0 {
+		permissions, err := getRoomPermissions(cmd.ctx, cmd.h, cmd.uid, cmd.roomID)
+		if err != nil {
+			return fmt.Errorf("Internal error")
+		}
+		if !permissions.has(command.permission) {
+			return fmt.Errorf("You do not have permission to use /%v", name)
+		}
+	}
+
+	rest := fields[1:]
+	args := []string{}
+	for i, arg := range command.args {
+		if len(rest) == 0 {
+			if arg.required {
+				return fmt.Errorf("Usage: %v", roomCommandUsage(name, command))
+			}
+			break
+		}
+		if i == len(command.args)-1 && arg.argType == "TEXT" {
+			args = append(args, strings.Join(rest, " "))
+			rest = nil
+			break
+		}
+		args = append(args, rest[0])
+		rest = rest[1:]
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("Usage: %v", roomCommandUsage(name, command))
+	}
+
+	return command.run(cmd, args)
+}
+
+func roomCommandUsage(name string, command roomCommand) string {
+	usage := "/" + name
+	for _, arg := range command.args {
+		if arg.required {
+			usage += fmt.Sprintf(" <%v>", arg.name)
+		} else {
+			usage += fmt.Sprintf(" [%v]", arg.name)
+		}
+	}
+	return usage
+}
+
+// Sends a message only the user who ran the command can see
+func (cmd *roomCommandContext) reply(content string) {
+	cmd.h.SocketServer.SendDataToUser <- socketServer.UserMessageData{
+		Uid: cmd.uid,
+		Data: socketMessages.RoomCommandReply{
+			ChannelID: cmd.channelID,
+			Content:   content,
+		},
+		MessageType: "ROOM_COMMAND_REPLY",
+	}
+}
+
+// Posts a message to the channel as the user who ran the command
+func (cmd *roomCommandContext) post(content string) error {
+	_, err := sendRoomMessage(cmd.ctx, cmd.h, cmd.uid, cmd.channelID, cmd.roomID, cmd.ownerID, content, false)
+	return err
+}
+
+// Resolves a USER argument (a username) to a uid
+func (cmd *roomCommandContext) userID(username string) (string, error) {
+	var id string
+	if err := cmd.h.DB.QueryRow(cmd.ctx, `
+	SELECT id FROM users WHERE LOWER(username) = LOWER($1);
+	`, username).Scan(&id); err != nil {
+		if err != pgx.ErrNoRows {
+			return "", fmt.Errorf("Internal error")
+		}
+		return "", fmt.Errorf("User %v not found", username)
+	}
+	return id, nil
+}
+
+// Same as userID, except the caller and the owner of the room are not allowed
+func (cmd *roomCommandContext) targetUserID(username string) (string, error) {
+	id, err := cmd.userID(username)
+	if err != nil {
+		return "", err
+	}
+	if id == cmd.uid {
+		return "", fmt.Errorf("You cannot target yourself")
+	}
+	if id == cmd.ownerID {
+		return "", fmt.Errorf("You cannot target the owner of the room")
+	}
+	return id, nil
+}
+
+func meCommand(cmd *roomCommandContext, args []string) error {
+	// the client shows the authors name next to the message, so only the action is needed
+	return cmd.post(fmt.Sprintf("_%v_", args[0]))
+}
+
+func topicCommand(cmd *roomCommandContext, args []string) error {
+	topic := ""
+	if len(args) > 0 {
+		topic = args[0]
+	}
+	if len(topic) > 100 {
+		return fmt.Errorf("Topic too long")
+	}
+
+	filtered, err := filterChannelTopic(cmd.ctx, cmd.h, cmd.uid, cmd.roomID, topic)
+	if err != nil {
+		return err
+	}
+
+	var prev_topic string
+	if err := cmd.h.DB.QueryRow(cmd.ctx, `
+	SELECT topic FROM room_channels WHERE id = $1;
+	`, cmd.channelID).Scan(&prev_topic); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	if _, err := cmd.h.DB.Exec(cmd.ctx, `
+	UPDATE room_channels SET topic = $1 WHERE id = $2;
+	`, filtered.Content, cmd.channelID); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	if err = recordFilterMatches(cmd.ctx, cmd.h, cmd.uid, "CHANNEL_TOPIC", cmd.channelID, cmd.roomID, topic, filtered); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	topic = filtered.Content
+
+	before := make(map[string]interface{})
+	before["topic"] = prev_topic
+	after := make(map[string]interface{})
+	after["topic"] = topic
+	if err := recordAuditLog(cmd.ctx, cmd.h, cmd.roomID, cmd.uid, "CHANNEL_UPDATE", cmd.channelID, before, after); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	changeData := make(map[string]interface{})
+	changeData["ID"] = cmd.channelID
+	changeData["topic"] = topic
+	if err = sendChannelChange(cmd.ctx, cmd.h, cmd.roomID, cmd.channelID, socketMessages.ChangeEvent{
+		Type:   "UPDATE",
+		Entity: "CHANNEL",
+		Data:   changeData,
+	}); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	return nil
+}
+
+func kickCommand(cmd *roomCommandContext, args []string) error {
+	target, err := cmd.targetUserID(args[0])
+	if err != nil {
+		return err
+	}
+
+	if err = kickRoomMember(cmd.ctx, cmd.h, target, cmd.roomID, cmd.uid); err != nil {
+		return err
+	}
+
+	cmd.reply(fmt.Sprintf("%v was kicked from the room", args[0]))
+
+	return nil
+}
+
+// Returns the optional duration and reason arguments of /ban and /mute
+func sanctionCommandArgValues(args []string) (time.Duration, string, error) {
+	var duration time.Duration
+	reason := ""
+	if len(args) > 1 {
+		var err error
+		if duration, err = parseSanctionDuration(args[1]); err != nil {
+			return 0, "", err
+		}
+	}
+	if len(args) > 2 {
+		reason = args[2]
+	}
+	if len(reason) > 200 {
+		return 0, "", fmt.Errorf("Reason too long")
+	}
+	return duration, reason, nil
+}
+
+func banCommand(cmd *roomCommandContext, args []string) error {
+	target, err := cmd.targetUserID(args[0])
+	if err != nil {
+		return err
+	}
+
+	duration, reason, err := sanctionCommandArgValues(args)
+	if err != nil {
+		return err
+	}
+
+	if err = banRoomMember(cmd.ctx, cmd.h, target, cmd.roomID, cmd.uid, reason, duration); err != nil {
+		return err
+	}
+
+	if duration == 0 {
+		cmd.reply(fmt.Sprintf("%v was banned from the room", args[0]))
+	} else {
+		cmd.reply(fmt.Sprintf("%v was banned from the room for %v", args[0], args[1]))
+	}
+
+	return nil
+}
+
+func unbanCommand(cmd *roomCommandContext, args []string) error {
+	target, err := cmd.targetUserID(args[0])
+	if err != nil {
+		return err
+	}
+
+	if err = unbanRoomMember(cmd.ctx, cmd.h, target, cmd.roomID, cmd.uid); err != nil {
+		return err
+	}
+
+	cmd.reply(fmt.Sprintf("%v was unbanned", args[0]))
+
+	return nil
+}
+
+func inviteCommand(cmd *roomCommandContext, args []string) error {
+	target, err := cmd.targetUserID(args[0])
+	if err != nil {
+		return err
+	}
+
+	if err = inviteToRoom(cmd.ctx, cmd.h, cmd.uid, target, cmd.roomID); err != nil {
+		return err
+	}
+
+	cmd.reply(fmt.Sprintf("Invitation sent to %v", args[0]))
+
+	return nil
+}
+
+func muteCommand(cmd *roomCommandContext, args []string) error {
+	target, err := cmd.targetUserID(args[0])
+	if err != nil {
+		return err
+	}
+
+	duration, reason, err := sanctionCommandArgValues(args)
+	if err != nil {
+		return err
+	}
+
+	if err = muteRoomMember(cmd.ctx, cmd.h, target, cmd.roomID, cmd.uid, reason, duration); err != nil {
+		return err
+	}
+
+	if duration == 0 {
+		cmd.reply(fmt.Sprintf("%v was muted", args[0]))
+	} else {
+		cmd.reply(fmt.Sprintf("%v was muted for %v", args[0], args[1]))
+	}
+
+	return nil
+}
+
+func unmuteCommand(cmd *roomCommandContext, args []string) error {
+	target, err := cmd.targetUserID(args[0])
+	if err != nil {
+		return err
+	}
+
+	unmuted, err := unmuteRoomMember(cmd.ctx, cmd.h, target, cmd.roomID, cmd.uid)
+	if err != nil {
+		return err
+	}
+	if !unmuted {
+		return fmt.Errorf("%v is not muted", args[0])
+	}
+
+	cmd.reply(fmt.Sprintf("%v was unmuted", args[0]))
+
+	return nil
+}
+
+// Rolls NdM dice, defaults to 1d6
+func rollCommand(cmd *roomCommandContext, args []string) error {
+	count, sides := 1, 6
+	if len(args) > 0 {
+		parts := strings.Split(strings.ToLower(args[0]), "d")
+		if len(parts) != 2 {
+			return fmt.Errorf("Dice should be written like 2d6")
+		}
+		var err error
+		if parts[0] != "" {
+			if count, err = strconv.Atoi(parts[0]); err != nil {
+				return fmt.Errorf("Dice should be written like 2d6")
+			}
+		}
+		if sides, err = strconv.Atoi(parts[1]); err != nil {
+			return fmt.Errorf("Dice should be written like 2d6")
+		}
+	}
+	if count < 1 || count > 20 || sides < 2 || sides > 1000 {
+		return fmt.Errorf("You can roll up to 20 dice with 2 to 1000 sides")
+	}
+
+	rolls := make([]string, count)
+	total := 0
+	for i := range rolls {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(sides)))
+		if err != nil {
+			return fmt.Errorf("Internal error")
+		}
+		roll := int(n.Int64()) + 1
+		total += roll
+		rolls[i] = strconv.Itoa(roll)
+	}
+
+	return cmd.post(fmt.Sprintf("Rolled %vd%v: %v (%v)", count, sides, strings.Join(rolls, ", "), total))
+}
+
+// Takes the users connection (if they are online) out of the rooms channel subscriptions
+func leaveRoomChannelSubsByUid(ctx context.Context, h handler, roomID string, uid string) error {
+	recvChan := make(chan *websocket.Conn, 1)
+	h.SocketServer.GetConnection <- socketServer.GetConnection{
+		RecvChan: recvChan,
+		Uid:      uid,
+	}
+	c := <-recvChan
+
+	close(recvChan)
+
+	if c == nil {
+		return nil
+	}
+
+	return leaveRoomChannelSubs(ctx, h, roomID, c)
+}
+
+func listRoomCommands(inData map[string]interface{}, h handler, uid string, c *websocket.Conn) error {
+	data := &socketValidation.RoomCommands{}
+	if err := UnmarshalMap(inData, data); err != nil {
+		return err
+	}
+
+	names := []string{}
+	for name := range roomCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	commands := []socketMessages.RoomCommand{}
+	for _, name := range names {
+		command := roomCommands[name]
+		args := []socketMessages.RoomCommandArg{}
+		for _, arg := range command.args {
+			args = append(args, socketMessages.RoomCommandArg{
+				Name:     arg.name,
+				Type:     arg.argType,
+				Required: arg.required,
+			})
+		}
+		commands = append(commands, socketMessages.RoomCommand{
+			Name:        name,
+			Description: command.description,
+			Args:        args,
+			Permission:  int64(command.permission),
+		})
+	}
+
+	h.SocketServer.SendDataToUser <- socketServer.UserMessageData{
+		Uid: uid,
+		Data: socketMessages.RoomCommands{
+			Commands: commands,
+		},
+		MessageType: "ROOM_COMMANDS",
+	}
+
+	return nil
+}
@@ -0,0 +1,383 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/pgtype"
+	"github.com/jackc/pgx/v5"
+	"github.com/web-stuff-98/psql-social/pkg/helpers/authHelpers"
+	"github.com/web-stuff-98/psql-social/pkg/responses"
+	"github.com/web-stuff-98/psql-social/pkg/validation"
+)
+
+/*
+	Invite codes can be shared with anyone, unlike invitations which are
+	sent to a specific user. Members with the invite permission can create
+	codes and list the rooms codes, the creator or a moderator can revoke
+	them. Expired and used up codes can't be used, and banned users are
+	refused. Resolving a code is public so that links can show a preview.
+*/
+
+const inviteCodeChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+func generateInviteCode() (string, error) {
+	code := make([]byte, 8)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(inviteCodeChars))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = inviteCodeChars[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// Returns the room of a code that can still be used
+func getUsableInviteRoom(ctx context.Context, h handler, code string) (string, *time.Time, error) {
+	var room_id string
+	var expires_at *time.Time
+	if err := h.DB.QueryRow(ctx, `
+	SELECT room_id,expires_at FROM room_invites
+	WHERE code = $1 AND (expires_at IS NULL OR expires_at > NOW()) AND (max_uses IS NULL OR uses < max_uses);
+	`, code).Scan(&room_id, &expires_at); err != nil {
+		if err != pgx.ErrNoRows {
+			return "", nil, fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return "", nil, fiber.NewError(fiber.StatusNotFound, "This invite is invalid or has expired")
+	}
+	return room_id, expires_at, nil
+}
+
+func (h handler) CreateRoomInvite(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	room_id := ctx.Params("id")
+	if room_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	v := validator.New()
+	body := &validation.CreateRoomInvite{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	if err = requireRoomPermission(rctx, h, uid, room_id, permInvite); err != nil {
+		return err
+	}
+
+	var max_uses *int
+	if body.MaxUses != 0 {
+		max_uses = &body.MaxUses
+	}
+	var expires_at *time.Time
+	if body.ExpiresIn != 0 {
+		t := time.Now().Add(time.Second * time.Duration(body.ExpiresIn))
+		expires_at = &t
+	}
+
+	code, err := generateInviteCode()
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	var id string
+	if err = h.DB.QueryRow(rctx, `
+	INSERT INTO room_invites (code,room_id,creator_id,max_uses,expires_at) VALUES($1,$2,$3,$4,$5) RETURNING id;
+	`, code, room_id, uid, max_uses, expires_at).Scan(&id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	after := make(map[string]interface{})
+	after["code"] = code
+	after["max_uses"] = max_uses
+	after["expires_at"] = formatSanctionExpiry(expires_at)
+	if err = recordAuditLog(rctx, h, room_id, uid, "INVITE_CREATE", id, nil, after); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	ctx.Response().Header.Add("Content-Type", "text/plain")
+	ctx.WriteString(code)
+	ctx.Status(fiber.StatusCreated)
+
+	return nil
+}
+
+// Lists the rooms invite codes that can still be used, newest first
+func (h handler) GetRoomInvites(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	room_id := ctx.Params("id")
+	if room_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	if err = requireRoomPermission(rctx, h, uid, room_id, permInvite); err != nil {
+		return err
+	}
+
+	rows, err := h.DB.Query(rctx, `
+	SELECT id,code,creator_id::TEXT,uses,max_uses,expires_at,created_at FROM room_invites
+	WHERE room_id = $1 AND (expires_at IS NULL OR expires_at > NOW()) AND (max_uses IS NULL OR uses < max_uses)
+	ORDER BY created_at DESC;
+	`, room_id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer rows.Close()
+
+	invites := []responses.RoomInvite{}
+	for rows.Next() {
+		var i responses.RoomInvite
+		var creator_id *string
+		var max_uses *int
+		var expires_at, created_at pgtype.Timestamptz
+		if err = rows.Scan(&i.ID, &i.Code, &creator_id, &i.Uses, &max_uses, &expires_at, &created_at); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		if creator_id != nil {
+			i.CreatorID = *creator_id
+		}
+		if max_uses != nil {
+			i.MaxUses = *max_uses
+		}
+		if expires_at.Status == pgtype.Present {
+			i.ExpiresAt = expires_at.Time.Format(time.RFC3339)
+		}
+		i.CreatedAt = created_at.Time.Format(time.RFC3339)
+		invites = append(invites, i)
+	}
+	rows.Close()
+
+	if bytes, err := json.Marshal(invites); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
+
+// Invite codes can be revoked by whoever created them, or by moderators (see permModeration)
+func (h handler) RevokeRoomInvite(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	code := ctx.Params("code")
+	if code == "" || len(code) > 10 {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	var id, room_id string
+	var creator_id *string
+	var uses int
+	if err = h.DB.QueryRow(rctx, `
+	SELECT id,room_id,creator_id::TEXT,uses FROM room_invites WHERE code = $1;
+	`, code).Scan(&id, &room_id, &creator_id, &uses); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Invite not found")
+	}
+
+	if creator_id == nil || *creator_id != uid {
+		permissions, err := getRoomPermissions(rctx, h, uid, room_id)
+		if err != nil {
+			if err != pgx.ErrNoRows {
+				return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+			}
+			return fiber.NewError(fiber.StatusNotFound, "Invite not found")
+		}
+		if permissions&permModeration == 0 {
+			return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+		}
+	}
+
+	if _, err = h.DB.Exec(rctx, `
+	DELETE FROM room_invites WHERE id = $1;
+	`, id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	before := make(map[string]interface{})
+	before["code"] = code
+	before["uses"] = uses
+	if err = recordAuditLog(rctx, h, room_id, uid, "INVITE_REVOKE", id, before, nil); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	return nil
+}
+
+// Doesn't require authentication
+func (h handler) GetInvitePreview(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	code := ctx.Params("code")
+	if code == "" || len(code) > 10 {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	room_id, expires_at, err := getUsableInviteRoom(rctx, h, code)
+	if err != nil {
+		return err
+	}
+
+	preview := responses.RoomInvitePreview{
+		Code:      code,
+		RoomID:    room_id,
+		ExpiresAt: formatSanctionExpiry(expires_at),
+	}
+	if err = h.DB.QueryRow(rctx, `
+	SELECT name,
+	EXISTS(SELECT 1 FROM room_pictures WHERE room_id = $1),
+	(SELECT COUNT(*) FROM members WHERE room_id = $1)
+	FROM rooms WHERE id = $1;
+	`, room_id).Scan(&preview.Name, &preview.HasImage, &preview.MemberCount); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if bytes, err := json.Marshal(preview); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
+
+// The rooms image, for the invite preview. Doesn't require authentication
+func (h handler) GetInviteImage(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	code := ctx.Params("code")
+	if code == "" || len(code) > 10 {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	room_id, _, err := getUsableInviteRoom(rctx, h, code)
+	if err != nil {
+		return err
+	}
+
+	var pictureData pgtype.Bytea
+	var mime string
+	if err = h.DB.QueryRow(rctx, `
+	SELECT picture_data,mime FROM room_pictures WHERE room_id = $1;
+	`, room_id).Scan(&pictureData, &mime); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Image not found")
+	}
+
+	ctx.Response().Header.Add("Content-Type", mime)
+	ctx.Response().Header.Add("Content-Length", strconv.Itoa(len(pictureData.Bytes)))
+	ctx.Write(pictureData.Bytes)
+
+	return nil
+}
+
+// Adds the user to the room and responds with the room ID. Using a code for a room
+// the user is already in doesn't count as a use
+func (h handler) JoinRoomWithInvite(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	code := ctx.Params("code")
+	if code == "" || len(code) > 10 {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	room_id, _, err := getUsableInviteRoom(rctx, h, code)
+	if err != nil {
+		return err
+	}
+
+	var banned, member bool
+	if err = h.DB.QueryRow(rctx, `
+	SELECT EXISTS(SELECT 1 FROM bans WHERE user_id = $1 AND room_id = $2),
+	EXISTS(SELECT 1 FROM members WHERE user_id = $1 AND room_id = $2) OR EXISTS(SELECT 1 FROM rooms WHERE id = $2 AND author_id = $1);
+	`, uid, room_id).Scan(&banned, &member); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if banned {
+		return fiber.NewError(fiber.StatusForbidden, "You are banned from this room")
+	}
+
+	if !member {
+		tx, err := h.DB.Begin(rctx)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		defer tx.Rollback(rctx)
+
+		// the code could have been used up since it was checked
+		tag, err := tx.Exec(rctx, `
+		UPDATE room_invites SET uses = uses + 1
+		WHERE code = $1 AND (expires_at IS NULL OR expires_at > NOW()) AND (max_uses IS NULL OR uses < max_uses);
+		`, code)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		if tag.RowsAffected() == 0 {
+			return fiber.NewError(fiber.StatusNotFound, "This invite is invalid or has expired")
+		}
+
+		if _, err = tx.Exec(rctx, `
+		INSERT INTO members (user_id,room_id) VALUES($1, $2) ON CONFLICT DO NOTHING;
+		`, uid, room_id); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+
+		if err = tx.Commit(rctx); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+
+		dispatchRoomWebhookEvent(h, room_id, "MEMBER_JOINED", map[string]interface{}{
+			"user_id":     uid,
+			"invite_code": code,
+		})
+	}
+
+	ctx.Response().Header.Add("Content-Type", "text/plain")
+	ctx.WriteString(room_id)
+
+	return nil
+}
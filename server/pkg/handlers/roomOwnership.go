@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/web-stuff-98/psql-social/pkg/helpers/authHelpers"
+	socketMessages "github.com/web-stuff-98/psql-social/pkg/socketMessages"
+	"github.com/web-stuff-98/psql-social/pkg/socketServer"
+	"github.com/web-stuff-98/psql-social/pkg/validation"
+)
+
+/*
+	The owner of a room can hand it to another member, the previous owner
+	stays in the room as a member. The owner can also choose who takes the
+	room over when their account is deleted, see passOnRooms in main.go.
+*/
+
+// Returns an error unless the user owns the room. Seeded rooms can't be modified
+func checkRoomOwner(ctx context.Context, h handler, uid string, roomID string) error {
+	var author_id string
+	var seeded bool
+	if err := h.DB.QueryRow(ctx, `
+	SELECT author_id,seeded FROM rooms WHERE id = $1;
+	`, roomID).Scan(&author_id, &seeded); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Room not found")
+	}
+	if author_id != uid {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+	if seeded {
+		return fiber.NewError(fiber.StatusBadRequest, "You cannot modify the example rooms")
+	}
+	return nil
+}
+
+// Returns an error unless the user is a member of the room who could own it. Bots can't own rooms
+func checkCanOwnRoom(ctx context.Context, h handler, uid string, roomID string) error {
+	var member, banned, bot bool
+	if err := h.DB.QueryRow(ctx, `
+	SELECT EXISTS(SELECT 1 FROM members WHERE user_id = $1 AND room_id = $2),
+	EXISTS(SELECT 1 FROM bans WHERE user_id = $1 AND room_id = $2),
+	COALESCE((SELECT bot FROM users WHERE id = $1), FALSE);
+	`, uid, roomID).Scan(&member, &banned, &bot); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if !member || banned {
+		return fiber.NewError(fiber.StatusBadRequest, "That user is not a member of this room")
+	}
+	if bot {
+		return fiber.NewError(fiber.StatusBadRequest, "Bots cannot own rooms")
+	}
+	return nil
+}
+
+func (h handler) TransferRoomOwnership(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	v := validator.New()
+	body := &validation.TransferRoomOwnership{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	room_id := ctx.Params("id")
+	if room_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	if body.Uid == uid {
+		return fiber.NewError(fiber.StatusBadRequest, "You already own this room")
+	}
+	if err = checkRoomOwner(rctx, h, uid, room_id); err != nil {
+		return err
+	}
+	if err = checkCanOwnRoom(rctx, h, body.Uid, room_id); err != nil {
+		return err
+	}
+
+	tx, err := h.DB.Begin(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer tx.Rollback(rctx)
+
+	// the owner isn't stored as a member, so the new owner and the previous owner swap places
+	if tag, err := tx.Exec(rctx, `
+	UPDATE rooms SET author_id = $1, successor_id = NULL WHERE id = $2 AND author_id = $3;
+	`, body.Uid, room_id, uid); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else if tag.RowsAffected() == 0 {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+	if _, err = tx.Exec(rctx, `
+	DELETE FROM members WHERE user_id = $1 AND room_id = $2;
+	`, body.Uid, room_id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if _, err = tx.Exec(rctx, `
+	INSERT INTO members (user_id,room_id) VALUES($1, $2) ON CONFLICT DO NOTHING;
+	`, uid, room_id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if err = tx.Commit(rctx); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	before := make(map[string]interface{})
+	before["author_id"] = uid
+	after := make(map[string]interface{})
+	after["author_id"] = body.Uid
+	if err = recordAuditLog(rctx, h, room_id, uid, "OWNER_TRANSFER", body.Uid, before, after); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	outChangeData := make(map[string]interface{})
+	outChangeData["ID"] = room_id
+	outChangeData["author_id"] = body.Uid
+
+	h.SocketServer.SendDataToSub <- socketServer.SubscriptionMessageData{
+		SubName: fmt.Sprintf("room:%v", room_id),
+		Data: socketMessages.ChangeEvent{
+			Type:   "UPDATE",
+			Entity: "ROOM",
+			Data:   outChangeData,
+		},
+		MessageType: "CHANGE",
+	}
+
+	return nil
+}
+
+// Sets what happens to the room when the owners account is deleted
+func (h handler) UpdateRoomSuccession(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	v := validator.New()
+	body := &validation.UpdateRoomSuccession{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	room_id := ctx.Params("id")
+	if room_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	if err = checkRoomOwner(rctx, h, uid, room_id); err != nil {
+		return err
+	}
+
+	var successor_id *string
+	if body.SuccessorID != "" {
+		if !body.Succession {
+			return fiber.NewError(fiber.StatusBadRequest, "Succession must be enabled to choose a successor")
+		}
+		if body.SuccessorID == uid {
+			return fiber.NewError(fiber.StatusBadRequest, "You cannot be your own successor")
+		}
+		if err = checkCanOwnRoom(rctx, h, body.SuccessorID, room_id); err != nil {
+			return err
+		}
+		successor_id = &body.SuccessorID
+	}
+
+	var prev_succession bool
+	var prev_successor_id *string
+	if err = h.DB.QueryRow(rctx, `
+	SELECT succession,successor_id::TEXT FROM rooms WHERE id = $1;
+	`, room_id).Scan(&prev_succession, &prev_successor_id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if _, err = h.DB.Exec(rctx, `
+	UPDATE rooms SET succession = $1, successor_id = $2 WHERE id = $3;
+	`, body.Succession, successor_id, room_id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	before := make(map[string]interface{})
+	before["succession"] = prev_succession
+	before["successor_id"] = prev_successor_id
+	after := make(map[string]interface{})
+	after["succession"] = body.Succession
+	after["successor_id"] = successor_id
+	if err = recordAuditLog(rctx, h, room_id, uid, "ROOM_UPDATE", room_id, before, after); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	return nil
+}
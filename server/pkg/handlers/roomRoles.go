@@ -0,0 +1,643 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/web-stuff-98/psql-social/pkg/helpers/authHelpers"
+	"github.com/web-stuff-98/psql-social/pkg/responses"
+	socketMessages "github.com/web-stuff-98/psql-social/pkg/socketMessages"
+	"github.com/web-stuff-98/psql-social/pkg/validation"
+)
+
+/*
+	Room owners can create roles and give them to users, so that they can
+	delegate moderation. A users permissions are the combined permissions
+	of their roles, the owner of the room has every permission and banned
+	users have none. Room settings, webhooks and deleting the room are
+	left to the owner.
+
+	Every permission check for a room goes through getRoomPermissions,
+	or getChannelPermissions for channels.
+*/
+
+type roomPermissions int64
+
+const (
+	// create, update, delete and reorder channels and categories, set channel topics, post in announcement channels
+	permManageChannels roomPermissions = 1 << iota
+	// ban and unban users
+	permBan
+	// kick, mute and unmute users
+	permKick
+	// delete other users messages
+	permDeleteMessages
+	// invite users to the room
+	permInvite
+	// create, update, delete and assign roles. Users can only give out permissions they have themselves
+	permManageRoles
+	// use @everyone in messages
+	permMentionEveryone
+	// see the channel and its messages, join its voice chat. Everyone in the room has this unless a channel override denies it
+	permViewChannel
+	// post messages and polls in the channel. Everyone in the room has this unless a channel override denies it
+	permSendMessages
+
+	permAll = permManageChannels | permBan | permKick | permDeleteMessages | permInvite | permManageRoles | permMentionEveryone | permViewChannel | permSendMessages
+	// users with any of these permissions are moderators, and can read the rooms audit log
+	permModeration = permManageChannels | permBan | permKick | permDeleteMessages | permManageRoles
+)
+
+func (p roomPermissions) has(perm roomPermissions) bool {
+	return p&perm == perm
+}
+
+// Returns the users permissions in the room. Returns pgx.ErrNoRows if the room doesn't exist
+func getRoomPermissions(ctx context.Context, h handler, uid string, roomID string) (roomPermissions, error) {
+	var author_id string
+	var banned bool
+	if err := h.DB.QueryRow(ctx, `
+	SELECT author_id,EXISTS(SELECT 1 FROM bans WHERE user_id = $1 AND room_id = $2) FROM rooms WHERE id = $2;
+	`, uid, roomID).Scan(&author_id, &banned); err != nil {
+		return 0, err
+	}
+	if author_id == uid {
+		return permAll, nil
+	}
+	if banned {
+		return 0, nil
+	}
+
+	var permissions int64
+	if err := h.DB.QueryRow(ctx, `
+	SELECT COALESCE(BIT_OR(room_roles.permissions), 0) FROM member_roles
+	INNER JOIN room_roles ON room_roles.id = member_roles.role_id
+	WHERE member_roles.user_id = $1 AND member_roles.room_id = $2;
+	`, uid, roomID).Scan(&permissions); err != nil {
+		return 0, err
+	}
+
+	return roomPermissions(permissions) | permViewChannel | permSendMessages, nil
+}
+
+// Returns an error if the user doesn't have the permission, for socket event handlers
+func checkRoomPermission(ctx context.Context, h handler, uid string, roomID string, perm roomPermissions) error {
+	permissions, err := getRoomPermissions(ctx, h, uid, roomID)
+	if err != nil {
+		if err != pgx.ErrNoRows {
+			return fmt.Errorf("Internal error")
+		}
+		return fmt.Errorf("Room not found")
+	}
+	if !permissions.has(perm) {
+		return fmt.Errorf("You do not have permission to do that")
+	}
+	return nil
+}
+
+// Same as checkRoomPermission, for http handlers
+func requireRoomPermission(ctx context.Context, h handler, uid string, roomID string, perm roomPermissions) error {
+	permissions, err := getRoomPermissions(ctx, h, uid, roomID)
+	if err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Room not found")
+	}
+	if !permissions.has(perm) {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+	return nil
+}
+
+// Returns an error if the user is banned from the room, or isn't a member of a private room
+func checkRoomReadAccess(ctx context.Context, h handler, uid string, roomID string) error {
+	var private, banned, member bool
+	var author_id string
+	if err := h.DB.QueryRow(ctx, `
+	SELECT private,author_id,
+	EXISTS(SELECT 1 FROM bans WHERE user_id = $1 AND room_id = $2),
+	EXISTS(SELECT 1 FROM members WHERE user_id = $1 AND room_id = $2)
+	FROM rooms WHERE id = $2;
+	`, uid, roomID).Scan(&private, &author_id, &banned, &member); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Room not found")
+	}
+	if banned {
+		return fiber.NewError(fiber.StatusForbidden, "You are banned from this room")
+	}
+	if private && !member && author_id != uid {
+		return fiber.NewError(fiber.StatusForbidden, "You are not a member of this room")
+	}
+	return nil
+}
+
+// Checks the user can manage roles in the room, and returns their permissions.
+// Seeded rooms can't be modified
+func checkCanManageRoles(ctx context.Context, h handler, uid string, roomID string) (roomPermissions, error) {
+	var seeded bool
+	if err := h.DB.QueryRow(ctx, `
+	SELECT seeded FROM rooms WHERE id = $1;
+	`, roomID).Scan(&seeded); err != nil {
+		if err != pgx.ErrNoRows {
+			return 0, fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return 0, fiber.NewError(fiber.StatusNotFound, "Room not found")
+	}
+	if seeded {
+		return 0, fiber.NewError(fiber.StatusBadRequest, "You cannot modify the example rooms")
+	}
+
+	permissions, err := getRoomPermissions(ctx, h, uid, roomID)
+	if err != nil {
+		return 0, fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if !permissions.has(permManageRoles) {
+		return 0, fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	return permissions, nil
+}
+
+// Sends the users roles and permissions to the room
+func sendMemberRolesChange(ctx context.Context, h handler, uid string, roomID string) error {
+	rows, err := h.DB.Query(ctx, `
+	SELECT role_id FROM member_roles WHERE user_id = $1 AND room_id = $2;
+	`, uid, roomID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return err
+		}
+		roles = append(roles, id)
+	}
+	rows.Close()
+
+	permissions, err := getRoomPermissions(ctx, h, uid, roomID)
+	if err != nil {
+		return err
+	}
+
+	changeData := make(map[string]interface{})
+	changeData["ID"] = uid
+	changeData["room_id"] = roomID
+	changeData["roles"] = roles
+	changeData["permissions"] = permissions
+	return sendRoomChannelsChange(ctx, h, roomID, socketMessages.ChangeEvent{
+		Type:   "UPDATE",
+		Entity: "MEMBER",
+		Data:   changeData,
+	})
+}
+
+func (h handler) CreateRoomRole(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	v := validator.New()
+	body := &validation.CreateUpdateRoomRole{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	room_id := ctx.Params("id")
+	if room_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	permissions, err := checkCanManageRoles(rctx, h, uid, room_id)
+	if err != nil {
+		return err
+	}
+	if !permissions.has(roomPermissions(body.Permissions)) {
+		return fiber.NewError(fiber.StatusBadRequest, "You cannot give out permissions you don't have")
+	}
+
+	filtered, allowed, err := filterName(rctx, h, uid, "ROLE_NAME", "", name)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if !allowed {
+		return fiber.NewError(fiber.StatusBadRequest, "That role name is not allowed")
+	}
+
+	var id string
+	if err = h.DB.QueryRow(rctx, `
+	INSERT INTO room_roles (room_id,name,permissions) VALUES($1,$2,$3) RETURNING id;
+	`, room_id, name, body.Permissions).Scan(&id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if err = recordFilterMatches(rctx, h, uid, "ROLE_NAME", id, "", name, filtered); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	after := make(map[string]interface{})
+	after["name"] = name
+	after["permissions"] = body.Permissions
+	if err = recordAuditLog(rctx, h, room_id, uid, "ROLE_CREATE", id, nil, after); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	changeData := make(map[string]interface{})
+	changeData["ID"] = id
+	changeData["room_id"] = room_id
+	changeData["name"] = name
+	changeData["permissions"] = body.Permissions
+	if err = sendRoomChannelsChange(rctx, h, room_id, socketMessages.ChangeEvent{
+		Type:   "INSERT",
+		Entity: "ROLE",
+		Data:   changeData,
+	}); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	ctx.Response().Header.Add("Content-Type", "text/plain")
+	ctx.WriteString(id)
+	ctx.Status(fiber.StatusCreated)
+
+	return nil
+}
+
+func (h handler) GetRoomRoles(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	room_id := ctx.Params("id")
+	if room_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	if err = checkRoomReadAccess(rctx, h, uid, room_id); err != nil {
+		return err
+	}
+
+	rows, err := h.DB.Query(rctx, `
+	SELECT id,name,permissions FROM room_roles WHERE room_id = $1 ORDER BY created_at;
+	`, room_id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer rows.Close()
+
+	roles := []responses.RoomRole{}
+	for rows.Next() {
+		var r responses.RoomRole
+		if err = rows.Scan(&r.ID, &r.Name, &r.Permissions); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		roles = append(roles, r)
+	}
+	rows.Close()
+
+	if bytes, err := json.Marshal(roles); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
+
+func (h handler) UpdateRoomRole(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	v := validator.New()
+	body := &validation.CreateUpdateRoomRole{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	id := ctx.Params("id")
+	if id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	var room_id, old_name string
+	var old_permissions int64
+	if err = h.DB.QueryRow(rctx, `
+	SELECT room_id,name,permissions FROM room_roles WHERE id = $1;
+	`, id).Scan(&room_id, &old_name, &old_permissions); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Role not found")
+	}
+
+	permissions, err := checkCanManageRoles(rctx, h, uid, room_id)
+	if err != nil {
+		return err
+	}
+	// permissions the user doesn't have can't be added or taken away
+	if !permissions.has(roomPermissions(body.Permissions ^ old_permissions)) {
+		return fiber.NewError(fiber.StatusBadRequest, "You cannot change permissions you don't have")
+	}
+
+	filtered, allowed, err := filterName(rctx, h, uid, "ROLE_NAME", id, name)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if !allowed {
+		return fiber.NewError(fiber.StatusBadRequest, "That role name is not allowed")
+	}
+
+	if _, err = h.DB.Exec(rctx, `
+	UPDATE room_roles SET name = $1, permissions = $2 WHERE id = $3;
+	`, name, body.Permissions, id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if err = recordFilterMatches(rctx, h, uid, "ROLE_NAME", id, "", name, filtered); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	before := make(map[string]interface{})
+	before["name"] = old_name
+	before["permissions"] = old_permissions
+	after := make(map[string]interface{})
+	after["name"] = name
+	after["permissions"] = body.Permissions
+	if err = recordAuditLog(rctx, h, room_id, uid, "ROLE_UPDATE", id, before, after); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if err = removeHiddenRoomSubs(rctx, h, room_id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	changeData := make(map[string]interface{})
+	changeData["ID"] = id
+	changeData["name"] = name
+	changeData["permissions"] = body.Permissions
+	if err = sendRoomChannelsChange(rctx, h, room_id, socketMessages.ChangeEvent{
+		Type:   "UPDATE",
+		Entity: "ROLE",
+		Data:   changeData,
+	}); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	return nil
+}
+
+func (h handler) DeleteRoomRole(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	id := ctx.Params("id")
+	if id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	var room_id, role_name string
+	var role_permissions int64
+	if err = h.DB.QueryRow(rctx, `
+	SELECT room_id,name,permissions FROM room_roles WHERE id = $1;
+	`, id).Scan(&room_id, &role_name, &role_permissions); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Role not found")
+	}
+
+	permissions, err := checkCanManageRoles(rctx, h, uid, room_id)
+	if err != nil {
+		return err
+	}
+	if !permissions.has(roomPermissions(role_permissions)) {
+		return fiber.NewError(fiber.StatusBadRequest, "You cannot delete a role with permissions you don't have")
+	}
+
+	if _, err = h.DB.Exec(rctx, `
+	DELETE FROM room_roles WHERE id = $1;
+	`, id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	before := make(map[string]interface{})
+	before["name"] = role_name
+	before["permissions"] = role_permissions
+	if err = recordAuditLog(rctx, h, room_id, uid, "ROLE_DELETE", id, before, nil); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if err = removeHiddenRoomSubs(rctx, h, room_id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	changeData := make(map[string]interface{})
+	changeData["ID"] = id
+	if err = sendRoomChannelsChange(rctx, h, room_id, socketMessages.ChangeEvent{
+		Type:   "DELETE",
+		Entity: "ROLE",
+		Data:   changeData,
+	}); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	return nil
+}
+
+// Gives the user a role, or takes it away if the request method is DELETE
+func (h handler) AssignRoomRole(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	room_id := ctx.Params("id")
+	user_id := ctx.Params("uid")
+	role_id := ctx.Params("role")
+	if room_id == "" || user_id == "" || role_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	permissions, err := checkCanManageRoles(rctx, h, uid, room_id)
+	if err != nil {
+		return err
+	}
+
+	var role_permissions int64
+	if err = h.DB.QueryRow(rctx, `
+	SELECT permissions FROM room_roles WHERE id = $1 AND room_id = $2;
+	`, role_id, room_id).Scan(&role_permissions); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Role not found")
+	}
+	if !permissions.has(roomPermissions(role_permissions)) {
+		return fiber.NewError(fiber.StatusBadRequest, "You cannot assign a role with permissions you don't have")
+	}
+
+	var exists, banned bool
+	if err = h.DB.QueryRow(rctx, `
+	SELECT EXISTS(SELECT 1 FROM users WHERE id = $1),
+	EXISTS(SELECT 1 FROM bans WHERE user_id = $1 AND room_id = $2);
+	`, user_id, room_id).Scan(&exists, &banned); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if !exists {
+		return fiber.NewError(fiber.StatusNotFound, "User not found")
+	}
+
+	roleData := make(map[string]interface{})
+	roleData["role_id"] = role_id
+	if ctx.Method() == fiber.MethodDelete {
+		if _, err = h.DB.Exec(rctx, `
+		DELETE FROM member_roles WHERE user_id = $1 AND role_id = $2;
+		`, user_id, role_id); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		if err = recordAuditLog(rctx, h, room_id, uid, "ROLE_UNASSIGN", user_id, roleData, nil); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+	} else {
+		if banned {
+			return fiber.NewError(fiber.StatusBadRequest, "This user is banned from the room")
+		}
+		if _, err = h.DB.Exec(rctx, `
+		INSERT INTO member_roles (user_id,room_id,role_id) VALUES($1,$2,$3) ON CONFLICT DO NOTHING;
+		`, user_id, room_id, role_id); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		if err = recordAuditLog(rctx, h, room_id, uid, "ROLE_ASSIGN", user_id, nil, roleData); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+	}
+
+	if err = removeHiddenRoomSubs(rctx, h, room_id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if err = sendMemberRolesChange(rctx, h, user_id, room_id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	return nil
+}
+
+// Lists the owner, the members of the room and users who have been given roles
+func (h handler) GetRoomMembers(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	room_id := ctx.Params("id")
+	if room_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	if err = checkRoomReadAccess(rctx, h, uid, room_id); err != nil {
+		return err
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	selectStmt, err := conn.Conn().Prepare(rctx, "get_room_members_select_stmt", `
+	SELECT users.id, users.id = rooms.author_id,
+	COALESCE(ARRAY_AGG(member_roles.role_id::TEXT) FILTER (WHERE member_roles.role_id IS NOT NULL), '{}'),
+	COALESCE(BIT_OR(room_roles.permissions), 0)
+	FROM rooms
+	INNER JOIN users ON users.id = rooms.author_id
+	OR users.id IN (SELECT user_id FROM members WHERE room_id = rooms.id)
+	OR users.id IN (SELECT user_id FROM member_roles WHERE room_id = rooms.id)
+	LEFT JOIN member_roles ON member_roles.user_id = users.id AND member_roles.room_id = rooms.id
+	LEFT JOIN room_roles ON room_roles.id = member_roles.role_id
+	WHERE rooms.id = $1
+	GROUP BY users.id, rooms.author_id
+	ORDER BY users.id = rooms.author_id DESC, users.username;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	rows, err := conn.Query(rctx, selectStmt.Name, room_id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer rows.Close()
+
+	members := []responses.RoomMember{}
+	for rows.Next() {
+		var m responses.RoomMember
+		if err = rows.Scan(&m.ID, &m.Owner, &m.Roles, &m.Permissions); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		if m.Owner {
+			m.Permissions = int64(permAll)
+		}
+		members = append(members, m)
+	}
+	rows.Close()
+
+	if bytes, err := json.Marshal(members); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
@@ -0,0 +1,320 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/pgtype"
+	"github.com/jackc/pgx/v5"
+	"github.com/web-stuff-98/psql-social/pkg/helpers/authHelpers"
+	"github.com/web-stuff-98/psql-social/pkg/responses"
+	socketMessages "github.com/web-stuff-98/psql-social/pkg/socketMessages"
+	"github.com/web-stuff-98/psql-social/pkg/socketServer"
+	"github.com/web-stuff-98/psql-social/pkg/validation"
+)
+
+/*
+	Room owners can set rules and up to 5 questions. Members have to accept
+	the current version of the rules, answering every question, before they
+	can post messages, join channels or join voice chat (see checkAcceptedRules).
+	Changing the rules or questions makes everyone accept them again. The
+	owner can read the answers.
+*/
+
+const rulesAcceptancesPageSize = 30
+
+// Returns an error unless the room has no rules, the user owns the room, or the user has accepted the current rules
+func checkAcceptedRules(ctx context.Context, h handler, uid string, roomID string) error {
+	var accepted bool
+	if err := h.DB.QueryRow(ctx, `
+	SELECT author_id = $1
+	OR NOT EXISTS(SELECT 1 FROM room_rules WHERE room_id = $2 AND (rules <> '' OR CARDINALITY(questions) > 0))
+	OR EXISTS(SELECT 1 FROM rules_acceptances INNER JOIN room_rules ON room_rules.room_id = rules_acceptances.room_id
+		WHERE rules_acceptances.room_id = $2 AND user_id = $1 AND rules_acceptances.version = room_rules.version)
+	FROM rooms WHERE id = $2;
+	`, uid, roomID).Scan(&accepted); err != nil {
+		if err != pgx.ErrNoRows {
+			return fmt.Errorf("Internal error")
+		}
+		return fmt.Errorf("Room not found")
+	}
+	if !accepted {
+		return fmt.Errorf("You must accept the rooms rules first")
+	}
+	return nil
+}
+
+// Returns the rules, questions and version. Version is 0 if the room has never had rules
+func getRoomRules(ctx context.Context, h handler, roomID string) (string, []string, int, error) {
+	rules, questions, version := "", []string{}, 0
+	if err := h.DB.QueryRow(ctx, `
+	SELECT rules,questions,version FROM room_rules WHERE room_id = $1;
+	`, roomID).Scan(&rules, &questions, &version); err != nil && err != pgx.ErrNoRows {
+		return "", nil, 0, err
+	}
+	return rules, questions, version, nil
+}
+
+func (h handler) GetRoomRules(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	room_id := ctx.Params("id")
+	if room_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	if err = checkRoomReadAccess(rctx, h, uid, room_id); err != nil {
+		return err
+	}
+
+	rules, questions, version, err := getRoomRules(rctx, h, room_id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	out := responses.RoomRules{
+		Rules:     rules,
+		Questions: questions,
+		Version:   version,
+		Accepted:  checkAcceptedRules(rctx, h, uid, room_id) == nil,
+	}
+
+	if bytes, err := json.Marshal(out); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
+
+func (h handler) UpdateRoomRules(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	v := validator.New()
+	body := &validation.UpdateRoomRules{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	room_id := ctx.Params("id")
+	if room_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	if err = checkRoomOwner(rctx, h, uid, room_id); err != nil {
+		return err
+	}
+
+	rules := strings.TrimSpace(body.Rules)
+	questions := []string{}
+	for _, q := range body.Questions {
+		q = strings.TrimSpace(q)
+		if q == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "Questions cannot be empty")
+		}
+		questions = append(questions, q)
+	}
+
+	prev_rules, prev_questions, _, err := getRoomRules(rctx, h, room_id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if prev_rules == rules && strings.Join(prev_questions, "\n") == strings.Join(questions, "\n") {
+		return nil
+	}
+
+	var version int
+	if err = h.DB.QueryRow(rctx, `
+	INSERT INTO room_rules (room_id,rules,questions) VALUES($1,$2,$3)
+	ON CONFLICT (room_id) DO UPDATE SET rules = EXCLUDED.rules, questions = EXCLUDED.questions,
+	version = room_rules.version + 1, updated_at = NOW()
+	RETURNING version;
+	`, room_id, rules, questions).Scan(&version); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	before := make(map[string]interface{})
+	before["rules"] = prev_rules
+	before["questions"] = prev_questions
+	after := make(map[string]interface{})
+	after["rules"] = rules
+	after["questions"] = questions
+	if err = recordAuditLog(rctx, h, room_id, uid, "ROOM_UPDATE", room_id, before, after); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	// members fetch the new rules when the version changes
+	outChangeData := make(map[string]interface{})
+	outChangeData["ID"] = room_id
+	outChangeData["rules_version"] = version
+
+	h.SocketServer.SendDataToSub <- socketServer.SubscriptionMessageData{
+		SubName: fmt.Sprintf("room:%v", room_id),
+		Data: socketMessages.ChangeEvent{
+			Type:   "UPDATE",
+			Entity: "ROOM",
+			Data:   outChangeData,
+		},
+		MessageType: "CHANGE",
+	}
+
+	return nil
+}
+
+func (h handler) AcceptRoomRules(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	v := validator.New()
+	body := &validation.AcceptRoomRules{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	room_id := ctx.Params("id")
+	if room_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	if err = checkRoomReadAccess(rctx, h, uid, room_id); err != nil {
+		return err
+	}
+
+	_, questions, version, err := getRoomRules(rctx, h, room_id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	// the rules could have changed since the user read them
+	if body.Version != version {
+		return fiber.NewError(fiber.StatusConflict, "The rules have changed, read them again")
+	}
+
+	if len(body.Answers) != len(questions) {
+		return fiber.NewError(fiber.StatusBadRequest, "Every question must be answered")
+	}
+	answers := []string{}
+	for _, a := range body.Answers {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "Every question must be answered")
+		}
+		answers = append(answers, a)
+	}
+
+	if _, err = h.DB.Exec(rctx, `
+	INSERT INTO rules_acceptances (room_id,user_id,version,questions,answers) VALUES($1,$2,$3,$4,$5)
+	ON CONFLICT (room_id,user_id) DO UPDATE SET version = EXCLUDED.version, questions = EXCLUDED.questions,
+	answers = EXCLUDED.answers, accepted_at = NOW();
+	`, room_id, uid, version, questions, answers); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	return nil
+}
+
+// Lists the answers members gave when they accepted the rules, newest first. Only for the room owner
+func (h handler) GetRulesAcceptances(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	room_id := ctx.Params("id")
+	if room_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	page := ctx.QueryInt("page", 1)
+	if page < 1 {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	offset := (page - 1) * rulesAcceptancesPageSize
+
+	var author_id string
+	if err = h.DB.QueryRow(rctx, `
+	SELECT author_id FROM rooms WHERE id = $1;
+	`, room_id).Scan(&author_id); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Room not found")
+	}
+	if author_id != uid {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	rows, err := h.DB.Query(rctx, `
+	SELECT user_id,version,questions,answers,accepted_at FROM rules_acceptances
+	WHERE room_id = $1
+	ORDER BY accepted_at DESC
+	LIMIT $2 OFFSET $3;
+	`, room_id, rulesAcceptancesPageSize, offset)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer rows.Close()
+
+	acceptances := []responses.RulesAcceptance{}
+	for rows.Next() {
+		var a responses.RulesAcceptance
+		var accepted_at pgtype.Timestamptz
+		if err = rows.Scan(&a.UserID, &a.Version, &a.Questions, &a.Answers, &accepted_at); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		a.AcceptedAt = accepted_at.Time.Format(time.RFC3339)
+		acceptances = append(acceptances, a)
+	}
+	rows.Close()
+
+	var count int
+	if err = h.DB.QueryRow(rctx, `
+	SELECT COUNT(*) FROM rules_acceptances WHERE room_id = $1;
+	`, room_id).Scan(&count); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if bytes, err := json.Marshal(responses.RulesAcceptancesPage{
+		Acceptances: acceptances,
+		Count:       count,
+	}); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
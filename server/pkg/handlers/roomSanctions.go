@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/pgtype"
+	"github.com/jackc/pgx/v5"
+	channelRTCserver "github.com/web-stuff-98/psql-social/pkg/channelRTCserver"
+	"github.com/web-stuff-98/psql-social/pkg/helpers/authHelpers"
+	"github.com/web-stuff-98/psql-social/pkg/responses"
+	socketMessages "github.com/web-stuff-98/psql-social/pkg/socketMessages"
+	"github.com/web-stuff-98/psql-social/pkg/socketServer"
+)
+
+/*
+	Bans and mutes can be permanent or last for a duration. Expired
+	sanctions are deleted by liftExpiredSanctions in main.go, which
+	sends out the same UNBAN event as lifting them manually.
+*/
+
+const maxSanctionDuration = time.Hour * 24 * 365
+
+// Parses durations written like 30m, 12h or 7d. "perm" is a permanent sanction (0).
+func parseSanctionDuration(s string) (time.Duration, error) {
+	s = strings.ToLower(s)
+	if s == "perm" {
+		return 0, nil
+	}
+
+	invalid := fmt.Errorf("Durations should be written like 30m, 12h or 7d, or perm")
+	if len(s) < 2 {
+		return 0, invalid
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n < 1 {
+		return 0, invalid
+	}
+
+	var unit time.Duration
+	switch s[len(s)-1] {
+	case 'm':
+		unit = time.Minute
+	case 'h':
+		unit = time.Hour
+	case 'd':
+		unit = time.Hour * 24
+	default:
+		return 0, invalid
+	}
+
+	if time.Duration(n) > maxSanctionDuration/unit {
+		return 0, fmt.Errorf("Sanctions can last up to 365 days")
+	}
+
+	return time.Duration(n) * unit, nil
+}
+
+// Returns nil for permanent sanctions
+func sanctionExpiry(duration time.Duration) *time.Time {
+	if duration == 0 {
+		return nil
+	}
+	expiresAt := time.Now().Add(duration)
+	return &expiresAt
+}
+
+func formatSanctionExpiry(expiresAt *time.Time) string {
+	if expiresAt == nil {
+		return ""
+	}
+	return expiresAt.Format(time.RFC3339)
+}
+
+// Mutes the user and takes them out of the rooms voice chats. Muting a user who is already muted replaces
+// the reason and duration of their mute
+func muteRoomMember(ctx context.Context, h handler, uid string, roomID string, issuerID string, reason string, duration time.Duration) error {
+	var before map[string]interface{}
+	var prevReason string
+	var prevExpiresAt *time.Time
+	if err := h.DB.QueryRow(ctx, `
+	SELECT reason,expires_at FROM mutes WHERE user_id = $1 AND room_id = $2;
+	`, uid, roomID).Scan(&prevReason, &prevExpiresAt); err != nil {
+		if err != pgx.ErrNoRows {
+			return fmt.Errorf("Internal error")
+		}
+	} else {
+		before = make(map[string]interface{})
+		before["reason"] = prevReason
+		before["expires_at"] = formatSanctionExpiry(prevExpiresAt)
+	}
+
+	expiresAt := sanctionExpiry(duration)
+	if _, err := h.DB.Exec(ctx, `
+	INSERT INTO mutes (user_id, room_id, issuer_id, reason, expires_at) VALUES($1, $2, $3, $4, $5)
+	ON CONFLICT (user_id, room_id) DO UPDATE SET issuer_id = $3, reason = $4, expires_at = $5, created_at = NOW();
+	`, uid, roomID, issuerID, reason, expiresAt); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	after := make(map[string]interface{})
+	after["reason"] = reason
+	after["expires_at"] = formatSanctionExpiry(expiresAt)
+	if err := recordAuditLog(ctx, h, roomID, issuerID, "MUTE", uid, before, after); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	rows, err := h.DB.Query(ctx, `
+	SELECT id FROM room_channels WHERE room_id = $1;
+	`, roomID)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return fmt.Errorf("Internal error")
+		}
+		// does nothing if the user isn't in the channels voice chat
+		h.ChannelRTCServer.LeaveChannelRTC <- channelRTCserver.LeaveChannel{
+			Uid:       uid,
+			ChannelID: id,
+		}
+	}
+
+	return nil
+}
+
+// Returns false if the user wasn't muted
+func unmuteRoomMember(ctx context.Context, h handler, uid string, roomID string, issuerID string) (bool, error) {
+	var reason string
+	var expiresAt *time.Time
+	if err := h.DB.QueryRow(ctx, `
+	DELETE FROM mutes WHERE user_id = $1 AND room_id = $2 RETURNING reason,expires_at;
+	`, uid, roomID).Scan(&reason, &expiresAt); err != nil {
+		if err != pgx.ErrNoRows {
+			return false, fmt.Errorf("Internal error")
+		}
+		return false, nil
+	}
+
+	before := make(map[string]interface{})
+	before["reason"] = reason
+	before["expires_at"] = formatSanctionExpiry(expiresAt)
+	if err := recordAuditLog(ctx, h, roomID, issuerID, "UNMUTE", uid, before, nil); err != nil {
+		return false, fmt.Errorf("Internal error")
+	}
+
+	return true, sendUnban(ctx, h, uid, roomID, "MUTE")
+}
+
+// Tells everyone in the rooms channels, and the user, that a ban or mute was lifted
+func sendUnban(ctx context.Context, h handler, uid string, roomID string, kind string) error {
+	rows, err := h.DB.Query(ctx, `
+	SELECT id FROM room_channels WHERE room_id = $1;
+	`, roomID)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	defer rows.Close()
+
+	channel_sub_names := []string{}
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return fmt.Errorf("Internal error")
+		}
+		channel_sub_names = append(channel_sub_names, fmt.Sprintf("channel:%v", id))
+	}
+
+	data := socketMessages.Unban{
+		UserID: uid,
+		RoomID: roomID,
+		Kind:   kind,
+	}
+	h.SocketServer.SendDataToSubs <- socketServer.SubscriptionsMessageData{
+		SubNames:    channel_sub_names,
+		Data:        data,
+		MessageType: "UNBAN",
+	}
+	h.SocketServer.SendDataToUser <- socketServer.UserMessageData{
+		Uid:         uid,
+		Data:        data,
+		MessageType: "UNBAN",
+	}
+
+	return nil
+}
+
+// Lists the active bans and mutes in a room, for users who can ban, kick or mute
+func (h handler) GetRoomSanctions(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	room_id := ctx.Params("id")
+	if room_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	permissions, err := getRoomPermissions(rctx, h, uid, room_id)
+	if err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Room not found")
+	}
+	if !permissions.has(permBan) && !permissions.has(permKick) {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	selectStmt, err := conn.Conn().Prepare(rctx, "get_room_sanctions_select_stmt", `
+	SELECT user_id,'BAN',reason,issuer_id,created_at,expires_at FROM bans
+	WHERE room_id = $1 AND (expires_at IS NULL OR expires_at > NOW())
+	UNION ALL
+	SELECT user_id,'MUTE',reason,issuer_id,created_at,expires_at FROM mutes
+	WHERE room_id = $1 AND (expires_at IS NULL OR expires_at > NOW())
+	ORDER BY created_at DESC;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	rows, err := conn.Query(rctx, selectStmt.Name, room_id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer rows.Close()
+
+	sanctions := []responses.RoomSanction{}
+	for rows.Next() {
+		var s responses.RoomSanction
+		var issuer_id *string
+		var created_at, expires_at pgtype.Timestamptz
+		if err = rows.Scan(&s.UserID, &s.Kind, &s.Reason, &issuer_id, &created_at, &expires_at); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		if issuer_id != nil {
+			s.IssuerID = *issuer_id
+		}
+		s.CreatedAt = created_at.Time.Format(time.RFC3339)
+		if expires_at.Status == pgtype.Present {
+			s.ExpiresAt = expires_at.Time.Format(time.RFC3339)
+		}
+		sanctions = append(sanctions, s)
+	}
+	rows.Close()
+
+	if bytes, err := json.Marshal(sanctions); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
@@ -59,9 +59,12 @@ func (h handler) WebSocketHandler() func(*fiber.Ctx) error {
 func (h handler) WebSocketAuth(ctx *fiber.Ctx) error {
 	if uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, context.Background(), h.DB); err != nil {
 		return fiber.ErrForbidden
+	} else if suspended, err := authHelpers.IsSuspended(context.Background(), h.DB, uid); err != nil || suspended {
+		return fiber.ErrForbidden
 	} else {
 		if websocket.IsWebSocketUpgrade(ctx) {
 			ctx.Locals("uid", uid)
+			ctx.Locals("bot", authHelpers.GetBotToken(ctx) != "")
 			ctx.Locals("open_convs", make(map[string]struct{}))
 			return ctx.Next()
 		}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math"
 	"strings"
 	"time"
 
@@ -28,6 +29,7 @@ func handleSocketEvent(data map[string]interface{}, event string, h handler, uid
 		RecvChan: recvChan,
 		Type:     event,
 		Conn:     c,
+		Bot:      c.Locals("bot") == true,
 	}
 	err = <-recvChan
 
@@ -60,6 +62,24 @@ func handleSocketEvent(data map[string]interface{}, event string, h handler, uid
 		err = directMessageUpdate(data, h, uid, c)
 	case "DIRECT_MESSAGE_DELETE":
 		err = directMessageDelete(data, h, uid, c)
+
+	case "GROUP_OPENED":
+		err = groupOpened(data, h, uid, c)
+	case "GROUP_CLOSED":
+		err = groupClosed(data, h, uid, c)
+	case "GROUP_MESSAGE":
+		err = groupMessage(data, h, uid, c)
+	case "GROUP_MESSAGE_UPDATE":
+		err = groupMessageUpdate(data, h, uid, c)
+	case "GROUP_MESSAGE_DELETE":
+		err = groupMessageDelete(data, h, uid, c)
+	case "GROUP_ADD_MEMBER":
+		err = groupAddMember(data, h, uid, c)
+	case "GROUP_REMOVE_MEMBER":
+		err = groupRemoveMember(data, h, uid, c)
+	case "GROUP_LEAVE":
+		err = groupLeave(data, h, uid, c)
+
 	case "CONV_OPENED":
 		err = convOpened(data, h, uid, c)
 	case "CONV_CLOSED":
@@ -74,6 +94,8 @@ func handleSocketEvent(data map[string]interface{}, event string, h handler, uid
 		err = invitation(data, h, uid, c)
 	case "INVITATION_RESPONSE":
 		err = invitationResponse(data, h, uid, c)
+	case "INVITATION_CANCEL":
+		err = invitationCancel(data, h, uid, c)
 
 	case "START_WATCHING":
 		err = startWatching(data, h, uid, c)
@@ -89,6 +111,17 @@ func handleSocketEvent(data map[string]interface{}, event string, h handler, uid
 		err = ban(data, h, uid, c)
 	case "UNBAN":
 		err = unban(data, h, uid, c)
+	case "KICK":
+		err = kick(data, h, uid, c)
+
+	case "ROOM_COMMANDS":
+		err = listRoomCommands(data, h, uid, c)
+	case "ROOM_POLL":
+		err = roomPoll(data, h, uid, c)
+	case "POLL_VOTE":
+		err = pollVote(data, h, uid, c)
+	case "POLL_RETRACT":
+		err = pollRetract(data, h, uid, c)
 
 	case "CALL_USER":
 		err = callUser(data, h, uid, c)
@@ -149,66 +182,15 @@ func joinRoom(inData map[string]interface{}, h handler, uid string, c *websocket
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
 	defer cancel()
 
-	conn, err := h.DB.Acquire(ctx)
-	if err != nil {
-		return fmt.Errorf("Internal error")
-	}
-	defer conn.Release()
-
-	selectRoomExistsStmt, err := conn.Conn().Prepare(ctx, "join_room_select_room_exists_stmt", `
-	SELECT EXISTS(SELECT 1 FROM rooms WHERE id = $1);
-	`)
-	if err != nil {
-		return fmt.Errorf("Internal error")
-	}
-
-	roomExists := false
-	if err = conn.QueryRow(ctx, selectRoomExistsStmt.Name, data.RoomID).Scan(&roomExists); err != nil {
-		return fmt.Errorf("Internal error")
-	}
-	if !roomExists {
-		return fmt.Errorf("Room not found")
-	}
-
-	banExists := false
-	if err = h.DB.QueryRow(ctx, `
-	SELECT EXISTS(SELECT 1 FROM bans WHERE user_id = $1);
-	`, uid).Scan(&banExists); err != nil {
-		return fmt.Errorf("Internal error")
-	}
-	if banExists {
-		return fmt.Errorf("You are banned from this room")
+	if err = checkRoomReadAccess(ctx, h, uid, data.RoomID); err != nil {
+		return err
 	}
 
-	selectRoomStmt, err := conn.Conn().Prepare(ctx, "join_room_select_room_stmt", `
-	SELECT private,author_id FROM rooms WHERE id = $1;
-	`)
+	conn, err := h.DB.Acquire(ctx)
 	if err != nil {
 		return fmt.Errorf("Internal error")
 	}
-
-	var private bool
-	var author_id string
-	if err = conn.QueryRow(ctx, selectRoomStmt.Name, data.RoomID).Scan(&private, &author_id); err != nil {
-		return fmt.Errorf("Internal error")
-	}
-
-	if private && author_id != uid {
-		membershipExistsStmt, err := conn.Conn().Prepare(ctx, "join_room_select_room_membership_stmt", `
-		SELECT EXISTS(SELECT 1 FROM members WHERE user_id = $1);
-		`)
-		if err != nil {
-			return fmt.Errorf("Internal error")
-		}
-
-		membershipExists := false
-		if err = conn.QueryRow(ctx, membershipExistsStmt.Name, uid).Scan(&membershipExists); err != nil {
-			return fmt.Errorf("Internal error")
-		}
-		if !membershipExists {
-			return fmt.Errorf("You are not a member of this room")
-		}
-	}
+	defer conn.Release()
 
 	selectChannelStmt, err := conn.Conn().Prepare(ctx, "join_room_select_channel_stmt", `
 	SELECT id,name FROM room_channels WHERE room_id = $1 AND main = TRUE;
@@ -226,6 +208,10 @@ func joinRoom(inData map[string]interface{}, h handler, uid string, c *websocket
 		}
 	}
 
+	if err = checkCanViewChannel(ctx, h, uid, mainChannelId); err != nil {
+		return err
+	}
+
 	h.SocketServer.JoinSubscriptionByWs <- socketServer.RegisterUnregisterSubsConnWs{
 		Conn:    c,
 		SubName: fmt.Sprintf("channel:%v", mainChannelId),
@@ -244,6 +230,11 @@ func leaveRoom(inData map[string]interface{}, h handler, uid string, c *websocke
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
 	defer cancel()
 
+	return leaveRoomChannelSubs(ctx, h, data.RoomID, c)
+}
+
+// Removes the connection from every channel subscription belonging to a room
+func leaveRoomChannelSubs(ctx context.Context, h handler, roomID string, c *websocket.Conn) error {
 	conn, err := h.DB.Acquire(ctx)
 	if err != nil {
 		return fmt.Errorf("Internal error")
@@ -257,20 +248,20 @@ func leaveRoom(inData map[string]interface{}, h handler, uid string, c *websocke
 		return fmt.Errorf("Internal error")
 	}
 
-	rows, err := conn.Query(ctx, selectChannelsStmt.Name, data.RoomID)
+	rows, err := conn.Query(ctx, selectChannelsStmt.Name, roomID)
 	if err != nil {
 		return fmt.Errorf("Internal error")
 	}
 	defer rows.Close()
 
-	channelIds := make(map[string]struct{})
+	channelSubs := make(map[string]struct{})
 
 	for rows.Next() {
 		var id string
 		if err = rows.Scan(&id); err != nil {
 			return fmt.Errorf("Internal error")
 		}
-		channelIds[id] = struct{}{}
+		channelSubs[fmt.Sprintf("channel:%v", id)] = struct{}{}
 	}
 
 	recvChan := make(chan map[string]struct{}, 1)
@@ -283,8 +274,7 @@ func leaveRoom(inData map[string]interface{}, h handler, uid string, c *websocke
 	close(recvChan)
 
 	for sub := range subs {
-		_, ok := channelIds[sub]
-		if strings.HasPrefix(sub, "channel:") && ok {
+		if _, ok := channelSubs[sub]; ok {
 			h.SocketServer.LeaveSubscriptionByWs <- socketServer.RegisterUnregisterSubsConnWs{
 				SubName: sub,
 				Conn:    c,
@@ -335,6 +325,14 @@ func joinChannel(inData map[string]interface{}, h handler, uid string, c *websoc
 		return fmt.Errorf("You are banned from this room")
 	}
 
+	if err = checkCanViewChannel(ctx, h, uid, data.ChannelID); err != nil {
+		return err
+	}
+
+	if err = checkAcceptedRules(ctx, h, uid, room_id); err != nil {
+		return err
+	}
+
 	h.SocketServer.JoinSubscriptionByWs <- socketServer.RegisterUnregisterSubsConnWs{
 		SubName: fmt.Sprintf("channel:%v", data.ChannelID),
 		Conn:    c,
@@ -368,72 +366,254 @@ func roomMessage(inData map[string]interface{}, h handler, uid string, c *websoc
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
 	defer cancel()
 
+	room_id, author_id, err := roomChannelAccess(ctx, h, uid, data.ChannelID)
+	if err != nil {
+		return err
+	}
+
+	if err = checkNotMuted(ctx, h, uid, room_id); err != nil {
+		return err
+	}
+
+	if err = checkAcceptedRules(ctx, h, uid, room_id); err != nil {
+		return err
+	}
+
+	content := strings.TrimSpace(data.Content)
+
+	// messages starting with a forward slash are commands, they are dispatched instead of being posted
+	if strings.HasPrefix(content, "/") {
+		if data.HasAttachment {
+			return fmt.Errorf("Commands cannot have attachments")
+		}
+		return handleRoomCommand(&roomCommandContext{
+			h:         h,
+			ctx:       ctx,
+			uid:       uid,
+			channelID: data.ChannelID,
+			roomID:    room_id,
+			ownerID:   author_id,
+		}, content)
+	}
+
+	id, err := sendRoomMessage(ctx, h, uid, data.ChannelID, room_id, author_id, content, data.HasAttachment)
+	if err != nil {
+		return err
+	}
+
+	if data.HasAttachment {
+		h.SocketServer.SendDataToUser <- socketServer.UserMessageData{
+			Uid: uid,
+			Data: socketMessages.RequestAttachment{
+				ID: id,
+			},
+			MessageType: "REQUEST_ATTACHMENT",
+		}
+	}
+
+	return nil
+}
+
+// Checks that the channel exists and that the user isn't banned from the room, isn't a member of
+// a private room, or can't view the channel. Returns the room ID and the ID of the rooms owner.
+func roomChannelAccess(ctx context.Context, h handler, uid string, channelID string) (roomID string, ownerID string, err error) {
 	conn, err := h.DB.Acquire(ctx)
 	if err != nil {
-		return fmt.Errorf("Internal error")
+		return "", "", fmt.Errorf("Internal error")
 	}
 	defer conn.Release()
 
-	selectChannelStmt, err := conn.Conn().Prepare(ctx, "room_message_select_room_channel_stmt", `
-	SELECT room_id FROM room_channels WHERE id = $1;
+	selectChannelStmt, err := conn.Conn().Prepare(ctx, "room_channel_access_select_room_stmt", `
+	SELECT rooms.id,rooms.private,rooms.author_id FROM room_channels
+	INNER JOIN rooms ON rooms.id = room_channels.room_id
+	WHERE room_channels.id = $1;
 	`)
 	if err != nil {
-		return fmt.Errorf("Internal error")
+		return "", "", fmt.Errorf("Internal error")
 	}
 
-	var room_id string
-	if err = conn.QueryRow(ctx, selectChannelStmt.Name, data.ChannelID).Scan(&room_id); err != nil {
+	var private bool
+	if err = conn.QueryRow(ctx, selectChannelStmt.Name, channelID).Scan(&roomID, &private, &ownerID); err != nil {
 		if err != pgx.ErrNoRows {
-			return fmt.Errorf("Internal error")
+			return "", "", fmt.Errorf("Internal error")
 		}
-		return fmt.Errorf("Room not found")
+		return "", "", fmt.Errorf("Room not found")
 	}
 
 	banExists := false
-	if err = h.DB.QueryRow(ctx, `
+	if err = conn.QueryRow(ctx, `
 	SELECT EXISTS(SELECT 1 FROM bans WHERE user_id = $1 AND room_id = $2);
-	`, uid, room_id).Scan(&banExists); err != nil {
-		return fmt.Errorf("Internal error")
+	`, uid, roomID).Scan(&banExists); err != nil {
+		return "", "", fmt.Errorf("Internal error")
 	}
 	if banExists {
-		return fmt.Errorf("You are banned from this room")
-	}
-
-	var private bool
-	var author_id string
-	if err = h.DB.QueryRow(ctx, `
-	SELECT private,author_id FROM rooms WHERE id = $1;
-	`, room_id).Scan(&private, &author_id); err != nil {
-		return fmt.Errorf("Internal error")
+		return "", "", fmt.Errorf("You are banned from this room")
 	}
 
-	if private && author_id != uid {
+	if private && ownerID != uid {
 		var membershipExists bool
-		if err = h.DB.QueryRow(ctx, `
+		if err = conn.QueryRow(ctx, `
 		SELECT EXISTS(SELECT 1 FROM members WHERE user_id = $1 AND room_id = $2);
-		`, uid, room_id).Scan(&membershipExists); err != nil {
-			return fmt.Errorf("Internal error")
+		`, uid, roomID).Scan(&membershipExists); err != nil {
+			return "", "", fmt.Errorf("Internal error")
 		}
 		if !membershipExists {
-			return fmt.Errorf("You are not a member of this room")
+			return "", "", fmt.Errorf("You are not a member of this room")
+		}
+	}
+
+	if ownerID != uid {
+		if err = checkCanViewChannel(ctx, h, uid, channelID); err != nil {
+			return "", "", err
+		}
+	}
+
+	return roomID, ownerID, nil
+}
+
+// Checks that a user can post a message, then filters, posts and mirrors it to followers of the channel.
+// Shared by ROOM_MESSAGE and commands that post to the channel, so that they can't be used to get around the checks.
+func sendRoomMessage(ctx context.Context, h handler, uid string, channelID string, roomID string, ownerID string, content string, hasAttachment bool) (string, error) {
+	if err := checkCanPost(ctx, h, uid, channelID, ownerID); err != nil {
+		return "", err
+	}
+
+	if strings.Contains(strings.ToLower(content), "@everyone") {
+		permissions, err := getRoomPermissions(ctx, h, uid, roomID)
+		if err != nil {
+			return "", fmt.Errorf("Internal error")
+		}
+		if !permissions.has(permMentionEveryone) {
+			return "", fmt.Errorf("You do not have permission to mention everyone")
 		}
 	}
 
+	filtered, err := filterMessage(ctx, h, uid, "ROOM_MESSAGE", roomID, content, 0)
+	if err != nil {
+		return "", err
+	}
+
+	// after the permission checks and the filter, since it starts the users wait for their next message
+	if err = checkSlowMode(ctx, h, uid, channelID, ownerID); err != nil {
+		return "", err
+	}
+
+	id, err := postRoomMessage(ctx, h, uid, channelID, roomID, ownerID, filtered.Content, roomMessageOpts{
+		hasAttachment: hasAttachment,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err = recordFilterMatches(ctx, h, uid, "ROOM_MESSAGE", id, roomID, content, filtered); err != nil {
+		return "", fmt.Errorf("Internal error")
+	}
+
+	if err = mirrorAnnouncement(ctx, h, uid, channelID, filtered.Content); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// Enforces the channels slow mode. The room owner is exempt. The cooldown is kept on redis,
+// keyed by the channel and the user, and expires when the user can post again.
+func checkSlowMode(ctx context.Context, h handler, uid string, channelID string, ownerID string) error {
+	if uid == ownerID {
+		return nil
+	}
+
+	var slowMode int
+	if err := h.DB.QueryRow(ctx, `
+	SELECT slow_mode FROM room_channels WHERE id = $1;
+	`, channelID).Scan(&slowMode); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	if slowMode == 0 {
+		return nil
+	}
+
+	key := fmt.Sprintf("slow-mode:%v:%v", channelID, uid)
+	set, err := h.RedisClient.SetNX(ctx, key, 1, time.Second*time.Duration(slowMode)).Result()
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	if !set {
+		remaining, err := h.RedisClient.PTTL(ctx, key).Result()
+		if err != nil {
+			return fmt.Errorf("Internal error")
+		}
+		return fmt.Errorf("Slow mode is on, wait %v seconds", int(math.Ceil(remaining.Seconds())))
+	}
+
+	return nil
+}
+
+func checkNotMuted(ctx context.Context, h handler, uid string, roomID string) error {
+	var muted bool
+	if err := h.DB.QueryRow(ctx, `
+	SELECT EXISTS(SELECT 1 FROM mutes WHERE user_id = $1 AND room_id = $2);
+	`, uid, roomID).Scan(&muted); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	if muted {
+		return fmt.Errorf("You are muted in this room")
+	}
+	return nil
+}
+
+// Optional parts of a room message
+type roomMessageOpts struct {
+	hasAttachment bool
+	// set for messages from incoming webhooks, there is no author
+	webhook *socketMessages.RoomMessageWebhook
+	// set for poll messages, the content is the question
+	poll *newPoll
+	// set for messages mirrored from a followed announcement channel, there is no author
+	source *socketMessages.RoomMessageSource
+}
+
+// Inserts a room message, creates notifications for members who aren't in the channel and sends the message out
+func postRoomMessage(ctx context.Context, h handler, uid string, channelID string, roomID string, ownerID string, content string, opts roomMessageOpts) (string, error) {
+	conn, err := h.DB.Acquire(ctx)
+	if err != nil {
+		return "", fmt.Errorf("Internal error")
+	}
+	defer conn.Release()
+
 	insertStmt, err := conn.Conn().Prepare(ctx, "insert_room_message_stmt", `
-	INSERT INTO room_messages (content, author_id, room_channel_id, has_attachment) VALUES($1, $2, $3, $4) RETURNING id;
+	INSERT INTO room_messages (content, author_id, room_channel_id, has_attachment, webhook_id, webhook_username, webhook_avatar, source_channel_id, source_author_id)
+	VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id;
 	`)
 	if err != nil {
-		return fmt.Errorf("Internal error")
+		return "", fmt.Errorf("Internal error")
 	}
 
-	content := strings.TrimSpace(data.Content)
+	var authorID, webhookID, webhookUsername, webhookAvatar, sourceChannelID, sourceAuthorID interface{}
+	if opts.webhook != nil {
+		webhookID = opts.webhook.ID
+		webhookUsername = opts.webhook.Username
+		webhookAvatar = opts.webhook.AvatarURL
+	} else if opts.source != nil {
+		sourceChannelID = opts.source.ChannelID
+		sourceAuthorID = opts.source.AuthorID
+	} else {
+		authorID = uid
+	}
 
 	var id string
-	if err := conn.QueryRow(ctx, insertStmt.Name, content, uid, data.ChannelID, data.HasAttachment).Scan(&id); err != nil {
-		return fmt.Errorf("Internal error")
+	if err := conn.QueryRow(ctx, insertStmt.Name, content, authorID, channelID, opts.hasAttachment, webhookID, webhookUsername, webhookAvatar, sourceChannelID, sourceAuthorID).Scan(&id); err != nil {
+		return "", fmt.Errorf("Internal error")
+	}
+
+	var poll *socketMessages.Poll
+	if opts.poll != nil {
+		if poll, err = insertPoll(ctx, conn, id, opts.poll); err != nil {
+			return "", err
+		}
 	}
 
-	subName := fmt.Sprintf("channel:%v", data.ChannelID)
+	subName := fmt.Sprintf("channel:%v", channelID)
 
 	// get uids of users in the channel, needed for excluding users already in the channel from notifications
 	recvChan := make(chan map[string]struct{})
@@ -443,48 +623,42 @@ func roomMessage(inData map[string]interface{}, h handler, uid string, c *websoc
 	}
 	uidsMap := <-recvChan
 	var receiveNotifications []string
-	if rows, err := h.DB.Query(ctx, `
+	if rows, err := conn.Query(ctx, `
 	SELECT user_id FROM members WHERE room_id = $1;
-	`, room_id); err != nil {
+	`, roomID); err != nil {
 		if err != pgx.ErrNoRows {
-			return fmt.Errorf("Internal error")
+			return "", fmt.Errorf("Internal error")
 		}
 	} else {
 		defer rows.Close()
 		for rows.Next() {
 			var uid string
 			if err = rows.Scan(&uid); err != nil {
-				return fmt.Errorf("Internal error")
+				return "", fmt.Errorf("Internal error")
 			}
 			if _, ok := uidsMap[uid]; !ok {
 				receiveNotifications = append(receiveNotifications, uid)
 			}
 		}
+		rows.Close()
 	}
-	var owner_id string
-	if err = h.DB.QueryRow(ctx, `
-	SELECT author_id FROM rooms WHERE id = $1;
-	`, room_id).Scan(&owner_id); err != nil {
-		return fmt.Errorf("Internal error")
-	} else {
-		if _, ok := uidsMap[owner_id]; !ok {
-			receiveNotifications = append(receiveNotifications, owner_id)
-		}
+	if _, ok := uidsMap[ownerID]; !ok {
+		receiveNotifications = append(receiveNotifications, ownerID)
 	}
 
 	for _, v := range receiveNotifications {
-		if _, err = h.DB.Exec(ctx, `
+		if _, err = conn.Exec(ctx, `
 		INSERT INTO room_message_notifications (user_id,channel_id,message_id,room_id) VALUES($1,$2,$3,$4);
-		`, v, data.ChannelID, id, room_id); err != nil {
-			return fmt.Errorf("Internal error")
+		`, v, channelID, id, roomID); err != nil {
+			return "", fmt.Errorf("Internal error")
 		}
 	}
 
 	h.SocketServer.SendDataToUsers <- socketServer.UsersMessageData{
 		Uids: receiveNotifications,
 		Data: socketMessages.RoomMessageNotify{
-			RoomID:    room_id,
-			ChannelID: data.ChannelID,
+			RoomID:    roomID,
+			ChannelID: channelID,
 		},
 		MessageType: "ROOM_MESSAGE_NOTIFY",
 	}
@@ -496,22 +670,30 @@ func roomMessage(inData map[string]interface{}, h handler, uid string, c *websoc
 			Content:       content,
 			CreatedAt:     time.Now().Format(time.RFC3339),
 			AuthorID:      uid,
-			HasAttachment: data.HasAttachment,
+			HasAttachment: opts.hasAttachment,
+			Webhook:       opts.webhook,
+			Poll:          poll,
+			Source:        opts.source,
 		},
 		MessageType: "ROOM_MESSAGE",
 	}
 
-	if data.HasAttachment {
-		h.SocketServer.SendDataToUser <- socketServer.UserMessageData{
-			Uid: uid,
-			Data: socketMessages.RequestAttachment{
-				ID: id,
-			},
-			MessageType: "REQUEST_ATTACHMENT",
-		}
+	webhookData := map[string]interface{}{
+		"ID":             id,
+		"channel_id":     channelID,
+		"author_id":      uid,
+		"content":        content,
+		"has_attachment": opts.hasAttachment,
+	}
+	if opts.webhook != nil {
+		webhookData["webhook_id"] = opts.webhook.ID
 	}
+	if poll != nil {
+		webhookData["poll_id"] = poll.ID
+	}
+	dispatchRoomWebhookEvent(h, roomID, "MESSAGE_CREATED", webhookData)
 
-	return nil
+	return id, nil
 }
 
 func roomMessageUpdate(inData map[string]interface{}, h handler, uid string, c *websocket.Conn) error {
@@ -530,21 +712,39 @@ func roomMessageUpdate(inData map[string]interface{}, h handler, uid string, c *
 	}
 	defer conn.Release()
 
+	var channel_id, room_id string
+	if err = conn.QueryRow(ctx, `
+	SELECT room_messages.room_channel_id,room_channels.room_id FROM room_messages
+	INNER JOIN room_channels ON room_channels.id = room_messages.room_channel_id
+	WHERE room_messages.author_id = $1 AND room_messages.id = $2;
+	`, uid, data.MsgID).Scan(&channel_id, &room_id); err != nil {
+		if err != pgx.ErrNoRows {
+			return fmt.Errorf("Internal error")
+		} else {
+			return fmt.Errorf("Message not found")
+		}
+	}
+
+	original := strings.TrimSpace(data.Content)
+	filtered, err := filterMessage(ctx, h, uid, "ROOM_MESSAGE", room_id, original, 0)
+	if err != nil {
+		return err
+	}
+	content := filtered.Content
+
 	stmt, err := conn.Conn().Prepare(ctx, "room_message_update_stmt", `
-	UPDATE room_messages SET content = $1 WHERE author_id = $2 AND id = $3;
+	UPDATE room_messages SET content = $1, edited_at = NOW() WHERE author_id = $2 AND id = $3;
 	`)
 	if err != nil {
 		return fmt.Errorf("Internal error")
 	}
 
-	content := strings.TrimSpace(data.Content)
+	if _, err = conn.Exec(ctx, stmt.Name, content, uid, data.MsgID); err != nil {
+		return fmt.Errorf("Internal error")
+	}
 
-	if _, err := conn.Exec(ctx, stmt.Name, content, uid, data.MsgID); err != nil {
-		if err != pgx.ErrNoRows {
-			return fmt.Errorf("Internal error")
-		} else {
-			return fmt.Errorf("Message not found")
-		}
+	if err = recordFilterMatches(ctx, h, uid, "ROOM_MESSAGE", data.MsgID, room_id, original, filtered); err != nil {
+		return fmt.Errorf("Internal error")
 	}
 
 	recvChan := make(chan map[string]struct{}, 1)
@@ -573,6 +773,13 @@ func roomMessageUpdate(inData map[string]interface{}, h handler, uid string, c *
 		SubName: channelName,
 	}
 
+	dispatchRoomWebhookEvent(h, room_id, "MESSAGE_UPDATED", map[string]interface{}{
+		"ID":         data.MsgID,
+		"channel_id": channel_id,
+		"author_id":  uid,
+		"content":    content,
+	})
+
 	return nil
 }
 
@@ -592,14 +799,18 @@ func roomMessageDelete(inData map[string]interface{}, h handler, uid string, c *
 	}
 	defer conn.Release()
 
-	stmt, err := conn.Conn().Prepare(ctx, "room_message_delete_stmt", `
-	DELETE FROM room_messages WHERE author_id = $1 AND id = $2;
+	selectStmt, err := conn.Conn().Prepare(ctx, "room_message_delete_select_stmt", `
+	SELECT room_messages.author_id,room_messages.room_channel_id,room_channels.room_id,room_messages.content FROM room_messages
+	INNER JOIN room_channels ON room_channels.id = room_messages.room_channel_id
+	WHERE room_messages.id = $1;
 	`)
 	if err != nil {
 		return fmt.Errorf("Internal error")
 	}
 
-	if _, err = conn.Exec(ctx, stmt.Name, uid, data.MsgID); err != nil {
+	var author_id *string
+	var channel_id, room_id, content string
+	if err = conn.QueryRow(ctx, selectStmt.Name, data.MsgID).Scan(&author_id, &channel_id, &room_id, &content); err != nil {
 		if err != pgx.ErrNoRows {
 			return fmt.Errorf("Internal error")
 		} else {
@@ -607,23 +818,35 @@ func roomMessageDelete(inData map[string]interface{}, h handler, uid string, c *
 		}
 	}
 
-	subsRecvChan := make(chan map[string]struct{}, 1)
-	h.SocketServer.GetConnectionSubscriptions <- socketServer.GetConnectionSubscriptions{
-		RecvChan: subsRecvChan,
-		Conn:     c,
+	// deleting other users messages, or messages without an author, needs the delete messages permission
+	if author_id == nil || *author_id != uid {
+		if err = checkRoomPermission(ctx, h, uid, room_id, permDeleteMessages); err != nil {
+			return err
+		}
 	}
-	subs := <-subsRecvChan
 
-	close(subsRecvChan)
+	stmt, err := conn.Conn().Prepare(ctx, "room_message_delete_stmt", `
+	DELETE FROM room_messages WHERE id = $1;
+	`)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	if _, err = conn.Exec(ctx, stmt.Name, data.MsgID); err != nil {
+		return fmt.Errorf("Internal error")
+	}
 
-	channelName := ""
-	for k := range subs {
-		if strings.HasPrefix(k, "channel:") {
-			channelName = k
-			break
+	if author_id == nil || *author_id != uid {
+		before := make(map[string]interface{})
+		before["author_id"] = author_id
+		before["channel_id"] = channel_id
+		before["content"] = content
+		if err = recordAuditLog(ctx, h, room_id, uid, "MESSAGE_DELETE", data.MsgID, before, nil); err != nil {
+			return fmt.Errorf("Internal error")
 		}
 	}
 
+	channelName := fmt.Sprintf("channel:%v", channel_id)
+
 	h.SocketServer.SendDataToSub <- socketServer.SubscriptionMessageData{
 		MessageType: "ROOM_MESSAGE_DELETE",
 		Data: socketMessages.RoomMessageDelete{
@@ -632,19 +855,6 @@ func roomMessageDelete(inData map[string]interface{}, h handler, uid string, c *
 		SubName: channelName,
 	}
 
-	var channel_id string
-	if err = h.DB.QueryRow(ctx, `
-	SELECT room_channel_id FROM room_messages WHERE id = $1;
-	`, data.MsgID).Scan(&channel_id); err != nil {
-		return fmt.Errorf("Internal error")
-	}
-	var room_id string
-	if err = h.DB.QueryRow(ctx, `
-	SELECT room_id FROM room_channels WHERE id = $1;
-	`, channel_id).Scan(&room_id); err != nil {
-		return fmt.Errorf("Internal error")
-	}
-
 	// get uids of users in the channel, needed for excluding users already in the channel from notifications
 	recvChan := make(chan map[string]struct{})
 	h.SocketServer.GetSubscriptionUids <- socketServer.GetSubscriptionUids{
@@ -699,6 +909,20 @@ func roomMessageDelete(inData map[string]interface{}, h handler, uid string, c *
 		MessageType: "ROOM_MESSAGE_NOTIFY_DELETE",
 	}
 
+	if err = markBookmarksUnavailable(ctx, h, data.MsgID); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	webhookData := map[string]interface{}{
+		"ID":         data.MsgID,
+		"channel_id": channel_id,
+		"author_id":  author_id,
+	}
+	if author_id == nil || *author_id != uid {
+		webhookData["deleted_by"] = uid
+	}
+	dispatchRoomWebhookEvent(h, room_id, "MESSAGE_DELETED", webhookData)
+
 	return nil
 }
 
@@ -736,14 +960,24 @@ func directMessage(inData map[string]interface{}, h handler, uid string, c *webs
 		return fmt.Errorf("This user has blocked your account")
 	}
 
+	original := strings.TrimSpace(data.Content)
+	filtered, err := filterMessage(ctx, h, uid, "DIRECT_MESSAGE", "", original, 200)
+	if err != nil {
+		return err
+	}
+
 	var id string
-	content := strings.TrimSpace(data.Content)
+	content := filtered.Content
 	if err := conn.QueryRow(ctx, `
 	INSERT INTO direct_messages (content, author_id, recipient_id, has_attachment) VALUES ($1, $2, $3, $4) RETURNING id;
 	`, content, uid, data.Uid, data.HasAttachment).Scan(&id); err != nil {
 		return fmt.Errorf("Internal error")
 	}
 
+	if err = recordFilterMatches(ctx, h, uid, "DIRECT_MESSAGE", id, "", original, filtered); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
 	h.SocketServer.SendDataToUsers <- socketServer.UsersMessageData{
 		Uids: []string{uid, data.Uid},
 		Data: socketMessages.DirectMessage{
@@ -823,18 +1057,27 @@ func directMessageUpdate(inData map[string]interface{}, h handler, uid string, c
 	}
 
 	updateMsgStmt, err := conn.Conn().Prepare(ctx, "direct_message_update_stmt", `
-	UPDATE direct_messages SET content = $1 WHERE id = $2;
+	UPDATE direct_messages SET content = $1, edited_at = NOW() WHERE id = $2;
 	`)
 	if err != nil {
 		return fmt.Errorf("Internal error")
 	}
 
-	content := strings.TrimSpace(data.Content)
+	original := strings.TrimSpace(data.Content)
+	filtered, err := filterMessage(ctx, h, uid, "DIRECT_MESSAGE", "", original, 200)
+	if err != nil {
+		return err
+	}
+	content := filtered.Content
 
 	if _, err = conn.Exec(ctx, updateMsgStmt.Name, content, data.MsgID); err != nil {
 		return fmt.Errorf("Internal error")
 	}
 
+	if err = recordFilterMatches(ctx, h, uid, "DIRECT_MESSAGE", data.MsgID, "", original, filtered); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
 	h.SocketServer.SendDataToUsers <- socketServer.UsersMessageData{
 		Uids: []string{uid, recipient_id},
 		Data: socketMessages.DirectMessageUpdate{
@@ -888,6 +1131,10 @@ func directMessageDelete(inData map[string]interface{}, h handler, uid string, c
 		return fmt.Errorf("Internal error")
 	}
 
+	if err = markBookmarksUnavailable(ctx, h, data.MsgID); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
 	h.SocketServer.SendDataToUsers <- socketServer.UsersMessageData{
 		Uids: []string{uid, recipient_id},
 		Data: socketMessages.DirectMessageDelete{
@@ -1183,24 +1430,39 @@ func invitation(inData map[string]interface{}, h handler, uid string, c *websock
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
 	defer cancel()
 
+	return inviteToRoom(ctx, h, uid, data.Uid, data.RoomID)
+}
+
+// Invitations are deleted if they haven't been responded to after this long
+const invitationDuration = time.Hour * 24 * 7
+
+func inviteToRoom(ctx context.Context, h handler, uid string, invited string, roomID string) error {
+	if err := checkRoomPermission(ctx, h, uid, roomID, permInvite); err != nil {
+		return err
+	}
+
 	conn, err := h.DB.Acquire(ctx)
 	if err != nil {
 		return fmt.Errorf("Internal error")
 	}
 	defer conn.Release()
 
-	selectInvitationExistsStmt, err := conn.Conn().Prepare(ctx, "invitation_select_invitation_stmt", `
-	SELECT EXISTS(SELECT 1 FROM invitations WHERE inviter = $1 AND invited = $2 AND room_id = $3);
+	// only one invitation per room and user, whoever sent it
+	selectInvitationStmt, err := conn.Conn().Prepare(ctx, "invitation_select_invitation_stmt", `
+	SELECT inviter FROM invitations WHERE invited = $1 AND room_id = $2 AND expires_at > NOW();
 	`)
 	if err != nil {
 		return fmt.Errorf("Internal error")
 	}
-	var invitationExists bool
-	if err = conn.QueryRow(ctx, selectInvitationExistsStmt.Name, data.Uid, uid, data.RoomID).Scan(&invitationExists); err != nil {
-		return fmt.Errorf("Internal error")
-	}
-	if invitationExists {
+	var existingInviter string
+	if err = conn.QueryRow(ctx, selectInvitationStmt.Name, invited, roomID).Scan(&existingInviter); err != nil {
+		if err != pgx.ErrNoRows {
+			return fmt.Errorf("Internal error")
+		}
+	} else if existingInviter == uid {
 		return fmt.Errorf("You have already sent an invitation to this user")
+	} else {
+		return fmt.Errorf("This user has already been invited to this room")
 	}
 
 	selectBlockedStmt, err := conn.Conn().Prepare(ctx, "invitation_select_blocked_stmt", `
@@ -1210,7 +1472,7 @@ func invitation(inData map[string]interface{}, h handler, uid string, c *websock
 		return fmt.Errorf("Internal error")
 	}
 	var blockedExists bool
-	if err = conn.QueryRow(ctx, selectBlockedStmt.Name, uid, data.Uid).Scan(&blockedExists); err != nil {
+	if err = conn.QueryRow(ctx, selectBlockedStmt.Name, uid, invited).Scan(&blockedExists); err != nil {
 		return fmt.Errorf("Internal error")
 	}
 	if blockedExists {
@@ -1224,7 +1486,7 @@ func invitation(inData map[string]interface{}, h handler, uid string, c *websock
 		return fmt.Errorf("Internal error")
 	}
 	var blockerExists bool
-	if err = conn.QueryRow(ctx, selectBlockerStmt.Name, uid, data.Uid).Scan(&blockerExists); err != nil {
+	if err = conn.QueryRow(ctx, selectBlockerStmt.Name, uid, invited).Scan(&blockerExists); err != nil {
 		return fmt.Errorf("Internal error")
 	}
 	if blockerExists {
@@ -1238,31 +1500,43 @@ func invitation(inData map[string]interface{}, h handler, uid string, c *websock
 		return fmt.Errorf("Internal error")
 	}
 	var memberExists bool
-	if err = conn.QueryRow(ctx, selectMemberExistsStmt.Name, data.Uid, data.RoomID).Scan(&memberExists); err != nil {
+	if err = conn.QueryRow(ctx, selectMemberExistsStmt.Name, invited, roomID).Scan(&memberExists); err != nil {
 		return fmt.Errorf("Internal error")
 	}
-	if blockerExists {
+	if memberExists {
 		return fmt.Errorf("This user is already a member of the room")
 	}
 
+	// an expired invitation that hasn't been deleted yet is replaced
 	insertStmt, err := conn.Conn().Prepare(ctx, "invitation_insert_stmt", `
-	INSERT INTO invitations (inviter, invited, room_id) VALUES($1, $2, $3);
+	INSERT INTO invitations (inviter, invited, room_id, expires_at) VALUES($1, $2, $3, $4)
+	ON CONFLICT (room_id, invited) DO UPDATE SET inviter = EXCLUDED.inviter, created_at = NOW(), expires_at = EXCLUDED.expires_at
+	WHERE invitations.expires_at <= NOW();
 	`)
 	if err != nil {
 		return fmt.Errorf("Internal error")
 	}
 
-	if _, err = conn.Exec(ctx, insertStmt.Name, uid, data.Uid, data.RoomID); err != nil {
+	createdAt := time.Now()
+	expiresAt := createdAt.Add(invitationDuration)
+	if tag, err := conn.Exec(ctx, insertStmt.Name, uid, invited, roomID, expiresAt); err != nil {
+		return fmt.Errorf("Internal error")
+	} else if tag.RowsAffected() == 0 {
+		return fmt.Errorf("This user has already been invited to this room")
+	}
+
+	if err = recordAuditLog(ctx, h, roomID, uid, "INVITE", invited, nil, nil); err != nil {
 		return fmt.Errorf("Internal error")
 	}
 
 	h.SocketServer.SendDataToUsers <- socketServer.UsersMessageData{
-		Uids: []string{uid, data.Uid},
+		Uids: []string{uid, invited},
 		Data: socketMessages.Invitation{
 			Inviter:   uid,
-			Invited:   data.Uid,
-			RoomID:    data.RoomID,
-			CreatedAt: time.Now().Format(time.RFC3339),
+			Invited:   invited,
+			RoomID:    roomID,
+			CreatedAt: createdAt.Format(time.RFC3339),
+			ExpiresAt: expiresAt.Format(time.RFC3339),
 		},
 		MessageType: "INVITATION",
 	}
@@ -1286,18 +1560,18 @@ func invitationResponse(inData map[string]interface{}, h handler, uid string, c
 	}
 	defer conn.Release()
 
-	selectExistsStmt, err := conn.Conn().Prepare(ctx, "invitation_response_select_stmt", `
-	SELECT EXISTS(SELECT 1 FROM invitations WHERE inviter = $1 AND invited = $2 AND room_id = $3);
+	selectInviterStmt, err := conn.Conn().Prepare(ctx, "invitation_response_select_stmt", `
+	SELECT inviter FROM invitations WHERE invited = $1 AND room_id = $2 AND expires_at > NOW();
 	`)
 	if err != nil {
 		return fmt.Errorf("Internal error")
 	}
-	var invitationExists bool
-	if err = conn.QueryRow(ctx, selectExistsStmt.Name, data.Inviter, uid, data.RoomID).Scan(&invitationExists); err != nil {
-		return fmt.Errorf("Internal error")
-	}
-	if !invitationExists {
-		return fmt.Errorf("This user did not send you an invitation")
+	var inviter string
+	if err = conn.QueryRow(ctx, selectInviterStmt.Name, uid, data.RoomID).Scan(&inviter); err != nil {
+		if err != pgx.ErrNoRows {
+			return fmt.Errorf("Internal error")
+		}
+		return fmt.Errorf("You have not been invited to this room, or the invitation expired")
 	}
 
 	selectInvitationExistsStmt, err := conn.Conn().Prepare(ctx, "invitation_response_select_member_stmt", `
@@ -1315,13 +1589,13 @@ func invitationResponse(inData map[string]interface{}, h handler, uid string, c
 	}
 
 	deleteStmt, err := conn.Conn().Prepare(ctx, "invitation_response_delete_stmt", `
-	DELETE FROM invitations WHERE inviter = $1 AND invited = $2 AND room_id = $3;
+	DELETE FROM invitations WHERE invited = $1 AND room_id = $2;
 	`)
 	if err != nil {
 		return fmt.Errorf("Internal error")
 	}
 
-	if _, err = conn.Exec(ctx, deleteStmt.Name, data.Inviter, uid, data.RoomID); err != nil {
+	if _, err = conn.Exec(ctx, deleteStmt.Name, uid, data.RoomID); err != nil {
 		return fmt.Errorf("Internal error")
 	}
 
@@ -1333,7 +1607,7 @@ func invitationResponse(inData map[string]interface{}, h handler, uid string, c
 	}
 
 	var blockedExists bool
-	if err = conn.QueryRow(ctx, selectBlockedStmt.Name, uid, data.Inviter).Scan(&blockedExists); err != nil {
+	if err = conn.QueryRow(ctx, selectBlockedStmt.Name, uid, inviter).Scan(&blockedExists); err != nil {
 		return fmt.Errorf("Internal error")
 	}
 	if blockedExists {
@@ -1348,7 +1622,7 @@ func invitationResponse(inData map[string]interface{}, h handler, uid string, c
 	}
 
 	var blockerExists bool
-	if err = conn.QueryRow(ctx, selectBlockerStmt.Name, uid, data.Inviter).Scan(&blockerExists); err != nil {
+	if err = conn.QueryRow(ctx, selectBlockerStmt.Name, uid, inviter).Scan(&blockerExists); err != nil {
 		return fmt.Errorf("Internal error")
 	}
 	if blockerExists {
@@ -1365,15 +1639,20 @@ func invitationResponse(inData map[string]interface{}, h handler, uid string, c
 		if _, err = conn.Exec(ctx, insertStmt.Name, uid, data.RoomID); err != nil {
 			return fmt.Errorf("Internal error")
 		}
+
+		dispatchRoomWebhookEvent(h, data.RoomID, "MEMBER_JOINED", map[string]interface{}{
+			"user_id":    uid,
+			"invited_by": inviter,
+		})
 	}
 
 	h.SocketServer.SendDataToUsers <- socketServer.UsersMessageData{
-		Uids:        []string{uid, data.Inviter},
+		Uids:        []string{uid, inviter},
 		MessageType: "INVITATION_RESPONSE",
 		Data: socketMessages.InvitationResponse{
 			Accepted: data.Accepted,
 			Invited:  uid,
-			Inviter:  data.Inviter,
+			Inviter:  inviter,
 			RoomID:   data.RoomID,
 		},
 	}
@@ -1381,6 +1660,45 @@ func invitationResponse(inData map[string]interface{}, h handler, uid string, c
 	return nil
 }
 
+// Only the user who sent the invitation can cancel it
+func invitationCancel(inData map[string]interface{}, h handler, uid string, c *websocket.Conn) error {
+	data := &socketValidation.InvitationCancel{}
+	var err error
+	if err = UnmarshalMap(inData, data); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	tag, err := h.DB.Exec(ctx, `
+	DELETE FROM invitations WHERE inviter = $1 AND invited = $2 AND room_id = $3;
+	`, uid, data.Uid, data.RoomID)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("You have not invited this user to this room")
+	}
+
+	if err = recordAuditLog(ctx, h, data.RoomID, uid, "INVITE_CANCEL", data.Uid, nil, nil); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	h.SocketServer.SendDataToUsers <- socketServer.UsersMessageData{
+		Uids: []string{uid, data.Uid},
+		Data: socketMessages.InvitationDelete{
+			Inviter: uid,
+			Invited: data.Uid,
+			RoomID:  data.RoomID,
+			Reason:  "CANCELLED",
+		},
+		MessageType: "INVITATION_DELETE",
+	}
+
+	return nil
+}
+
 func ban(inData map[string]interface{}, h handler, uid string, c *websocket.Conn) error {
 	data := &socketValidation.BanUnban{}
 	var err error
@@ -1397,14 +1715,31 @@ func ban(inData map[string]interface{}, h handler, uid string, c *websocket.Conn
 
 	var author_id string
 	if err = h.DB.QueryRow(ctx, `
-	SELECT author_id FROM rooms WHERE room_id = $1;
+	SELECT author_id FROM rooms WHERE id = $1;
 	`, data.RoomID).Scan(&author_id); err != nil {
 		return fmt.Errorf("Internal error")
 	}
-	if author_id != uid {
-		return fmt.Errorf("Only the owner of a room can ban users")
+	if data.Uid == author_id {
+		return fmt.Errorf("You cannot target the owner of the room")
+	}
+	if err = checkRoomPermission(ctx, h, uid, data.RoomID, permBan); err != nil {
+		return err
 	}
 
+	if len(data.Reason) > 200 {
+		return fmt.Errorf("Reason too long")
+	}
+	duration := time.Second * time.Duration(data.Duration)
+	if duration < 0 || duration > maxSanctionDuration {
+		return fmt.Errorf("Invalid duration")
+	}
+
+	return banRoomMember(ctx, h, data.Uid, data.RoomID, uid, data.Reason, duration)
+}
+
+// Bans the user from the room, deletes their messages and tells everyone in the rooms channels, then
+// takes the user out of the rooms channels and voice channels. A duration of 0 bans the user permanently.
+func banRoomMember(ctx context.Context, h handler, uid string, roomID string, issuerID string, reason string, duration time.Duration) error {
 	conn, err := h.DB.Acquire(ctx)
 	if err != nil {
 		return fmt.Errorf("Internal error")
@@ -1419,40 +1754,55 @@ func ban(inData map[string]interface{}, h handler, uid string, c *websocket.Conn
 	}
 
 	var banExists bool
-	if err = conn.QueryRow(ctx, blockedExistsStmt.Name, data.Uid, data.RoomID).Scan(&banExists); err != nil {
+	if err = conn.QueryRow(ctx, blockedExistsStmt.Name, uid, roomID).Scan(&banExists); err != nil {
 		return fmt.Errorf("Internal error")
 	}
 	if banExists {
 		return fmt.Errorf("User is already banned from this room")
 	}
 
+	expiresAt := sanctionExpiry(duration)
+
 	insertBanStmt, err := conn.Conn().Prepare(ctx, "ban_insert_stmt", `
-	INSERT INTO bans (user_id, room_id) VALUES($1, $2);
+	INSERT INTO bans (user_id, room_id, issuer_id, reason, expires_at) VALUES($1, $2, $3, $4, $5);
 	`)
 	if err != nil {
 		return fmt.Errorf("Internal error")
 	}
-	if _, err = conn.Exec(ctx, insertBanStmt.Name, data.Uid, data.RoomID); err != nil {
+	if _, err = conn.Exec(ctx, insertBanStmt.Name, uid, roomID, issuerID, reason, expiresAt); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	after := make(map[string]interface{})
+	after["reason"] = reason
+	after["expires_at"] = formatSanctionExpiry(expiresAt)
+	if err = recordAuditLog(ctx, h, roomID, issuerID, "BAN", uid, nil, after); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	if _, err = conn.Exec(ctx, `
+	DELETE FROM member_roles WHERE user_id = $1 AND room_id = $2;
+	`, uid, roomID); err != nil {
 		return fmt.Errorf("Internal error")
 	}
 
 	deleteMsgsStmt, err := conn.Conn().Prepare(ctx, "ban_delete_msgs_stmt", `
-	DELETE FROM room_messages WHERE author_id = $1 AND room_id = $2;
+	DELETE FROM room_messages WHERE author_id = $1 AND room_channel_id IN (SELECT id FROM room_channels WHERE room_id = $2);
 	`)
 	if err != nil {
 		return fmt.Errorf("Internal error")
 	}
-	if _, err = conn.Exec(ctx, deleteMsgsStmt.Name, data.Uid, data.RoomID); err != nil {
+	if _, err = conn.Exec(ctx, deleteMsgsStmt.Name, uid, roomID); err != nil {
 		return fmt.Errorf("Internal error")
 	}
 
 	selectChannelsStmt, err := conn.Conn().Prepare(ctx, "ban_select_channels_stmt", `
-	SELECT id FROM channels WHERE room_id = $1;
+	SELECT id FROM room_channels WHERE room_id = $1;
 	`)
 	if err != nil {
 		return fmt.Errorf("Internal error")
 	}
-	rows, err := conn.Query(ctx, selectChannelsStmt.Name, data.RoomID)
+	rows, err := conn.Query(ctx, selectChannelsStmt.Name, roomID)
 	if err != nil {
 		return fmt.Errorf("Internal error")
 	}
@@ -1467,13 +1817,33 @@ func ban(inData map[string]interface{}, h handler, uid string, c *websocket.Conn
 		h.SocketServer.SendDataToSub <- socketServer.SubscriptionMessageData{
 			SubName: fmt.Sprintf("channel:%v", id),
 			Data: socketMessages.Ban{
-				UserID: data.Uid,
-				RoomID: data.RoomID,
+				UserID:    uid,
+				RoomID:    roomID,
+				Reason:    reason,
+				ExpiresAt: formatSanctionExpiry(expiresAt),
 			},
 			MessageType: "BAN",
 		}
+
+		// does nothing if the user isn't in the channels voice chat
+		h.ChannelRTCServer.LeaveChannelRTC <- channelRTCserver.LeaveChannel{
+			Uid:       uid,
+			ChannelID: id,
+		}
+	}
+	rows.Close()
+
+	if err = leaveRoomChannelSubsByUid(ctx, h, roomID, uid); err != nil {
+		return err
 	}
 
+	dispatchRoomWebhookEvent(h, roomID, "BAN", map[string]interface{}{
+		"user_id":    uid,
+		"issuer_id":  issuerID,
+		"reason":     reason,
+		"expires_at": formatSanctionExpiry(expiresAt),
+	})
+
 	return nil
 }
 
@@ -1493,14 +1863,21 @@ func unban(inData map[string]interface{}, h handler, uid string, c *websocket.Co
 
 	var author_id string
 	if err = h.DB.QueryRow(ctx, `
-	SELECT author_id FROM rooms WHERE room_id = $1;
+	SELECT author_id FROM rooms WHERE id = $1;
 	`, data.RoomID).Scan(&author_id); err != nil {
 		return fmt.Errorf("Internal error")
 	}
-	if author_id != uid {
-		return fmt.Errorf("Only the owner of a room can unban users")
+	if data.Uid == author_id {
+		return fmt.Errorf("You cannot target the owner of the room")
+	}
+	if err = checkRoomPermission(ctx, h, uid, data.RoomID, permBan); err != nil {
+		return err
 	}
 
+	return unbanRoomMember(ctx, h, data.Uid, data.RoomID, uid)
+}
+
+func unbanRoomMember(ctx context.Context, h handler, uid string, roomID string, issuerID string) error {
 	conn, err := h.DB.Acquire(ctx)
 	if err != nil {
 		return fmt.Errorf("Internal error")
@@ -1514,7 +1891,7 @@ func unban(inData map[string]interface{}, h handler, uid string, c *websocket.Co
 		return fmt.Errorf("Internal error")
 	}
 	var banExists bool
-	if err = conn.QueryRow(ctx, banSelectStmt.Name, data.Uid, data.RoomID).Scan(&banExists); err != nil {
+	if err = conn.QueryRow(ctx, banSelectStmt.Name, uid, roomID).Scan(&banExists); err != nil {
 		return fmt.Errorf("Internal error")
 	}
 	if !banExists {
@@ -1522,15 +1899,149 @@ func unban(inData map[string]interface{}, h handler, uid string, c *websocket.Co
 	}
 
 	deleteStmt, err := conn.Conn().Prepare(ctx, "unban_delete_stmt", `
-	DELETE FROM bans WHERE user_id = $1 AND room_id = $2;
+	DELETE FROM bans WHERE user_id = $1 AND room_id = $2 RETURNING reason,expires_at;
+	`)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	var reason string
+	var expiresAt *time.Time
+	if err = conn.QueryRow(ctx, deleteStmt.Name, uid, roomID).Scan(&reason, &expiresAt); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	before := make(map[string]interface{})
+	before["reason"] = reason
+	before["expires_at"] = formatSanctionExpiry(expiresAt)
+	if err = recordAuditLog(ctx, h, roomID, issuerID, "UNBAN", uid, before, nil); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	return sendUnban(ctx, h, uid, roomID, "BAN")
+}
+
+func kick(inData map[string]interface{}, h handler, uid string, c *websocket.Conn) error {
+	data := &socketValidation.Kick{}
+	var err error
+	if err = UnmarshalMap(inData, data); err != nil {
+		return err
+	}
+
+	if data.Uid == uid {
+		return fmt.Errorf("You cannot kick yourself")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	var author_id string
+	if err = h.DB.QueryRow(ctx, `
+	SELECT author_id FROM rooms WHERE id = $1;
+	`, data.RoomID).Scan(&author_id); err != nil {
+		if err != pgx.ErrNoRows {
+			return fmt.Errorf("Internal error")
+		}
+		return fmt.Errorf("Room not found")
+	}
+	if data.Uid == author_id {
+		return fmt.Errorf("You cannot target the owner of the room")
+	}
+	if err = checkRoomPermission(ctx, h, uid, data.RoomID, permKick); err != nil {
+		return err
+	}
+
+	return kickRoomMember(ctx, h, data.Uid, data.RoomID, uid)
+}
+
+// Removes the users membership and roles, takes them out of the rooms channels and voice
+// channels, and tells them and everyone in the room. Public rooms can be rejoined.
+func kickRoomMember(ctx context.Context, h handler, uid string, roomID string, actorID string) error {
+	conn, err := h.DB.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	defer conn.Release()
+
+	deleteMemberStmt, err := conn.Conn().Prepare(ctx, "kick_delete_member_stmt", `
+	DELETE FROM members WHERE user_id = $1 AND room_id = $2;
+	`)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	tag, err := conn.Exec(ctx, deleteMemberStmt.Name, uid, roomID)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("This user is not a member of the room")
+	}
+
+	deleteRolesStmt, err := conn.Conn().Prepare(ctx, "kick_delete_member_roles_stmt", `
+	DELETE FROM member_roles WHERE user_id = $1 AND room_id = $2;
 	`)
 	if err != nil {
 		return fmt.Errorf("Internal error")
 	}
-	if _, err = conn.Exec(ctx, deleteStmt.Name, data.Uid, data.RoomID); err != nil {
+	if _, err = conn.Exec(ctx, deleteRolesStmt.Name, uid, roomID); err != nil {
+		return fmt.Errorf("Internal error")
+	}
+
+	if err = recordAuditLog(ctx, h, roomID, actorID, "KICK", uid, nil, nil); err != nil {
 		return fmt.Errorf("Internal error")
 	}
 
+	if err = leaveRoomChannelSubsByUid(ctx, h, roomID, uid); err != nil {
+		return err
+	}
+
+	selectChannelsStmt, err := conn.Conn().Prepare(ctx, "kick_select_channels_stmt", `
+	SELECT id FROM room_channels WHERE room_id = $1;
+	`)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	rows, err := conn.Query(ctx, selectChannelsStmt.Name, roomID)
+	if err != nil {
+		return fmt.Errorf("Internal error")
+	}
+	defer rows.Close()
+
+	subNames := []string{fmt.Sprintf("room:%v", roomID)}
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return fmt.Errorf("Internal error")
+		}
+		subNames = append(subNames, fmt.Sprintf("channel:%v", id))
+
+		// does nothing if the user isn't in the channels voice chat
+		h.ChannelRTCServer.LeaveChannelRTC <- channelRTCserver.LeaveChannel{
+			Uid:       uid,
+			ChannelID: id,
+		}
+	}
+
+	h.SocketServer.SendDataToUser <- socketServer.UserMessageData{
+		Uid: uid,
+		Data: socketMessages.Kicked{
+			RoomID: roomID,
+		},
+		MessageType: "KICKED",
+	}
+
+	changeData := make(map[string]interface{})
+	changeData["ID"] = uid
+	changeData["room_id"] = roomID
+	h.SocketServer.SendDataToSubs <- socketServer.SubscriptionsMessageData{
+		SubNames: subNames,
+		Data: socketMessages.ChangeEvent{
+			Type:   "DELETE",
+			Entity: "MEMBER",
+			Data:   changeData,
+		},
+		MessageType: "CHANGE",
+	}
+
 	return nil
 }
 
@@ -1828,6 +2339,20 @@ func channelWebRTCJoin(inData map[string]interface{}, h handler, uid string, c *
 		return err
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	room_id, _, err := roomChannelAccess(ctx, h, uid, data.ChannelID)
+	if err != nil {
+		return err
+	}
+	if err = checkNotMuted(ctx, h, uid, room_id); err != nil {
+		return err
+	}
+	if err = checkAcceptedRules(ctx, h, uid, room_id); err != nil {
+		return err
+	}
+
 	h.ChannelRTCServer.JoinChannelRTC <- channelRTCserver.JoinChannel{
 		Uid:               uid,
 		ChannelID:         data.ChannelID,
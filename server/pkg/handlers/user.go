@@ -38,14 +38,15 @@ func (h handler) GetUser(ctx *fiber.Ctx) error {
 	defer conn.Release()
 
 	selectUserStmt, err := conn.Conn().Prepare(rctx, "get_user_select_stmt", `
-	SELECT id,username,role FROM users WHERE id = $1;
+	SELECT id,username,role,bot FROM users WHERE id = $1;
 	`)
 	if err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 	}
 
 	var id, username, role string
-	if err := conn.QueryRow(rctx, selectUserStmt.Name, user_id).Scan(&id, &username, &role); err != nil {
+	var bot bool
+	if err := conn.QueryRow(rctx, selectUserStmt.Name, user_id).Scan(&id, &username, &role, &bot); err != nil {
 		if err != pgx.ErrNoRows {
 			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 		} else {
@@ -73,6 +74,7 @@ func (h handler) GetUser(ctx *fiber.Ctx) error {
 		Username: username,
 		Role:     role,
 		Online:   isOnline,
+		Bot:      bot,
 	}); err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
 	} else {
@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/pgtype"
+	"github.com/jackc/pgx/v5"
+	"github.com/web-stuff-98/psql-social/pkg/helpers/authHelpers"
+	"github.com/web-stuff-98/psql-social/pkg/responses"
+	"github.com/web-stuff-98/psql-social/pkg/validation"
+	webhookServer "github.com/web-stuff-98/psql-social/pkg/webhookServer"
+)
+
+const maxWebhooksPerRoom = 10
+
+// Sends an event to the rooms outgoing webhooks. Delivery happens in the background.
+// Never blocks, if the webhook server is backed up the event is dropped.
+func dispatchRoomWebhookEvent(h handler, roomID string, event string, data map[string]interface{}) {
+	select {
+	case h.WebhookServer.DispatchEvent <- webhookServer.RoomEvent{
+		RoomID: roomID,
+		Event:  event,
+		Data:   data,
+	}:
+	default:
+		log.Printf("Webhook event queue full, dropped %v event for room %v\n", event, roomID)
+	}
+}
+
+func parseWebhookBody(ctx *fiber.Ctx) (*validation.CreateUpdateRoomWebhook, error) {
+	v := validator.New()
+	body := &validation.CreateUpdateRoomWebhook{}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if err := v.Struct(body); err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	u, err := url.Parse(body.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Webhook URL must be http or https")
+	}
+	// hostnames are checked again when delivering, after they are resolved
+	if !webhookServer.AllowPrivateHosts() {
+		host := u.Hostname()
+		if ip := net.ParseIP(host); strings.EqualFold(host, "localhost") || (ip != nil && webhookServer.IsBlockedIP(ip)) {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "Webhook URL cannot be a local or private address")
+		}
+	}
+	return body, nil
+}
+
+func (h handler) CreateRoomWebhook(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	room_id := ctx.Params("id")
+	if room_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	body, err := parseWebhookBody(ctx)
+	if err != nil {
+		return err
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	selectRoomStmt, err := conn.Conn().Prepare(rctx, "create_room_webhook_select_room_stmt", `
+	SELECT author_id,(SELECT COUNT(*) FROM room_webhooks WHERE room_id = $1) FROM rooms WHERE id = $1;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	var author_id string
+	var count int
+	if err = conn.QueryRow(rctx, selectRoomStmt.Name, room_id).Scan(&author_id, &count); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Room not found")
+	}
+	if author_id != uid {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+	if count >= maxWebhooksPerRoom {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("A room cannot have more than %v webhooks", maxWebhooksPerRoom))
+	}
+
+	secretBytes := make([]byte, 32)
+	if _, err = rand.Read(secretBytes); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	secret := hex.EncodeToString(secretBytes)
+
+	insertStmt, err := conn.Conn().Prepare(rctx, "create_room_webhook_insert_stmt", `
+	INSERT INTO room_webhooks (room_id,url,secret,events) VALUES($1,$2,$3,$4) RETURNING id;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	var id string
+	if err = conn.QueryRow(rctx, insertStmt.Name, room_id, body.URL, secret, body.Events).Scan(&id); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	if bytes, err := json.Marshal(responses.RoomWebhookSecret{
+		ID:     id,
+		Secret: secret,
+	}); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Status(fiber.StatusCreated)
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
+
+func (h handler) GetRoomWebhooks(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	room_id := ctx.Params("id")
+	if room_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	var author_id string
+	if err = conn.QueryRow(rctx, `
+	SELECT author_id FROM rooms WHERE id = $1;
+	`, room_id).Scan(&author_id); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Room not found")
+	}
+	if author_id != uid {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	selectStmt, err := conn.Conn().Prepare(rctx, "get_room_webhooks_select_stmt", `
+	SELECT id,url,events,disabled,consecutive_failures,created_at FROM room_webhooks WHERE room_id = $1 ORDER BY created_at ASC;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	rows, err := conn.Query(rctx, selectStmt.Name, room_id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer rows.Close()
+
+	webhooks := []responses.RoomWebhook{}
+
+	for rows.Next() {
+		var id, url string
+		var events []string
+		var disabled bool
+		var consecutive_failures int
+		var created_at pgtype.Timestamptz
+		if err = rows.Scan(&id, &url, &events, &disabled, &consecutive_failures, &created_at); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		webhooks = append(webhooks, responses.RoomWebhook{
+			ID:                  id,
+			URL:                 url,
+			Events:              events,
+			Disabled:            disabled,
+			ConsecutiveFailures: consecutive_failures,
+			CreatedAt:           created_at.Time.Format(time.RFC3339),
+		})
+	}
+
+	if bytes, err := json.Marshal(webhooks); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
+
+// Updating a webhook also re-enables it if it was disabled after failing too many times
+func (h handler) UpdateRoomWebhook(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	webhook_id := ctx.Params("id")
+	if webhook_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	body, err := parseWebhookBody(ctx)
+	if err != nil {
+		return err
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	updateStmt, err := conn.Conn().Prepare(rctx, "update_room_webhook_stmt", `
+	UPDATE room_webhooks SET url = $1, events = $2, disabled = FALSE, consecutive_failures = 0
+	WHERE id = $3 AND room_id IN (SELECT id FROM rooms WHERE author_id = $4)
+	RETURNING id;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	var id string
+	if err = conn.QueryRow(rctx, updateStmt.Name, body.URL, body.Events, webhook_id, uid).Scan(&id); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Webhook not found")
+	}
+
+	return nil
+}
+
+func (h handler) DeleteRoomWebhook(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	webhook_id := ctx.Params("id")
+	if webhook_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	deleteStmt, err := conn.Conn().Prepare(rctx, "delete_room_webhook_stmt", `
+	DELETE FROM room_webhooks WHERE id = $1 AND room_id IN (SELECT id FROM rooms WHERE author_id = $2) RETURNING id;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	var id string
+	if err = conn.QueryRow(rctx, deleteStmt.Name, webhook_id, uid).Scan(&id); err != nil {
+		if err != pgx.ErrNoRows {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Webhook not found")
+	}
+
+	return nil
+}
+
+// Returns the 50 most recent delivery attempts
+func (h handler) GetRoomWebhookDeliveries(ctx *fiber.Ctx) error {
+	rctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	uid, _, err := authHelpers.GetUidAndSid(h.RedisClient, ctx, rctx, h.DB)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	webhook_id := ctx.Params("id")
+	if webhook_id == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	conn, err := h.DB.Acquire(rctx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer conn.Release()
+
+	var exists bool
+	if err = conn.QueryRow(rctx, `
+	SELECT EXISTS(SELECT 1 FROM room_webhooks WHERE id = $1 AND room_id IN (SELECT id FROM rooms WHERE author_id = $2));
+	`, webhook_id, uid).Scan(&exists); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	if !exists {
+		return fiber.NewError(fiber.StatusNotFound, "Webhook not found")
+	}
+
+	selectStmt, err := conn.Conn().Prepare(rctx, "get_room_webhook_deliveries_select_stmt", `
+	SELECT id,delivery_id,event,attempt,status_code,error,success,created_at FROM room_webhook_deliveries
+	WHERE webhook_id = $1 ORDER BY created_at DESC LIMIT 50;
+	`)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+
+	rows, err := conn.Query(rctx, selectStmt.Name, webhook_id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	}
+	defer rows.Close()
+
+	deliveries := []responses.RoomWebhookDelivery{}
+
+	for rows.Next() {
+		var id, delivery_id, event, errMsg string
+		var attempt, status_code int
+		var success bool
+		var created_at pgtype.Timestamptz
+		if err = rows.Scan(&id, &delivery_id, &event, &attempt, &status_code, &errMsg, &success, &created_at); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+		}
+		deliveries = append(deliveries, responses.RoomWebhookDelivery{
+			ID:         id,
+			DeliveryID: delivery_id,
+			Event:      event,
+			Attempt:    attempt,
+			StatusCode: status_code,
+			Error:      errMsg,
+			Success:    success,
+			CreatedAt:  created_at.Time.Format(time.RFC3339),
+		})
+	}
+
+	if bytes, err := json.Marshal(deliveries); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal error")
+	} else {
+		ctx.Response().Header.Add("Content-Type", "application/json")
+		ctx.Write(bytes)
+	}
+
+	return nil
+}
@@ -7,9 +7,9 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-// Returns the correct table names for a message (checks if its a direct message or a room message)
+// Returns the correct table names for a message (checks if its a direct message, a room message or a group message)
 func GetTableNames(conn *pgxpool.Conn, ctx context.Context, id string) (metaTable string, chunkTable string, err error) {
-	var isDirectMessage, isRoomMsg bool
+	var isDirectMessage, isRoomMsg, isGroupMsg bool
 	if selectDirectMessage, err := conn.Conn().Prepare(ctx, "get_msg_table_names_helper_select_direct_messages_stmt", "SELECT EXISTS(SELECT 1 FROM direct_messages WHERE id = $1);"); err != nil {
 		return "", "", err
 	} else {
@@ -24,11 +24,21 @@ func GetTableNames(conn *pgxpool.Conn, ctx context.Context, id string) (metaTabl
 			return "", "", err
 		}
 	}
+	if selectGroupMessage, err := conn.Conn().Prepare(ctx, "get_msg_table_names_helper_select_group_messages_stmt", "SELECT EXISTS(SELECT 1 FROM group_messages WHERE id = $1);"); err != nil {
+		return "", "", err
+	} else {
+		if err = conn.Conn().QueryRow(ctx, selectGroupMessage.Name, id).Scan(&isGroupMsg); err != nil {
+			return "", "", err
+		}
+	}
 	if isDirectMessage {
 		return "direct_message_attachment_metadata", "direct_message_attachment_chunks", nil
 	}
 	if isRoomMsg {
 		return "room_message_attachment_metadata", "room_message_attachment_chunks", nil
 	}
-	return "", "", fmt.Errorf("Message not found in either table")
+	if isGroupMsg {
+		return "group_message_attachment_metadata", "group_message_attachment_chunks", nil
+	}
+	return "", "", fmt.Errorf("Message not found in any table")
 }
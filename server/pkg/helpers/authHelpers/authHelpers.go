@@ -2,10 +2,14 @@ package authHelpers
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"os"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -81,11 +85,60 @@ func Authorize(redisClient *redis.Client, ctx context.Context, uid string) (*fib
 		log.Fatalln("Redis error in Authorize helper function:", cmd.Err())
 	}
 
+	// keep track of the users sessions so that they can be listed and force logged out
+	if err := redisClient.SAdd(ctx, userSessionsKey(uid), sid.String()).Err(); err != nil {
+		log.Fatalln("Redis error in Authorize helper function:", err)
+	}
+	redisClient.Expire(ctx, userSessionsKey(uid), sessionDuration)
+
 	return cookie, nil
 }
 
-// Decrypt the JWT stored inside the cookie, queries the db for the user ID and returns the user ID and session ID
+// Bots send their token in the Authorization header as "Bot <token>" instead of using the session cookie
+func GetBotToken(ctx *fiber.Ctx) string {
+	header := ctx.Get(fiber.HeaderAuthorization)
+	if !strings.HasPrefix(header, "Bot ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bot "))
+}
+
+// Bot tokens are stored as sha256 hashes, so that a leaked database doesn't leak usable tokens
+func HashBotToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func GenerateBotToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Decrypt the JWT stored inside the cookie, queries the db for the user ID and returns the user ID and session ID.
+// Bots are looked up by their token instead, and have no session ID. Bot tokens stop working while the bot or
+// its owner is suspended.
 func GetUidAndSid(redisClient *redis.Client, ctx *fiber.Ctx, rctx context.Context, db *pgxpool.Pool) (uid string, sid string, err error) {
+	if token := GetBotToken(ctx); token != "" {
+		var botID string
+		var suspended bool
+		if err := db.QueryRow(rctx, `
+		SELECT bot_tokens.bot_id, COALESCE(bots.suspended_until > NOW(), FALSE) OR COALESCE(owners.suspended_until > NOW(), FALSE)
+		FROM bot_tokens
+		INNER JOIN users AS bots ON bots.id = bot_tokens.bot_id
+		LEFT JOIN users AS owners ON owners.id = bots.owner_id
+		WHERE bot_tokens.token_hash = $1;
+		`, HashBotToken(token)).Scan(&botID, &suspended); err != nil {
+			return "", "", fmt.Errorf("Invalid bot token")
+		}
+		if suspended {
+			return "", "", fmt.Errorf("Suspended")
+		}
+		return botID, "", nil
+	}
+
 	cookie := string(ctx.Request().Header.Cookie("session_token"))
 	if cookie == "" {
 		return "", "", fmt.Errorf("No cookie")
@@ -106,11 +159,27 @@ func GetUidAndSid(redisClient *redis.Client, ctx *fiber.Ctx, rctx context.Contex
 	return val, sessionID, nil
 }
 
+// Suspended users can't log in, refresh their session or open a socket connection
+func IsSuspended(ctx context.Context, db *pgxpool.Pool, uid string) (bool, error) {
+	var suspended bool
+	if err := db.QueryRow(ctx, `
+	SELECT COALESCE(suspended_until > NOW(), FALSE) FROM users WHERE id = $1;
+	`, uid).Scan(&suspended); err != nil {
+		return false, err
+	}
+	return suspended, nil
+}
+
 func RefreshToken(redisClient *redis.Client, ctx *fiber.Ctx, rctx context.Context, db *pgxpool.Pool) (*fiber.Cookie, error) {
 	if uid, sid, err := GetUidAndSid(redisClient, ctx, rctx, db); err != nil {
 		return GetClearedCookie(), err
+	} else if sid == "" {
+		return GetClearedCookie(), fmt.Errorf("Bots do not have sessions")
+	} else if suspended, err := IsSuspended(rctx, db, uid); err != nil || suspended {
+		DeleteSession(redisClient, rctx, uid, sid)
+		return GetClearedCookie(), fmt.Errorf("Suspended")
 	} else {
-		redisClient.Del(rctx, sid)
+		DeleteSession(redisClient, rctx, uid, sid)
 		if cookie, err := Authorize(redisClient, rctx, uid); err != nil {
 			return GetClearedCookie(), err
 		} else {
@@ -119,7 +188,54 @@ func RefreshToken(redisClient *redis.Client, ctx *fiber.Ctx, rctx context.Contex
 	}
 }
 
-// kind of useless verbose function
-func DeleteSession(redisClient *redis.Client, ctx context.Context, sid string) {
+func userSessionsKey(uid string) string {
+	return "sessions:" + uid
+}
+
+func DeleteSession(redisClient *redis.Client, ctx context.Context, uid string, sid string) {
 	redisClient.Del(ctx, sid)
+	redisClient.SRem(ctx, userSessionsKey(uid), sid)
+}
+
+type Session struct {
+	ID        string
+	ExpiresIn time.Duration
+}
+
+// Returns the users sessions that haven't expired yet
+func GetSessions(redisClient *redis.Client, ctx context.Context, uid string) ([]Session, error) {
+	sids, err := redisClient.SMembers(ctx, userSessionsKey(uid)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []Session{}
+	for _, sid := range sids {
+		ttl, err := redisClient.TTL(ctx, sid).Result()
+		if err != nil {
+			return nil, err
+		}
+		// the key is gone, so the session expired
+		if ttl < 0 {
+			redisClient.SRem(ctx, userSessionsKey(uid), sid)
+			continue
+		}
+		sessions = append(sessions, Session{ID: sid, ExpiresIn: ttl})
+	}
+
+	return sessions, nil
+}
+
+// Deletes all of the users sessions, logging them out everywhere
+func DeleteSessions(redisClient *redis.Client, ctx context.Context, uid string) error {
+	sids, err := redisClient.SMembers(ctx, userSessionsKey(uid)).Result()
+	if err != nil {
+		return err
+	}
+	if len(sids) > 0 {
+		if err = redisClient.Del(ctx, sids...).Err(); err != nil {
+			return err
+		}
+	}
+	return redisClient.Del(ctx, userSessionsKey(uid)).Err()
 }
@@ -0,0 +1,91 @@
+package pollhelpers
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	socketMessages "github.com/web-stuff-98/psql-social/pkg/socketMessages"
+)
+
+// Returns the poll with the vote counts for each option. Voters are only included for polls that aren't anonymous.
+func GetPollTally(conn *pgxpool.Conn, ctx context.Context, id string) (*socketMessages.Poll, error) {
+	selectPollStmt, err := conn.Conn().Prepare(ctx, "get_poll_tally_helper_select_poll_stmt", `
+	SELECT message_id,multiple,anonymous,closes_at,closed FROM polls WHERE id = $1;
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	poll := &socketMessages.Poll{
+		ID:      id,
+		Options: []socketMessages.PollOption{},
+	}
+	var closes_at *time.Time
+	if err = conn.QueryRow(ctx, selectPollStmt.Name, id).Scan(&poll.MessageID, &poll.Multiple, &poll.Anonymous, &closes_at, &poll.Closed); err != nil {
+		return nil, err
+	}
+	if closes_at != nil {
+		poll.ClosesAt = closes_at.Format(time.RFC3339)
+	}
+
+	selectOptionsStmt, err := conn.Conn().Prepare(ctx, "get_poll_tally_helper_select_options_stmt", `
+	SELECT poll_options.id,poll_options.content,ARRAY_REMOVE(ARRAY_AGG(poll_votes.user_id::TEXT), NULL)
+	FROM poll_options
+	LEFT JOIN poll_votes ON poll_votes.option_id = poll_options.id
+	WHERE poll_options.poll_id = $1
+	GROUP BY poll_options.id
+	ORDER BY poll_options.position ASC;
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.Query(ctx, selectOptionsStmt.Name, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var option socketMessages.PollOption
+		var voters []string
+		if err = rows.Scan(&option.ID, &option.Content, &voters); err != nil {
+			return nil, err
+		}
+		option.Votes = len(voters)
+		if !poll.Anonymous {
+			option.Voters = voters
+		}
+		poll.Options = append(poll.Options, option)
+	}
+
+	return poll, rows.Err()
+}
+
+// Returns the ids of the options the user voted for
+func GetUserVotes(conn *pgxpool.Conn, ctx context.Context, id string, uid string) ([]string, error) {
+	selectStmt, err := conn.Conn().Prepare(ctx, "get_user_votes_helper_select_stmt", `
+	SELECT option_id FROM poll_votes WHERE poll_id = $1 AND user_id = $2;
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.Query(ctx, selectStmt.Name, id, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	votes := []string{}
+	for rows.Next() {
+		var option_id string
+		if err = rows.Scan(&option_id); err != nil {
+			return nil, err
+		}
+		votes = append(votes, option_id)
+	}
+
+	return votes, rows.Err()
+}
@@ -8,6 +8,7 @@ type User struct {
 	Online   bool   `json:"online"`
 	// "ADMIN" | "USER"
 	Role string `json:"role"`
+	Bot  bool   `json:"bot"`
 }
 
 type Room struct {
@@ -16,6 +17,13 @@ type Room struct {
 	AuthorID  string `json:"author_id"`
 	Private   bool   `json:"is_private"`
 	CreatedAt string `json:"created_at"`
+	// Only included by GetRoom. Null keeps messages forever
+	RetentionDays *int `json:"retention_days,omitempty"`
+	// Only included by GetRoom. Channel ids to their retention, for channels that override the rooms retention (0 keeps messages forever)
+	ChannelRetentionDays map[string]int `json:"channel_retention_days,omitempty"`
+	// Only included by GetRoom, for the owner
+	Succession  *bool  `json:"succession,omitempty"`
+	SuccessorID string `json:"successor_id,omitempty"`
 }
 
 type RoomsPage struct {
@@ -23,10 +31,104 @@ type RoomsPage struct {
 	Count int    `json:"count"`
 }
 
+type BookmarksPage struct {
+	Bookmarks []Bookmark `json:"bookmarks"`
+	Count     int        `json:"count"`
+}
+
+type Bookmark struct {
+	ID        string `json:"ID"`
+	Kind      string `json:"kind"`
+	MsgID     string `json:"msg_id"`
+	Note      string `json:"note"`
+	CreatedAt string `json:"created_at"`
+	// true if the message was deleted or the user can no longer read it, the message fields below are left empty
+	Unavailable bool   `json:"unavailable"`
+	Content     string `json:"content,omitempty"`
+	AuthorID    string `json:"author_id,omitempty"`
+	// where the message is. channel_id and room_id for room messages, conversee_id (the other user) for direct messages, group_id for group messages
+	ChannelID   string `json:"channel_id,omitempty"`
+	RoomID      string `json:"room_id,omitempty"`
+	ConverseeID string `json:"conversee_id,omitempty"`
+	GroupID     string `json:"group_id,omitempty"`
+}
+
 type RoomChannelBase struct {
+	ID    string `json:"ID"`
+	Name  string `json:"name"`
+	Main  bool   `json:"main"`
+	Topic string `json:"topic"`
+	// seconds users have to wait between messages, 0 if slow mode is off
+	SlowMode int `json:"slow_mode"`
+	// "TEXT" | "ANNOUNCEMENT"
+	Type string `json:"type"`
+	// empty if the channel isn't in a category
+	CategoryID string `json:"category_id"`
+	Position   int    `json:"position"`
+}
+
+type RoomRole struct {
 	ID   string `json:"ID"`
 	Name string `json:"name"`
-	Main bool   `json:"main"`
+	// bitset, see roomPermissions in handlers/roomRoles.go
+	Permissions int64 `json:"permissions"`
+}
+
+// Users who are members of the room, or have been given a role
+type RoomMember struct {
+	ID    string   `json:"ID"`
+	Owner bool     `json:"owner"`
+	Roles []string `json:"roles"`
+	// the combined permissions of the users roles
+	Permissions int64 `json:"permissions"`
+}
+
+type AuditLogPage struct {
+	Entries []AuditLogEntry `json:"entries"`
+	Count   int             `json:"count"`
+}
+
+type AuditLogEntry struct {
+	ID string `json:"ID"`
+	// BAN/UNBAN/MUTE/UNMUTE/KICK/MESSAGE_DELETE/CHANNEL_CREATE/CHANNEL_UPDATE/CHANNEL_DELETE/
+	// ROOM_UPDATE/OWNER_TRANSFER/INVITE/INVITE_CANCEL/INVITE_CREATE/INVITE_REVOKE/JOIN_APPROVE/JOIN_DENY/
+	// ROLE_CREATE/ROLE_UPDATE/ROLE_DELETE/ROLE_ASSIGN/ROLE_UNASSIGN/CATEGORY_CREATE/CATEGORY_UPDATE/
+	// CATEGORY_DELETE/OVERRIDE_UPDATE/OVERRIDE_DELETE
+	Action string `json:"action"`
+	// empty for actions taken by the server, like bans expiring
+	ActorID   string                 `json:"actor_id,omitempty"`
+	TargetID  string                 `json:"target_id,omitempty"`
+	Before    map[string]interface{} `json:"before,omitempty"`
+	After     map[string]interface{} `json:"after,omitempty"`
+	CreatedAt string                 `json:"created_at"`
+}
+
+// An active ban or mute
+type RoomSanction struct {
+	UserID string `json:"user_id"`
+	// "BAN" | "MUTE"
+	Kind   string `json:"kind"`
+	Reason string `json:"reason"`
+	// empty if the issuer deleted their account
+	IssuerID  string `json:"issuer_id,omitempty"`
+	CreatedAt string `json:"created_at"`
+	// empty for permanent sanctions
+	ExpiresAt string `json:"expires_at,omitempty"`
+}
+
+type ChannelOverride struct {
+	// "EVERYONE" | "ROLE" | "USER"
+	Type string `json:"type"`
+	// the role or user ID, empty for EVERYONE
+	TargetID string `json:"target_id"`
+	Allow    int64  `json:"allow"`
+	Deny     int64  `json:"deny"`
+}
+
+type RoomChannelCategory struct {
+	ID       string `json:"ID"`
+	Name     string `json:"name"`
+	Position int    `json:"position"`
 }
 
 type RoomMessage struct {
@@ -35,6 +137,45 @@ type RoomMessage struct {
 	AuthorID      string `json:"author_id"`
 	CreatedAt     string `json:"created_at"`
 	HasAttachment bool   `json:"has_attachment"`
+	// Only included for messages posted by incoming webhooks, author_id will be empty
+	Webhook *RoomMessageWebhook `json:"webhook,omitempty"`
+	Poll    *Poll               `json:"poll,omitempty"`
+	// Only included for messages mirrored from a followed announcement channel, author_id will be empty
+	Source *RoomMessageSource `json:"source,omitempty"`
+}
+
+// The fields are empty if the source channel or author was deleted
+type RoomMessageSource struct {
+	ChannelID   string `json:"channel_id"`
+	ChannelName string `json:"channel_name"`
+	RoomID      string `json:"room_id"`
+	RoomName    string `json:"room_name"`
+	AuthorID    string `json:"author_id"`
+}
+
+type Poll struct {
+	ID        string       `json:"ID"`
+	Multiple  bool         `json:"multiple"`
+	Anonymous bool         `json:"anonymous"`
+	ClosesAt  string       `json:"closes_at,omitempty"`
+	Closed    bool         `json:"closed"`
+	Options   []PollOption `json:"options"`
+	// option ids the user voted for
+	MyVotes []string `json:"my_votes"`
+}
+
+type PollOption struct {
+	ID      string   `json:"ID"`
+	Content string   `json:"content"`
+	Votes   int      `json:"votes"`
+	Voters  []string `json:"voters,omitempty"`
+}
+
+type RoomMessageWebhook struct {
+	// Empty if the webhook has since been revoked
+	ID        string `json:"ID"`
+	Username  string `json:"username"`
+	AvatarURL string `json:"avatar_url"`
 }
 
 type RoomChannel struct {
@@ -55,9 +196,15 @@ type Invitation struct {
 	Inviter   string `json:"inviter"`
 	Invited   string `json:"invited"`
 	CreatedAt string `json:"created_at"`
+	ExpiresAt string `json:"expires_at"`
 	RoomID    string `json:"room_id"`
 }
 
+type PendingInvitations struct {
+	Received []Invitation `json:"received"`
+	Sent     []Invitation `json:"sent"`
+}
+
 type FriendRequest struct {
 	Friender  string `json:"friender"`
 	Friended  string `json:"friended"`
@@ -82,6 +229,7 @@ type AttachmentMetadata struct {
 type Notifications struct {
 	DirectMessageNotifications []DirectMessageNotification `json:"dm_ns"`
 	RoomMessageNotifications   []RoomMessageNotification   `json:"rm_ns"`
+	GroupMessageNotifications  []GroupMessageNotification  `json:"gm_ns"`
 }
 
 type DirectMessageNotification struct {
@@ -92,3 +240,237 @@ type RoomMessageNotification struct {
 	RoomID    string `json:"room_id"`
 	ChannelID string `json:"channel_id"`
 }
+
+type GroupMessageNotification struct {
+	GroupID string `json:"group_id"`
+}
+
+type Group struct {
+	ID        string   `json:"ID"`
+	Name      string   `json:"name"`
+	OwnerID   string   `json:"owner_id"`
+	Members   []string `json:"members"`
+	CreatedAt string   `json:"created_at"`
+}
+
+type GroupMessage struct {
+	ID            string `json:"ID"`
+	Content       string `json:"content"`
+	AuthorID      string `json:"author_id"`
+	GroupID       string `json:"group_id"`
+	CreatedAt     string `json:"created_at"`
+	HasAttachment bool   `json:"has_attachment"`
+}
+
+type Bot struct {
+	ID        string `json:"ID"`
+	Username  string `json:"username"`
+	CreatedAt string `json:"created_at"`
+}
+
+// The token is only ever sent when it is created, it can't be retrieved afterwards
+type BotToken struct {
+	ID    string `json:"ID"`
+	Token string `json:"token"`
+}
+
+type RoomWebhook struct {
+	ID                  string   `json:"ID"`
+	URL                 string   `json:"url"`
+	Events              []string `json:"events"`
+	Disabled            bool     `json:"disabled"`
+	ConsecutiveFailures int      `json:"consecutive_failures"`
+	CreatedAt           string   `json:"created_at"`
+}
+
+// The secret is only ever sent when the webhook is created
+type RoomWebhookSecret struct {
+	ID     string `json:"ID"`
+	Secret string `json:"secret"`
+}
+
+type RoomWebhookDelivery struct {
+	ID         string `json:"ID"`
+	DeliveryID string `json:"delivery_id"`
+	Event      string `json:"event"`
+	Attempt    int    `json:"attempt"`
+	StatusCode int    `json:"status_code"`
+	Error      string `json:"error"`
+	Success    bool   `json:"success"`
+	CreatedAt  string `json:"created_at"`
+}
+
+type ChannelWebhook struct {
+	ID        string `json:"ID"`
+	Name      string `json:"name"`
+	CreatedAt string `json:"created_at"`
+}
+
+// The URL contains the token, so it is only sent when the webhook is created or rotated
+type ChannelWebhookURL struct {
+	ID  string `json:"ID"`
+	URL string `json:"url"`
+}
+
+// Enabled is false when the room uses the default word list
+type RoomWordFilter struct {
+	Words   []string `json:"words"`
+	Action  string   `json:"action"`
+	Enabled bool     `json:"enabled"`
+}
+
+type FilterMatchesPage struct {
+	Matches []FilterMatch `json:"matches"`
+	Count   int           `json:"count"`
+}
+
+type FilterMatch struct {
+	ID string `json:"ID"`
+	// "ROOM_MESSAGE" | "DIRECT_MESSAGE" | "GROUP_MESSAGE" | "USERNAME" | "ROOM_NAME" | "CHANNEL_NAME" | "CHANNEL_TOPIC" | "CATEGORY_NAME" | "ROLE_NAME" | "GROUP_NAME" | "JOIN_REQUEST"
+	Target   string `json:"target"`
+	TargetID string `json:"target_id"`
+	UserID   string `json:"uid"`
+	RoomID   string `json:"room_id"`
+	Stage    string `json:"stage"`
+	Rule     string `json:"rule"`
+	// "BLOCK" | "MASK" | "FLAG"
+	Action    string `json:"action"`
+	Matched   string `json:"matched"`
+	Content   string `json:"content"`
+	CreatedAt string `json:"created_at"`
+}
+
+type Export struct {
+	ID string `json:"ID"`
+	// "CHANNEL" | "CONVERSATION"
+	Kind string `json:"kind"`
+	// the channel id, or the other users id
+	TargetID string `json:"target_id"`
+	// "PENDING" | "COMPLETE" | "FAILED"
+	Status    string `json:"status"`
+	Size      int    `json:"size"`
+	CreatedAt string `json:"created_at"`
+	// empty until the export has finished
+	ExpiresAt string `json:"expires_at,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type ReportsPage struct {
+	Reports []Report `json:"reports"`
+	Count   int      `json:"count"`
+}
+
+type Report struct {
+	ID string `json:"ID"`
+	// empty if the user deleted their account
+	ReporterID     string `json:"reporter_id,omitempty"`
+	ReportedUserID string `json:"reported_user_id,omitempty"`
+	// "ROOM_MESSAGE" | "DIRECT_MESSAGE" | "USER" | "ROOM"
+	TargetType string `json:"target_type"`
+	TargetID   string `json:"target_id"`
+	Reason     string `json:"reason"`
+	// a copy of the reported message or name, taken when the report was made
+	Content string `json:"content"`
+	// "OPEN" | "CLAIMED" | "RESOLVED" | "DISMISSED"
+	Status    string `json:"status"`
+	ClaimedBy string `json:"claimed_by,omitempty"`
+	CreatedAt string `json:"created_at"`
+	// set once the report has been resolved or dismissed
+	DecidedBy      string   `json:"decided_by,omitempty"`
+	DecidedAt      string   `json:"decided_at,omitempty"`
+	DecidedActions []string `json:"decided_actions"`
+	DecisionNote   string   `json:"decision_note"`
+}
+
+type AdminUsersPage struct {
+	Users []AdminUser `json:"users"`
+	Count int         `json:"count"`
+}
+
+type AdminUser struct {
+	ID       string `json:"ID"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	Bot      bool   `json:"bot"`
+	// empty if the user isn't suspended, "infinity" for permanent suspensions
+	SuspendedUntil string `json:"suspended_until,omitempty"`
+	Online         bool   `json:"online"`
+}
+
+type AdminUserDetails struct {
+	User AdminUser `json:"user"`
+	// rooms the user owns or is a member of
+	Rooms    []AdminRoom    `json:"rooms"`
+	Sessions []AdminSession `json:"sessions"`
+	// reports made against the user, and reports made by the user
+	ReportsAgainst []Report `json:"reports_against"`
+	ReportsMade    []Report `json:"reports_made"`
+}
+
+type AdminSession struct {
+	ExpiresAt string `json:"expires_at"`
+}
+
+type AdminRoomsPage struct {
+	Rooms []AdminRoom `json:"rooms"`
+	Count int         `json:"count"`
+}
+
+type AdminRoom struct {
+	ID          string `json:"ID"`
+	Name        string `json:"name"`
+	Private     bool   `json:"private"`
+	AuthorID    string `json:"author_id"`
+	CreatedAt   string `json:"created_at"`
+	MemberCount int    `json:"member_count"`
+}
+
+type RoomInvite struct {
+	ID        string `json:"ID"`
+	Code      string `json:"code"`
+	CreatorID string `json:"creator_id,omitempty"`
+	Uses      int    `json:"uses"`
+	// 0 if the code can be used any number of times
+	MaxUses int `json:"max_uses"`
+	// empty if the code never expires
+	ExpiresAt string `json:"expires_at,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+// What someone with an invite code sees before joining
+type RoomInvitePreview struct {
+	Code        string `json:"code"`
+	RoomID      string `json:"room_id"`
+	Name        string `json:"name"`
+	HasImage    bool   `json:"has_image"`
+	MemberCount int    `json:"member_count"`
+	ExpiresAt   string `json:"expires_at,omitempty"`
+}
+
+type JoinRequest struct {
+	UserID    string `json:"user_id"`
+	Message   string `json:"message"`
+	CreatedAt string `json:"created_at"`
+}
+
+type RoomRules struct {
+	Rules     string   `json:"rules"`
+	Questions []string `json:"questions"`
+	// 0 if the room has never had rules
+	Version int `json:"version"`
+	// whether the user has accepted the current version, always true for the owner and when screening is off
+	Accepted bool `json:"accepted"`
+}
+
+type RulesAcceptancesPage struct {
+	Acceptances []RulesAcceptance `json:"acceptances"`
+	Count       int               `json:"count"`
+}
+
+type RulesAcceptance struct {
+	UserID     string   `json:"user_id"`
+	Version    int      `json:"version"`
+	Questions  []string `json:"questions"`
+	Answers    []string `json:"answers"`
+	AcceptedAt string   `json:"accepted_at"`
+}
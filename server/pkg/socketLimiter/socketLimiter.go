@@ -23,6 +23,8 @@ import (
 type SocketLimiter struct {
 	SocketEvent   chan SocketEvent
 	Configuration map[string]EventLimiterConfiguration
+	// Bots use this for every event type instead of the normal configuration
+	BotConfiguration EventLimiterConfiguration
 }
 
 type EventLimiterConfiguration struct {
@@ -37,6 +39,7 @@ type SocketEvent struct {
 	RecvChan chan error
 	Type     string
 	Conn     *websocket.Conn
+	Bot      bool
 }
 
 /* --------------- REDIS JSON --------------- */
@@ -83,6 +86,14 @@ func configure() map[string]EventLimiterConfiguration {
 	config["DIRECT_MESSAGE_DELETE"] = messageEventConfig
 	config["CONV_OPENED"] = messageEventConfig
 	config["CONV_CLOSED"] = messageEventConfig
+	config["GROUP_MESSAGE"] = messageEventConfig
+	config["GROUP_MESSAGE_UPDATE"] = messageEventConfig
+	config["GROUP_MESSAGE_DELETE"] = messageEventConfig
+	config["GROUP_OPENED"] = messageEventConfig
+	config["GROUP_CLOSED"] = messageEventConfig
+	config["GROUP_ADD_MEMBER"] = generalEventConfig
+	config["GROUP_REMOVE_MEMBER"] = generalEventConfig
+	config["GROUP_LEAVE"] = generalEventConfig
 
 	config["START_WATCHING"] = watchEventConfig
 	config["STOP_WATCHING"] = watchEventConfig
@@ -91,12 +102,19 @@ func configure() map[string]EventLimiterConfiguration {
 	config["FRIEND_REQUEST_RESPONSE"] = generalEventConfig
 	config["INVITATION"] = generalEventConfig
 	config["INVITATION_RESPONSE"] = generalEventConfig
+	config["INVITATION_CANCEL"] = generalEventConfig
 
 	config["BLOCK"] = generalEventConfig
 	config["UNBLOCK"] = generalEventConfig
 
 	config["BAN"] = generalEventConfig
 	config["UNBAN"] = generalEventConfig
+	config["KICK"] = generalEventConfig
+
+	config["ROOM_COMMANDS"] = generalEventConfig
+	config["ROOM_POLL"] = messageEventConfig
+	config["POLL_VOTE"] = generalEventConfig
+	config["POLL_RETRACT"] = generalEventConfig
 
 	config["CALL_USER"] = generalEventConfig
 	config["CALL_USER_RESPONSE"] = generalEventConfig
@@ -119,6 +137,12 @@ func Init(redisClient *redis.Client) *SocketLimiter {
 	sl := &SocketLimiter{
 		SocketEvent:   make(chan SocketEvent),
 		Configuration: config,
+		BotConfiguration: EventLimiterConfiguration{
+			Window:        time.Second * 10,
+			MaxReqs:       30,
+			BlockDuration: time.Minute * 5,
+			Message:       "Too many requests",
+		},
 	}
 	go runLimiter(redisClient, sl)
 	return sl
@@ -193,6 +217,9 @@ func socketEventRegistration(redisClient *redis.Client, sl *SocketLimiter) {
 				eventData.RecvChan <- fmt.Errorf("Limiter configuration not found for socket event type")
 				continue
 			} else {
+				if eventData.Bot {
+					config = sl.BotConfiguration
+				}
 				// check if connection has already exceeded the rate limiter
 				if data.RequestsInWindow > config.MaxReqs && data.LastRequest.Add(config.BlockDuration).Before(time.Now()) {
 					// need to set the value again so that the key doesn't expire
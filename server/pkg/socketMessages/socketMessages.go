@@ -4,11 +4,45 @@ package socketmessages
 
 // TYPE: ROOM_MESSAGE
 type RoomMessage struct {
-	ID            string `json:"ID"`
-	Content       string `json:"content"`
-	CreatedAt     string `json:"created_at"`
-	AuthorID      string `json:"author_id"`
-	HasAttachment bool   `json:"has_attachment"`
+	ID            string              `json:"ID"`
+	Content       string              `json:"content"`
+	CreatedAt     string              `json:"created_at"`
+	AuthorID      string              `json:"author_id"`
+	HasAttachment bool                `json:"has_attachment"`
+	Webhook       *RoomMessageWebhook `json:"webhook,omitempty"`
+	Poll          *Poll               `json:"poll,omitempty"`
+	Source        *RoomMessageSource  `json:"source,omitempty"`
+}
+type RoomMessageWebhook struct {
+	ID        string `json:"ID"`
+	Username  string `json:"username"`
+	AvatarURL string `json:"avatar_url"`
+}
+type RoomMessageSource struct {
+	ChannelID   string `json:"channel_id"`
+	ChannelName string `json:"channel_name"`
+	RoomID      string `json:"room_id"`
+	RoomName    string `json:"room_name"`
+	AuthorID    string `json:"author_id"`
+}
+
+// TYPE: POLL_TALLY
+// Also included in ROOM_MESSAGE for poll messages
+type Poll struct {
+	ID        string       `json:"ID"`
+	MessageID string       `json:"msg_id"`
+	Multiple  bool         `json:"multiple"`
+	Anonymous bool         `json:"anonymous"`
+	ClosesAt  string       `json:"closes_at,omitempty"`
+	Closed    bool         `json:"closed"`
+	Options   []PollOption `json:"options"`
+}
+type PollOption struct {
+	ID      string `json:"ID"`
+	Content string `json:"content"`
+	Votes   int    `json:"votes"`
+	// uids of the voters, left out for anonymous polls
+	Voters []string `json:"voters,omitempty"`
 }
 
 // TYPE: ROOM_MESSAGE_UPDATE
@@ -32,6 +66,46 @@ type RoomMessageNotify struct {
 type Ban struct {
 	UserID string `json:"user_id"`
 	RoomID string `json:"room_id"`
+	Reason string `json:"reason"`
+	// empty for permanent bans
+	ExpiresAt string `json:"expires_at,omitempty"`
+}
+
+// TYPE: KICKED
+type Kicked struct {
+	RoomID string `json:"room_id"`
+}
+
+// TYPE: UNBAN
+type Unban struct {
+	UserID string `json:"user_id"`
+	RoomID string `json:"room_id"`
+	// "BAN" | "MUTE"
+	Kind string `json:"kind"`
+}
+
+// TYPE: ROOM_COMMANDS
+type RoomCommands struct {
+	Commands []RoomCommand `json:"commands"`
+}
+type RoomCommand struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Args        []RoomCommandArg `json:"args"`
+	// the room permission needed to use the command, 0 if anyone can use it
+	Permission int64 `json:"permission"`
+}
+type RoomCommandArg struct {
+	Name string `json:"name"`
+	// USER/TEXT/DICE
+	Type     string `json:"type"`
+	Required bool   `json:"required"`
+}
+
+// TYPE: ROOM_COMMAND_REPLY
+type RoomCommandReply struct {
+	ChannelID string `json:"channel_id"`
+	Content   string `json:"content"`
 }
 
 // TYPE: DIRECT_MESSAGE
@@ -64,6 +138,34 @@ type DirectMessageNotify struct {
 	Uid string `json:"uid"`
 }
 
+// TYPE: GROUP_MESSAGE
+type GroupMessage struct {
+	ID            string `json:"ID"`
+	Content       string `json:"content"`
+	CreatedAt     string `json:"created_at"`
+	AuthorID      string `json:"author_id"`
+	GroupID       string `json:"group_id"`
+	HasAttachment bool   `json:"has_attachment"`
+}
+
+// TYPE: GROUP_MESSAGE_UPDATE
+type GroupMessageUpdate struct {
+	ID      string `json:"ID"`
+	Content string `json:"content"`
+	GroupID string `json:"group_id"`
+}
+
+// TYPE: GROUP_MESSAGE_DELETE
+type GroupMessageDelete struct {
+	ID      string `json:"ID"`
+	GroupID string `json:"group_id"`
+}
+
+// TYPE: GROUP_MESSAGE_NOTIFY/GROUP_MESSAGE_NOTIFY_DELETE
+type GroupMessageNotify struct {
+	GroupID string `json:"group_id"`
+}
+
 // TYPE: FRIEND_REQUEST
 type FriendRequest struct {
 	Friender  string `json:"friender"`
@@ -99,6 +201,17 @@ type Invitation struct {
 	Invited   string `json:"invited"`
 	RoomID    string `json:"room_id"`
 	CreatedAt string `json:"created_at"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// TYPE: INVITATION_DELETE
+// Sent to the inviter and the invited user when an invitation is cancelled or expires
+type InvitationDelete struct {
+	Inviter string `json:"inviter"`
+	Invited string `json:"invited"`
+	RoomID  string `json:"room_id"`
+	// "CANCELLED" | "EXPIRED"
+	Reason string `json:"reason"`
 }
 
 // TYPE: INVITATION_RESPONSE
@@ -109,6 +222,22 @@ type InvitationResponse struct {
 	RoomID   string `json:"room_id"`
 }
 
+// TYPE: JOIN_REQUEST
+// Sent to the room owner when someone asks to join their private room
+type JoinRequest struct {
+	RoomID    string `json:"room_id"`
+	UserID    string `json:"user_id"`
+	Message   string `json:"message"`
+	CreatedAt string `json:"created_at"`
+}
+
+// TYPE: JOIN_REQUEST_RESPONSE
+type JoinRequestResponse struct {
+	RoomID   string `json:"room_id"`
+	UserID   string `json:"user_id"`
+	Accepted bool   `json:"accepted"`
+}
+
 // TYPE: CALL_USER_RESPONSE
 type CallResponse struct {
 	Called string `json:"called"`
@@ -211,3 +340,18 @@ type AttachmentMetadataCreated struct {
 	Name string `json:"name"`
 	ID   string `json:"ID"`
 }
+
+// TYPE: EXPORT_COMPLETE
+type ExportComplete struct {
+	ID     string `json:"ID"`
+	Failed bool   `json:"failed"`
+	// where the zip can be downloaded from, only works for the user who requested the export
+	URL string `json:"url"`
+}
+
+// TYPE: SYSTEM_ANNOUNCEMENT
+// Sent to every connected socket by an admin
+type SystemAnnouncement struct {
+	Content   string `json:"content"`
+	CreatedAt string `json:"created_at"`
+}
@@ -37,6 +37,7 @@ type SocketServer struct {
 
 	SendDataToUser  chan UserMessageData
 	SendDataToUsers chan UsersMessageData
+	SendDataToAll   chan AllMessageData
 
 	JoinSubscriptionByWs  chan RegisterUnregisterSubsConnWs
 	LeaveSubscriptionByWs chan RegisterUnregisterSubsConnWs
@@ -112,6 +113,11 @@ type UsersMessageData struct {
 	MessageType string
 }
 
+type AllMessageData struct {
+	Data        interface{}
+	MessageType string
+}
+
 type ConnMessageData struct {
 	Data        interface{}
 	Conn        *websocket.Conn
@@ -162,6 +168,7 @@ func Init(csdc chan string, cRTCsdc chan string, udlcdc chan string, udludc chan
 
 		SendDataToUser:  make(chan UserMessageData),
 		SendDataToUsers: make(chan UsersMessageData),
+		SendDataToAll:   make(chan AllMessageData),
 
 		JoinSubscriptionByWs:  make(chan RegisterUnregisterSubsConnWs),
 		LeaveSubscriptionByWs: make(chan RegisterUnregisterSubsConnWs),
@@ -185,6 +192,7 @@ func runServer(ss *SocketServer, csdc chan string, cRTCsdc chan string, udlcdc c
 	go messageLoop(ss)
 	go sendUserData(ss)
 	go sendUsersData(ss)
+	go sendAllData(ss)
 	go joinSubsByWs(ss)
 	go leaveSubByWs(ss)
 	go sendSubData(ss)
@@ -364,6 +372,26 @@ func sendUsersData(ss *SocketServer) {
 	}
 }
 
+// Sends to every connected socket
+func sendAllData(ss *SocketServer) {
+	for {
+		data := <-ss.SendDataToAll
+
+		ss.ConnectionsByID.mutex.RLock()
+
+		conns := []*websocket.Conn{}
+		for _, c := range ss.ConnectionsByID.data {
+			conns = append(conns, c)
+		}
+
+		ss.ConnectionsByID.mutex.RUnlock()
+
+		for _, c := range conns {
+			WriteMessage(data.MessageType, data.Data, c, ss)
+		}
+	}
+}
+
 func joinSubsByWs(ss *SocketServer) {
 	for {
 		data := <-ss.JoinSubscriptionByWs
@@ -437,11 +465,18 @@ func sendSubsData(ss *SocketServer) {
 
 		ss.Subscriptions.mutex.RLock()
 
+		// users can be in more than one of the subscriptions, only send the message to them once
+		sent := make(map[string]struct{})
+
 		for _, subName := range data.SubNames {
 			if uids, ok := ss.Subscriptions.data[subName]; ok {
 				ss.ConnectionsByID.mutex.RLock()
 
 				for uid := range uids {
+					if _, ok := sent[uid]; ok {
+						continue
+					}
+					sent[uid] = struct{}{}
 					WriteMessage(data.MessageType, data.Data, ss.ConnectionsByID.data[uid], ss)
 				}
 
@@ -489,8 +524,11 @@ func getSubscriptionUids(ss *SocketServer) {
 
 		out := make(map[string]struct{})
 
+		// copied so that the map isn't read by the caller while it's being modified
 		if uids, ok := ss.Subscriptions.data[data.SubName]; ok {
-			out = uids
+			for uid := range uids {
+				out[uid] = struct{}{}
+			}
 		}
 
 		ss.Subscriptions.mutex.RUnlock()
@@ -17,6 +17,28 @@ type RoomMessage struct {
 	HasAttachment bool   `json:"has_attachment"`
 }
 
+// ROOM_POLL
+type RoomPoll struct {
+	ChannelID string   `json:"channel_id" validate:"required,lte=36"`
+	Question  string   `json:"question" validate:"required,lte=200"`
+	Options   []string `json:"options" validate:"required,min=2,max=10,dive,required,lte=100"`
+	Multiple  bool     `json:"multiple"`
+	Anonymous bool     `json:"anonymous"`
+	// RFC3339, optional
+	ClosesAt string `json:"closes_at" validate:"lte=40"`
+}
+
+// POLL_VOTE
+type PollVote struct {
+	PollID    string   `json:"poll_id" validate:"required,lte=36"`
+	OptionIDs []string `json:"option_ids" validate:"required,min=1,max=10,dive,required,lte=36"`
+}
+
+// POLL_RETRACT
+type PollRetract struct {
+	PollID string `json:"poll_id" validate:"required,lte=36"`
+}
+
 // ROOM_MESSAGE_UPDATE
 type RoomMessageUpdate struct {
 	Content string `json:"content" validate:"required,lte=200"`
@@ -68,16 +90,51 @@ type FriendRequestResponse struct {
 	Accepted bool   `json:"accepted"`
 }
 
+// GROUP_MESSAGE
+type GroupMessage struct {
+	Content       string `json:"content" validate:"required,lte=200"`
+	GroupID       string `json:"group_id" validate:"required,lte=36"`
+	HasAttachment bool   `json:"has_attachment"`
+}
+
+// GROUP_MESSAGE_UPDATE
+type GroupMessageUpdate struct {
+	Content string `json:"content" validate:"required,lte=200"`
+	MsgID   string `json:"msg_id" validate:"required,lte=36"`
+}
+
+// GROUP_MESSAGE_DELETE
+type GroupMessageDelete struct {
+	MsgID string `json:"msg_id" validate:"required,lte=36"`
+}
+
+// GROUP_OPENED/GROUP_CLOSED/GROUP_LEAVE
+type GroupOpenedClosedLeave struct {
+	GroupID string `json:"group_id" validate:"required,lte=36"`
+}
+
+// GROUP_ADD_MEMBER/GROUP_REMOVE_MEMBER
+type GroupAddRemoveMember struct {
+	GroupID string `json:"group_id" validate:"required,lte=36"`
+	Uid     string `json:"uid" validate:"required,lte=36"`
+}
+
 // INVITATION
 type Invitation struct {
-	RoomID string `json:"room_id" validation:"required,lte=36"`
-	Uid    string `json:"uid" validation:"required,lte=36"`
+	RoomID string `json:"room_id" validate:"required,lte=36"`
+	Uid    string `json:"uid" validate:"required,lte=36"`
 }
 
-// INVITATION_RESPONSE
+// INVITATION_CANCEL
+type InvitationCancel struct {
+	RoomID string `json:"room_id" validate:"required,lte=36"`
+	Uid    string `json:"uid" validate:"required,lte=36"`
+}
+
+// INVITATION_RESPONSE. Inviter is ignored, there is only one invitation per room and user
 type InvitationResponse struct {
-	Inviter  string `json:"inviter" validation:"required,lte=36"`
-	RoomID   string `json:"room_id" validation:"required,lte=36"`
+	Inviter  string `json:"inviter" validate:"lte=36"`
+	RoomID   string `json:"room_id" validate:"required,lte=36"`
 	Accepted bool   `json:"accepted"`
 }
 
@@ -88,10 +145,23 @@ type BlockUnBlock struct {
 
 // BAN/UNBAN
 type BanUnban struct {
-	Uid    string `json:"uid" validation:"required,lte=36"`
-	RoomID string `json:"room_id" validation:"required,lte=36"`
+	Uid    string `json:"uid" validate:"required,lte=36"`
+	RoomID string `json:"room_id" validate:"required,lte=36"`
+	// ignored when unbanning
+	Reason string `json:"reason" validate:"lte=200"`
+	// in seconds, 0 for a permanent ban, up to a year. Ignored when unbanning
+	Duration int `json:"duration" validate:"gte=0,lte=31536000"`
 }
 
+// KICK
+type Kick struct {
+	Uid    string `json:"uid" validate:"required,lte=36"`
+	RoomID string `json:"room_id" validate:"required,lte=36"`
+}
+
+// ROOM_COMMANDS
+type RoomCommands struct{}
+
 // CALL_USER
 type CallUser struct {
 	Uid string `json:"uid" validation:"required,lte=36"`
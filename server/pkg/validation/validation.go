@@ -11,6 +11,10 @@ type Login struct {
 	Password string `json:"password" validate:"required,gte=8,lte=72"`
 }
 
+type CreateBot struct {
+	Username string `json:"username" validate:"required,gte=2,lte=16"`
+}
+
 type CreateUpdateRoom struct {
 	Name    string `json:"name" validate:"required,gte=2,lte=16"`
 	Private bool   `json:"private"`
@@ -35,6 +39,14 @@ type Bio struct {
 type CreateUpdateChannel struct {
 	Name string `json:"name" validate:"required,lte=16,gte=2"`
 	Main bool   `json:"main"`
+	// seconds between messages, 0 turns slow mode off
+	SlowMode int `json:"slow_mode" validate:"gte=0,lte=21600"`
+	// "TEXT" | "ANNOUNCEMENT", empty is TEXT
+	Type string `json:"type" validate:"omitempty,oneof=TEXT ANNOUNCEMENT"`
+	// null leaves the topic unchanged
+	Topic *string `json:"topic" validate:"omitempty,lte=100"`
+	// null leaves the category unchanged, empty removes the channel from its category
+	CategoryID *string `json:"category_id" validate:"omitempty,lte=36"`
 }
 
 type CreateAttachmentMetadata struct {
@@ -43,3 +55,144 @@ type CreateAttachmentMetadata struct {
 	Size int    `json:"size"`
 	ID   string `json:"msg_id"`
 }
+
+type CreateUpdateRoomWebhook struct {
+	URL    string   `json:"url" validate:"required,url,lte=2048"`
+	Events []string `json:"events" validate:"required,gte=1,lte=6,dive,oneof=MESSAGE_CREATED MESSAGE_UPDATED MESSAGE_DELETED MEMBER_JOINED BAN CHANNEL_CREATED"`
+}
+
+type CreateChannelWebhook struct {
+	Name string `json:"name" validate:"required,gte=2,lte=16"`
+}
+
+type IncomingWebhookMessage struct {
+	Content   string `json:"content" validate:"required,lte=200"`
+	Username  string `json:"username" validate:"omitempty,gte=2,lte=16"`
+	AvatarURL string `json:"avatar_url" validate:"omitempty,url,lte=2048"`
+}
+
+type CreateGroup struct {
+	Name string   `json:"name" validate:"lte=24"`
+	Uids []string `json:"uids" validate:"required,gte=1,lte=9,dive,required,lte=36"`
+}
+
+type UpdateGroup struct {
+	Name string `json:"name" validate:"lte=24"`
+}
+
+type CreateBookmark struct {
+	MsgID string `json:"msg_id" validate:"required,lte=36"`
+	Note  string `json:"note" validate:"lte=200"`
+}
+
+type UpdateBookmark struct {
+	Note string `json:"note" validate:"lte=200"`
+}
+
+type UpdateRoomWordFilter struct {
+	Enabled bool     `json:"enabled"`
+	Words   []string `json:"words" validate:"lte=200,dive,required,lte=32"`
+	Action  string   `json:"action" validate:"omitempty,oneof=BLOCK MASK FLAG"`
+}
+
+// A null number of days keeps messages forever, or uses the rooms retention for channels
+type UpdateRetention struct {
+	Days *int `json:"days" validate:"omitempty,gte=0,lte=3650"`
+}
+
+// The channel the followed announcement channels posts are mirrored to
+type FollowChannel struct {
+	ChannelID string `json:"channel_id" validate:"required,lte=36"`
+}
+
+type CreateUpdateChannelCategory struct {
+	Name string `json:"name" validate:"required,lte=24"`
+}
+
+// Categories and channels are listed in the order they should be displayed.
+// Every category and channel in the room must be included
+type UpdateChannelOrder struct {
+	Categories []string                 `json:"categories" validate:"lte=50,dive,required,lte=36"`
+	Channels   []UpdateChannelOrderItem `json:"channels" validate:"required,gte=1,lte=200,dive"`
+}
+
+type UpdateChannelOrderItem struct {
+	ID string `json:"ID" validate:"required,lte=36"`
+	// empty if the channel isn't in a category
+	CategoryID string `json:"category_id" validate:"lte=36"`
+}
+
+// Permissions is a bitset of roomPermissions
+type CreateUpdateRoomRole struct {
+	Name        string `json:"name" validate:"required,lte=24"`
+	Permissions int64  `json:"permissions" validate:"gte=0,lte=127"`
+}
+
+// Allow and Deny are bitsets of roomPermissions, the same permission can't be in both
+type UpdateChannelOverride struct {
+	Allow int64 `json:"allow" validate:"gte=0,lte=511"`
+	Deny  int64 `json:"deny" validate:"gte=0,lte=511"`
+}
+
+type CreateReport struct {
+	TargetType string `json:"target_type" validate:"required,oneof=ROOM_MESSAGE DIRECT_MESSAGE USER ROOM"`
+	TargetID   string `json:"target_id" validate:"required,lte=36"`
+	Reason     string `json:"reason" validate:"required,lte=300"`
+}
+
+// Actions are DELETE_CONTENT, SUSPEND_USER and DELETE_ROOM. SuspendDays is used
+// with SUSPEND_USER, 0 suspends the user permanently
+type ResolveReport struct {
+	Actions     []string `json:"actions" validate:"lte=3,dive,oneof=DELETE_CONTENT SUSPEND_USER DELETE_ROOM"`
+	SuspendDays int      `json:"suspend_days" validate:"gte=0,lte=365"`
+	Note        string   `json:"note" validate:"lte=300"`
+}
+
+type DismissReport struct {
+	Note string `json:"note" validate:"lte=300"`
+}
+
+// Days 0 is a permanent suspension
+type SuspendUser struct {
+	Days int `json:"days" validate:"gte=0,lte=365"`
+}
+
+type SystemAnnouncement struct {
+	Content string `json:"content" validate:"required,lte=500"`
+}
+
+// MaxUses 0 is unlimited, ExpiresIn is in seconds and 0 never expires (up to 30 days)
+type CreateRoomInvite struct {
+	MaxUses   int `json:"max_uses" validate:"gte=0,lte=1000"`
+	ExpiresIn int `json:"expires_in" validate:"gte=0,lte=2592000"`
+}
+
+type TransferRoomOwnership struct {
+	Uid string `json:"uid" validate:"required,lte=36"`
+}
+
+// An empty SuccessorID hands the room to the longest standing member
+type UpdateRoomSuccession struct {
+	Succession  bool   `json:"succession"`
+	SuccessorID string `json:"successor_id" validate:"lte=36"`
+}
+
+type CreateJoinRequest struct {
+	Message string `json:"message" validate:"lte=200"`
+}
+
+type JoinRequestResponse struct {
+	Accepted bool `json:"accepted"`
+}
+
+// Empty rules with no questions turns screening off
+type UpdateRoomRules struct {
+	Rules     string   `json:"rules" validate:"lte=2000"`
+	Questions []string `json:"questions" validate:"lte=5,dive,lte=200"`
+}
+
+// Version is the version of the rules that were accepted, one answer for each question
+type AcceptRoomRules struct {
+	Version int      `json:"version" validate:"required,gte=1"`
+	Answers []string `json:"answers" validate:"lte=5,dive,lte=500"`
+}
@@ -0,0 +1,305 @@
+package webhookserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+/*
+	This is for outgoing webhooks. Room owners subscribe a URL to
+	some of the rooms events, and whenever one of those events happens
+	the JSON payload is POSTed to the URL, signed with the webhooks
+	secret (HMAC-SHA256 of the body in the X-Signature-256 header).
+
+	Deliveries are retried with exponential backoff. Every attempt is
+	written to room_webhook_deliveries. If deliveries keep failing the
+	webhook gets disabled until the owner updates it.
+
+	Events and deliveries are handled by a fixed number of workers reading
+	from bounded queues, so a busy room can't start an unbounded number of
+	goroutines. Anything that doesn't fit in a queue is dropped.
+
+	Webhooks can't be delivered to loopback, private or link-local
+	addresses, otherwise room owners could use the delivery log to probe
+	the servers network. The address is checked when dialing, after DNS
+	resolution. Set WEBHOOK_ALLOW_PRIVATE_HOSTS=true to allow them, for
+	testing with a local receiver.
+*/
+
+const (
+	maxAttempts = 5
+	// the delay before the first retry, doubled after every attempt
+	initialBackoff = time.Second * 2
+	// the webhook is disabled after this many deliveries fail in a row (after retries)
+	maxConsecutiveFailures = 5
+	requestTimeout         = time.Second * 10
+	// events are dropped if this many are waiting to be dispatched, so that room events never wait on webhooks
+	dispatchQueueSize = 256
+	// deliveries are dropped if this many are waiting for a delivery worker
+	deliveryQueueSize = 1024
+	dispatchWorkers   = 4
+	// each delivery holds its worker for up to maxAttempts requests and the backoff between them
+	deliveryWorkers = 32
+)
+
+// Events room webhooks can subscribe to
+var Events = []string{
+	"MESSAGE_CREATED",
+	"MESSAGE_UPDATED",
+	"MESSAGE_DELETED",
+	"MEMBER_JOINED",
+	"BAN",
+	"CHANNEL_CREATED",
+}
+
+type WebhookServer struct {
+	// Channel for sending a room event to the rooms webhooks. Buffered, sends should not block
+	DispatchEvent chan RoomEvent
+
+	deliveries chan delivery
+}
+
+/* --------------- MODELS --------------- */
+type RoomEvent struct {
+	RoomID string
+	// MESSAGE_CREATED/MESSAGE_UPDATED/MESSAGE_DELETED/MEMBER_JOINED/BAN/CHANNEL_CREATED
+	Event string
+	Data  map[string]interface{}
+}
+
+// The JSON body POSTed to the webhook URL
+type Payload struct {
+	DeliveryID string                 `json:"delivery_id"`
+	Event      string                 `json:"event"`
+	RoomID     string                 `json:"room_id"`
+	Timestamp  string                 `json:"timestamp"`
+	Data       map[string]interface{} `json:"data"`
+}
+
+type webhook struct {
+	id     string
+	url    string
+	secret string
+}
+
+type delivery struct {
+	webhook webhook
+	payload Payload
+}
+
+func Init(db *pgxpool.Pool) *WebhookServer {
+	ws := &WebhookServer{
+		DispatchEvent: make(chan RoomEvent, dispatchQueueSize),
+		deliveries:    make(chan delivery, deliveryQueueSize),
+	}
+	runServer(ws, db)
+	return ws
+}
+
+// Returns true if webhooks may be delivered to loopback, private and link-local addresses
+func AllowPrivateHosts() bool {
+	return os.Getenv("WEBHOOK_ALLOW_PRIVATE_HOSTS") == "true"
+}
+
+// Returns true if webhooks can't be delivered to the address
+func IsBlockedIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast()
+}
+
+// Refuses connections to blocked addresses. Runs after DNS resolution, so hostnames that resolve to
+// a blocked address are refused too
+func dialControl(network string, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || IsBlockedIP(ip) {
+		return fmt.Errorf("Webhook address %v is not allowed", host)
+	}
+	return nil
+}
+
+func newClient() *http.Client {
+	if AllowPrivateHosts() {
+		return &http.Client{Timeout: requestTimeout}
+	}
+	dialer := &net.Dialer{
+		Timeout: requestTimeout,
+		Control: dialControl,
+	}
+	return &http.Client{
+		Timeout: requestTimeout,
+		// no proxy, the proxy would be dialed instead of the webhook host
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: requestTimeout,
+		},
+	}
+}
+
+func runServer(ws *WebhookServer, db *pgxpool.Pool) {
+	client := newClient()
+
+	for i := 0; i < dispatchWorkers; i++ {
+		go dispatchEvents(ws, db)
+	}
+	for i := 0; i < deliveryWorkers; i++ {
+		go deliverEvents(ws, client, db)
+	}
+}
+
+// Signs the body with the webhooks secret. Receivers should compute the same thing and compare.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func dispatchEvents(ws *WebhookServer, db *pgxpool.Pool) {
+	for {
+		dispatchEvent(ws, db, <-ws.DispatchEvent)
+	}
+}
+
+func deliverEvents(ws *WebhookServer, client *http.Client, db *pgxpool.Pool) {
+	for {
+		d := <-ws.deliveries
+		deliver(client, db, d.webhook, d.payload)
+	}
+}
+
+// Looks up the rooms webhooks subscribed to the event and queues a delivery to each of them
+func dispatchEvent(ws *WebhookServer, db *pgxpool.Pool, event RoomEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	rows, err := db.Query(ctx, `
+	SELECT id,url,secret FROM room_webhooks WHERE room_id = $1 AND $2 = ANY(events) AND disabled = FALSE;
+	`, event.RoomID, event.Event)
+	if err != nil {
+		log.Println("Error selecting webhooks in webhook server:", err)
+		return
+	}
+	defer rows.Close()
+
+	webhooks := []webhook{}
+	for rows.Next() {
+		var w webhook
+		if err = rows.Scan(&w.id, &w.url, &w.secret); err != nil {
+			log.Println("Error scanning webhook in webhook server:", err)
+			return
+		}
+		webhooks = append(webhooks, w)
+	}
+
+	for _, w := range webhooks {
+		payload := Payload{
+			DeliveryID: uuid.New().String(),
+			Event:      event.Event,
+			RoomID:     event.RoomID,
+			Timestamp:  time.Now().Format(time.RFC3339),
+			Data:       event.Data,
+		}
+		select {
+		case ws.deliveries <- delivery{webhook: w, payload: payload}:
+		default:
+			log.Printf("Webhook delivery queue full, dropped %v delivery for webhook %v\n", event.Event, w.id)
+		}
+	}
+}
+
+// Sends the payload, retrying with exponential backoff. Runs on a delivery worker
+func deliver(client *http.Client, db *pgxpool.Pool, w webhook, payload Payload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Println("Error marshalling webhook payload:", err)
+		return
+	}
+	signature := Sign(w.secret, body)
+
+	backoff := initialBackoff
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		statusCode, err := post(client, w.url, body, signature, payload)
+
+		success := err == nil && statusCode >= 200 && statusCode < 300
+		errMsg := ""
+		if err != nil {
+			errMsg = err.Error()
+		} else if !success {
+			errMsg = fmt.Sprintf("Unexpected status code %v", statusCode)
+		}
+		if len(errMsg) > 200 {
+			errMsg = errMsg[:200]
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+		if _, err := db.Exec(ctx, `
+		INSERT INTO room_webhook_deliveries (webhook_id,delivery_id,event,attempt,status_code,error,success) VALUES($1,$2,$3,$4,$5,$6,$7);
+		`, w.id, payload.DeliveryID, payload.Event, attempt, statusCode, errMsg, success); err != nil {
+			log.Println("Error inserting webhook delivery:", err)
+		}
+
+		if success {
+			if _, err := db.Exec(ctx, `
+			UPDATE room_webhooks SET consecutive_failures = 0 WHERE id = $1;
+			`, w.id); err != nil {
+				log.Println("Error resetting webhook failures:", err)
+			}
+			cancel()
+			return
+		}
+		cancel()
+
+		if attempt < maxAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*8)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, `
+	UPDATE room_webhooks SET consecutive_failures = consecutive_failures + 1,
+	disabled = consecutive_failures + 1 >= $2 WHERE id = $1;
+	`, w.id, maxConsecutiveFailures); err != nil {
+		log.Println("Error updating webhook failures:", err)
+	}
+}
+
+func post(client *http.Client, url string, body []byte, signature string, payload Payload) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "psql-social-webhooks")
+	req.Header.Set("X-Signature-256", signature)
+	req.Header.Set("X-Webhook-Event", payload.Event)
+	req.Header.Set("X-Webhook-Delivery", payload.DeliveryID)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	return res.StatusCode, nil
+}
@@ -8,11 +8,23 @@ CREATE TABLE users (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
     username VARCHAR(16) UNIQUE NOT NULL,
     password VARCHAR(72) NOT NULL,
-    /* "ADMIN" | "USER" , role exists but its not used for anything*/
+    /* "ADMIN" | "USER". Admins review reports, see handlers/report.go */
     role VARCHAR(5) NOT NULL,
     friends UUID [] DEFAULT '{}' :: UUID [],
     blocked UUID [] DEFAULT '{}' :: UUID [],
-    seeded BOOLEAN NOT NULL DEFAULT FALSE
+    seeded BOOLEAN NOT NULL DEFAULT FALSE,
+    /* bots are created by a user, and get deleted along with them */
+    bot BOOLEAN NOT NULL DEFAULT FALSE,
+    owner_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    /* suspended users can't log in. Null if the user isn't suspended, infinity for permanent suspensions */
+    suspended_until TIMESTAMPTZ
+);
+
+/* bots authenticate with a token instead of a session. Only the sha256 hash of the token is stored */
+CREATE TABLE bot_tokens (
+    bot_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 
 CREATE TABLE friends (
@@ -46,7 +58,16 @@ CREATE TABLE rooms (
     private BOOLEAN NOT NULL,
     author_id UUID REFERENCES users(id) ON DELETE CASCADE,
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-    seeded BOOLEAN NOT NULL DEFAULT FALSE
+    seeded BOOLEAN NOT NULL DEFAULT FALSE,
+    /* messages older than this many days are purged, null keeps messages forever */
+    retention_days INT,
+    /*
+        when the owner is deleted the room is handed to successor_id if they are still a member,
+        otherwise to the longest standing member. If succession is disabled, or there is nobody
+        to hand it to, the room is deleted along with the owner
+    */
+    succession BOOLEAN NOT NULL DEFAULT TRUE,
+    successor_id UUID REFERENCES users(id) ON DELETE SET NULL
 );
 
 /* Mime kept here incase I want to store images as pngs with transparency */
@@ -57,28 +78,171 @@ CREATE TABLE room_pictures (
     picture_data BYTEA NOT NULL
 );
 
+/* a user can only have one invitation to a room at a time, whoever sent it. Expired invitations are deleted by expireInvitations in main.go */
 CREATE TABLE invitations (
     inviter UUID REFERENCES users(id) ON DELETE CASCADE,
     invited UUID REFERENCES users(id) ON DELETE CASCADE,
     room_id UUID REFERENCES rooms(id) ON DELETE CASCADE,
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-    PRIMARY KEY (inviter, invited)
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (room_id, invited)
+);
+
+/*
+    Members have to accept the rules, and answer the questions, before they can post or join channels.
+    version goes up whenever the rules or questions change, members then have to accept them again.
+    Screening is off while there are no rules and no questions. See handlers/roomRules.go
+*/
+CREATE TABLE room_rules (
+    room_id UUID PRIMARY KEY REFERENCES rooms(id) ON DELETE CASCADE,
+    rules VARCHAR(2000) NOT NULL DEFAULT '',
+    questions VARCHAR(200) [] NOT NULL DEFAULT '{}',
+    version INT NOT NULL DEFAULT 1,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+/* the questions are copied so that the answers still make sense after the questions change */
+CREATE TABLE rules_acceptances (
+    room_id UUID REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    version INT NOT NULL,
+    questions VARCHAR(200) [] NOT NULL DEFAULT '{}',
+    answers VARCHAR(500) [] NOT NULL DEFAULT '{}',
+    accepted_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (room_id, user_id)
+);
+
+/* requests to join private rooms, deleted once they have been approved or denied. See handlers/joinRequests.go */
+CREATE TABLE join_requests (
+    room_id UUID REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    message VARCHAR(200) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (room_id, user_id)
+);
+
+/*
+    Shareable invite codes, anyone with the code can join the room. Null max_uses and
+    expires_at mean the code can be used any number of times and never expires.
+    See handlers/roomInvites.go
+*/
+CREATE TABLE room_invites (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    code VARCHAR(10) UNIQUE NOT NULL,
+    room_id UUID REFERENCES rooms(id) ON DELETE CASCADE,
+    creator_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    max_uses INT,
+    uses INT NOT NULL DEFAULT 0,
+    expires_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+/* channels are listed by category position, then by channel position. Channels without a category are listed first */
+CREATE TABLE room_channel_categories (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    room_id UUID REFERENCES rooms(id) ON DELETE CASCADE,
+    name VARCHAR(24) NOT NULL,
+    position INT NOT NULL DEFAULT 0
 );
 
 CREATE TABLE room_channels (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
     name VARCHAR(16) NOT NULL,
     main BOOLEAN NOT NULL,
-    room_id UUID REFERENCES rooms(id) ON DELETE CASCADE
+    topic VARCHAR(100) NOT NULL DEFAULT '',
+    room_id UUID REFERENCES rooms(id) ON DELETE CASCADE,
+    category_id UUID REFERENCES room_channel_categories(id) ON DELETE SET NULL,
+    position INT NOT NULL DEFAULT 0,
+    /* "TEXT" | "ANNOUNCEMENT". Only users with permManageChannels in the channel can post in announcement channels */
+    type VARCHAR(12) NOT NULL DEFAULT 'TEXT',
+    /* the number of seconds users have to wait between messages, 0 is off. The room owner is exempt */
+    slow_mode INT NOT NULL DEFAULT 0,
+    /* overrides the rooms retention. null uses the rooms retention, 0 keeps messages forever */
+    retention_days INT
+);
+
+/* outgoing webhooks. events is a list of event names, see webhookServer.Events */
+CREATE TABLE room_webhooks (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    room_id UUID REFERENCES rooms(id) ON DELETE CASCADE,
+    url VARCHAR(2048) NOT NULL,
+    secret CHAR(64) NOT NULL,
+    events VARCHAR(24) [] NOT NULL,
+    disabled BOOLEAN NOT NULL DEFAULT FALSE,
+    consecutive_failures INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+/* one row per delivery attempt, delivery_id is the same for all attempts of a delivery */
+CREATE TABLE room_webhook_deliveries (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    webhook_id UUID REFERENCES room_webhooks(id) ON DELETE CASCADE,
+    delivery_id UUID NOT NULL,
+    event VARCHAR(24) NOT NULL,
+    attempt SMALLINT NOT NULL,
+    status_code INTEGER NOT NULL,
+    error VARCHAR(200) NOT NULL,
+    success BOOLEAN NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 
+/* incoming webhooks. Only the sha256 hash of the token in the webhook URL is stored */
+CREATE TABLE channel_webhooks (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    channel_id UUID REFERENCES room_channels(id) ON DELETE CASCADE,
+    name VARCHAR(16) NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+/* messages posted by incoming webhooks have no author_id, the webhook columns are set instead */
 CREATE TABLE room_messages (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
     content TEXT NOT NULL,
     author_id UUID REFERENCES users(id) ON DELETE CASCADE,
     room_channel_id UUID REFERENCES room_channels(id) ON DELETE CASCADE,
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-    has_attachment BOOLEAN NOT NULL
+    has_attachment BOOLEAN NOT NULL,
+    webhook_id UUID REFERENCES channel_webhooks(id) ON DELETE SET NULL,
+    webhook_username VARCHAR(16),
+    webhook_avatar VARCHAR(2048),
+    /* null if the message was never edited */
+    edited_at TIMESTAMPTZ,
+    /* set for messages mirrored from a followed announcement channel, there is no author_id */
+    source_channel_id UUID REFERENCES room_channels(id) ON DELETE SET NULL,
+    source_author_id UUID REFERENCES users(id) ON DELETE SET NULL
+);
+
+/* posts in the source announcement channel are mirrored to the target channel */
+CREATE TABLE channel_follows (
+    source_channel_id UUID REFERENCES room_channels(id) ON DELETE CASCADE,
+    target_channel_id UUID REFERENCES room_channels(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (source_channel_id, target_channel_id)
+);
+
+/* the question is the content of the message. closes_at is null if the poll stays open */
+CREATE TABLE polls (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    message_id UUID UNIQUE NOT NULL REFERENCES room_messages(id) ON DELETE CASCADE,
+    multiple BOOLEAN NOT NULL,
+    anonymous BOOLEAN NOT NULL,
+    closes_at TIMESTAMPTZ,
+    closed BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE poll_options (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    poll_id UUID REFERENCES polls(id) ON DELETE CASCADE,
+    content VARCHAR(100) NOT NULL,
+    position SMALLINT NOT NULL
+);
+
+CREATE TABLE poll_votes (
+    poll_id UUID REFERENCES polls(id) ON DELETE CASCADE,
+    option_id UUID REFERENCES poll_options(id) ON DELETE CASCADE,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    PRIMARY KEY (option_id, user_id)
 );
 
 CREATE TABLE direct_messages (
@@ -87,7 +251,41 @@ CREATE TABLE direct_messages (
     author_id UUID REFERENCES users(id) ON DELETE CASCADE,
     recipient_id UUID REFERENCES users(id) ON DELETE CASCADE,
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-    has_attachment BOOLEAN NOT NULL
+    has_attachment BOOLEAN NOT NULL,
+    edited_at TIMESTAMPTZ
+);
+
+/* group direct message conversations. owner_id is the user who can remove members, ownership passes to the longest standing member when the owner leaves */
+CREATE TABLE group_conversations (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name VARCHAR(24) NOT NULL DEFAULT '',
+    owner_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE group_conversation_members (
+    group_id UUID REFERENCES group_conversations(id) ON DELETE CASCADE,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    joined_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (group_id, user_id)
+);
+
+/* Mime kept here incase I want to store images as pngs with transparency */
+CREATE TABLE group_conversation_pictures (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    group_id UUID REFERENCES group_conversations(id) ON DELETE CASCADE,
+    mime CHAR(10) NOT NULL,
+    picture_data BYTEA NOT NULL
+);
+
+CREATE TABLE group_messages (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    content VARCHAR(200) NOT NULL,
+    author_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    group_id UUID REFERENCES group_conversations(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    has_attachment BOOLEAN NOT NULL,
+    edited_at TIMESTAMPTZ
 );
 
 CREATE TABLE room_message_notifications (
@@ -105,18 +303,86 @@ CREATE TABLE direct_message_notifications (
     PRIMARY KEY (user_id, message_id)
 );
 
+CREATE TABLE group_message_notifications (
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    group_id UUID REFERENCES group_conversations(id) ON DELETE CASCADE,
+    message_id UUID REFERENCES group_messages(id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, message_id)
+);
+
+/* expires_at is null for permanent bans. Expired bans are deleted by liftExpiredSanctions in main.go */
 CREATE TABLE bans (
     user_id UUID REFERENCES users(id) ON DELETE CASCADE,
     room_id UUID REFERENCES rooms(id) ON DELETE CASCADE,
+    issuer_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    reason VARCHAR(200) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ,
+    PRIMARY KEY (user_id, room_id)
+);
+
+/* Muted users can read the rooms channels but can't post or join voice */
+CREATE TABLE mutes (
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    room_id UUID REFERENCES rooms(id) ON DELETE CASCADE,
+    issuer_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    reason VARCHAR(200) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ,
     PRIMARY KEY (user_id, room_id)
 );
 
+/*
+    Append only. target_id is a user, channel, role or message id depending on the action,
+    before and after hold the changed values. See recordAuditLog in handlers/auditLog.go
+*/
+CREATE TABLE room_audit_log (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    room_id UUID REFERENCES rooms(id) ON DELETE CASCADE,
+    actor_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    action VARCHAR(16) NOT NULL,
+    target_id UUID,
+    before JSONB,
+    after JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
 CREATE TABLE members (
     user_id UUID REFERENCES users(id) ON DELETE CASCADE,
     room_id UUID REFERENCES rooms(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
     PRIMARY KEY (user_id, room_id)
 );
 
+/* permissions is a bitset, see roomPermissions in handlers/roomRoles.go. The room owner has every permission */
+CREATE TABLE room_roles (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    room_id UUID REFERENCES rooms(id) ON DELETE CASCADE,
+    name VARCHAR(24) NOT NULL,
+    permissions BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+/* roles are removed when the user is kicked or banned */
+CREATE TABLE member_roles (
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    room_id UUID REFERENCES rooms(id) ON DELETE CASCADE,
+    role_id UUID REFERENCES room_roles(id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);
+
+/* allows or denies permissions in a channel for a user, a role, or everyone in the room if user_id and role_id are both null.
+The override for everyone is applied first, then role overrides, then the users override */
+CREATE TABLE channel_overrides (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    channel_id UUID REFERENCES room_channels(id) ON DELETE CASCADE,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    role_id UUID REFERENCES room_roles(id) ON DELETE CASCADE,
+    allow BIGINT NOT NULL DEFAULT 0,
+    deny BIGINT NOT NULL DEFAULT 0,
+    CHECK (user_id IS NULL OR role_id IS NULL)
+);
+
 CREATE TABLE direct_message_attachment_chunks (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
     bytes BYTEA NOT NULL,
@@ -151,6 +417,104 @@ CREATE TABLE room_message_attachment_metadata (
     message_id UUID REFERENCES room_messages(id) ON DELETE CASCADE
 );
 
+CREATE TABLE group_message_attachment_chunks (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    bytes BYTEA NOT NULL,
+    message_id UUID REFERENCES group_messages(id) ON DELETE CASCADE,
+    chunk_index INT NOT NULL
+);
+
+CREATE TABLE group_message_attachment_metadata (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    meta VARCHAR(128) NOT NULL,
+    name VARCHAR(200) NOT NULL,
+    size INT NOT NULL,
+    failed BOOLEAN NOT NULL,
+    ratio FLOAT NOT NULL,
+    message_id UUID REFERENCES group_messages(id) ON DELETE CASCADE
+);
+
+/* kind is "ROOM" | "DIRECT" | "GROUP". message_id has no foreign key so the bookmark is
+kept when the message is deleted, it is marked unavailable instead */
+CREATE TABLE bookmarks (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(6) NOT NULL,
+    message_id UUID NOT NULL,
+    note VARCHAR(200) NOT NULL DEFAULT '',
+    unavailable BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, message_id)
+);
+
+/* replaces the default word list of the content filter for messages in the room. action is "BLOCK" | "MASK" | "FLAG" */
+CREATE TABLE room_word_filters (
+    room_id UUID PRIMARY KEY REFERENCES rooms(id) ON DELETE CASCADE,
+    words VARCHAR(32) [] NOT NULL DEFAULT '{}' :: VARCHAR(32) [],
+    action VARCHAR(5) NOT NULL
+);
+
+/* target is "ROOM_MESSAGE" | "DIRECT_MESSAGE" | "GROUP_MESSAGE" | "USERNAME" | "ROOM_NAME" | "CHANNEL_NAME" | "CHANNEL_TOPIC"
+| "CATEGORY_NAME" | "ROLE_NAME" | "GROUP_NAME" | "JOIN_REQUEST". target_id has no foreign key,
+it is null when the content was blocked. content is the content before it was masked */
+CREATE TABLE content_filter_matches (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    target VARCHAR(14) NOT NULL,
+    target_id UUID,
+    room_id UUID REFERENCES rooms(id) ON DELETE CASCADE,
+    stage VARCHAR(14) NOT NULL,
+    rule VARCHAR(64) NOT NULL,
+    action VARCHAR(5) NOT NULL,
+    matched TEXT NOT NULL,
+    content TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+/* exports of a room channel or a conversation. kind is "CHANNEL" | "CONVERSATION", target_id is the channel id
+or the other users id. status is "PENDING" | "COMPLETE" | "FAILED". The zip is stored in export_chunks */
+CREATE TABLE exports (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(12) NOT NULL,
+    target_id UUID NOT NULL,
+    status VARCHAR(8) NOT NULL DEFAULT 'PENDING',
+    size INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ
+);
+
+CREATE TABLE export_chunks (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    bytes BYTEA NOT NULL,
+    export_id UUID REFERENCES exports(id) ON DELETE CASCADE,
+    chunk_index INT NOT NULL
+);
+
+/*
+    target_type is "ROOM_MESSAGE" | "DIRECT_MESSAGE" | "USER" | "ROOM". target_id has no foreign key so that
+    reports are kept after the content is deleted, content is a copy of the reported message or name.
+    reported_user_id is the author of the message, the user, or the owner of the room.
+    status is "OPEN" | "CLAIMED" | "RESOLVED" | "DISMISSED", the decided_ columns are set when the report is closed
+*/
+CREATE TABLE reports (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    reporter_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    reported_user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    target_type VARCHAR(14) NOT NULL,
+    target_id UUID NOT NULL,
+    reason VARCHAR(300) NOT NULL,
+    content TEXT NOT NULL DEFAULT '',
+    status VARCHAR(9) NOT NULL DEFAULT 'OPEN',
+    claimed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    decided_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    decided_at TIMESTAMPTZ,
+    /* the actions taken when resolving the report, see validation.ResolveReport */
+    decided_actions VARCHAR(16) [] NOT NULL DEFAULT '{}' :: VARCHAR(16) [],
+    decision_note VARCHAR(300) NOT NULL DEFAULT ''
+);
+
 /* Mime kept here incase I want to store images as pngs with transparency */
 CREATE TABLE profile_pictures (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
@@ -167,4 +531,36 @@ CREATE INDEX idx_role ON users (role);
 
 CREATE INDEX idx_friends ON users USING gin (friends);
 
-CREATE INDEX idx_blocked ON users USING gin (blocked);
\ No newline at end of file
+CREATE INDEX idx_blocked ON users USING gin (blocked);
+
+CREATE INDEX idx_bookmarks_message ON bookmarks (message_id);
+
+CREATE INDEX idx_polls_closes_at ON polls (closes_at) WHERE closed = FALSE;
+
+CREATE INDEX idx_content_filter_matches_room ON content_filter_matches (room_id, created_at);
+
+CREATE INDEX idx_room_messages_channel_created_at ON room_messages (room_channel_id, created_at);
+
+CREATE INDEX idx_room_channels_room_position ON room_channels (room_id, position);
+
+CREATE INDEX idx_member_roles_room_user ON member_roles (room_id, user_id);
+
+CREATE UNIQUE INDEX idx_channel_overrides_target ON channel_overrides (channel_id, COALESCE(user_id, role_id, channel_id));
+
+CREATE INDEX idx_bans_expires_at ON bans (expires_at) WHERE expires_at IS NOT NULL;
+
+CREATE INDEX idx_mutes_expires_at ON mutes (expires_at) WHERE expires_at IS NOT NULL;
+
+CREATE INDEX idx_room_audit_log_room_created_at ON room_audit_log (room_id, created_at);
+
+CREATE INDEX idx_reports_status_created_at ON reports (status, created_at);
+
+CREATE INDEX idx_reports_target ON reports (target_id);
+
+CREATE INDEX idx_room_invites_room_id ON room_invites (room_id);
+
+CREATE INDEX idx_invitations_inviter ON invitations (inviter);
+
+CREATE INDEX idx_invitations_invited ON invitations (invited);
+
+CREATE INDEX idx_invitations_expires_at ON invitations (expires_at);
\ No newline at end of file